@@ -1,19 +1,19 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
 	"time"
 
-	fmongo "github.com/andy-zhangtao/Functions/service/f_mongo"
-	fweaviate "github.com/andy-zhangtao/Functions/service/f_weaviate"
+	"github.com/andy-zhangtao/Functions/driver"
+	"github.com/andy-zhangtao/Functions/events"
 	"github.com/andy-zhangtao/Functions/tools/flogs"
 	"github.com/andy-zhangtao/Functions/types"
 	"github.com/sirupsen/logrus"
-	"github.com/weaviate/weaviate-go-client/v4/weaviate/data"
 )
 
 // DirayCreate create a new diary
@@ -22,13 +22,54 @@ import (
 // @Tags diary
 // @Accept  json
 // @Produce  json
-func DirayCreate(data string) (dcm types.DirayCreateModel, object *data.ObjectWrapper, err error) {
+func DirayCreate(ctx context.Context, data string, repo driver.DiaryRepository) (dcm types.DirayCreateModel, id string, err error) {
+	dcm, err = parseDiary(data)
+	if err != nil {
+		return dcm, id, err
+	}
+
+	id, err = repo.SaveDiary(ctx, dcm.Diary(), nil)
+	if err != nil {
+		logrus.Errorf("Error creating weaviate record: %v", err)
+		return dcm, id, fmt.Errorf("error creating weaviate record: %v", err)
+	}
+
+	return dcm, id, nil
+}
+
+// DiaryUpdate replaces the diary of dcm.ID in the vector store and the archive
+func DiaryUpdate(ctx context.Context, data string, repos *driver.Repositories) (dcm types.DirayCreateModel, err error) {
+	dcm, err = parseDiary(data)
+	if err != nil {
+		return dcm, err
+	}
+
+	if dcm.ID == "" {
+		return dcm, fmt.Errorf("error parsing request body: id is empty")
+	}
+
+	err = repos.Diary.UpdateDiary(ctx, dcm.ID, dcm.Diary())
+	if err != nil {
+		logrus.Errorf("Error updating weaviate record %s: %v", dcm.ID, err)
+		return dcm, fmt.Errorf("error updating weaviate record: %w", err)
+	}
 
-	// var dcm types.DirayCreateModel
+	// the diaries saved before the archive existed have no copy in it
+	err = repos.DiaryArchive.UpdateDiary(ctx, dcm.ID, dcm.Diary())
+	if err != nil && !errors.Is(err, driver.ErrNotFound) {
+		logrus.Errorf("Error updating mongo record %s: %v", dcm.ID, err)
+		return dcm, fmt.Errorf("error updating mongo record: %w", err)
+	}
+
+	return dcm, nil
+}
+
+// parseDiary parses and checks the create or update request
+func parseDiary(data string) (dcm types.DirayCreateModel, err error) {
 	err = json.Unmarshal([]byte(data), &dcm)
 	if err != nil {
 		logrus.Errorf("Error parsing request body: %v", err)
-		return dcm, object, err
+		return dcm, err
 	}
 
 	if dcm.Version == "" {
@@ -45,49 +86,34 @@ func DirayCreate(data string) (dcm types.DirayCreateModel, object *data.ObjectWr
 	t, err := time.Parse("2006-01-02", dcm.Date)
 	if err != nil {
 		logrus.Errorf("Error parsing request body: %v", err)
-		return dcm, object, fmt.Errorf("error parsing request body: %v", err)
+		return dcm, fmt.Errorf("error parsing request body: %v", err)
 	}
 
 	dcm.DateSave = t
 
-	wc, err := fweaviate.NewWeaviateClient(os.Getenv(types.EnvWeaviateHost), os.Getenv(types.EnvWeaviateSchema), os.Getenv(types.EnvWewaviateKey))
-	if err != nil {
-		logrus.Errorf("Error creating weaviate client: %v", err)
-		return dcm, object, fmt.Errorf("error creating weaviate client: %v", err)
-	}
-
 	switch dcm.Version {
 	case types.RequestVersionV1:
 		err = checkV1(dcm)
 		if err != nil {
 			logrus.Errorf("Error parsing request body: %v", err)
-			return dcm, object, fmt.Errorf("error parsing request body: %v", err)
+			return dcm, fmt.Errorf("error parsing request body: %v", err)
 		}
 
-		object, err = wc.AddNewRecord(types.DiaryClassName, map[string]interface{}{
-			"user":    dcm.User,
-			"content": dcm.Body,
-			"date":    dcm.DateSave.Unix(),
-		})
-		if err != nil {
-			logrus.Errorf("Error creating weaviate record: %v", err)
-			return dcm, object, fmt.Errorf("error creating weaviate record: %v", err)
-		}
-
-		return dcm, object, nil
+		return dcm, nil
 	default:
 		logrus.Errorf("Not support version: %v", dcm.Version)
-		return dcm, object, fmt.Errorf("not support version: %v", dcm.Version)
+		return dcm, fmt.Errorf("not support version: %v", dcm.Version)
 	}
 }
 
-// DirayCreateHandler handle the diary create request
-// @Summary create a new diary
-// First save the diary to weaviate, then save the diary to mongo
+// DirayCreateHandler handle the diary create and update request
+// @Summary create a new diary or update a diary
+// POST first saves the diary to weaviate, then saves the diary to mongo, then publishes diary.created.
+// PUT replaces the diary of the id in weaviate and mongo, then publishes diary.updated.
 func DirayCreateHandler(w http.ResponseWriter, r *http.Request) {
 
-	// chech the http method , only allow POST method
-	if r.Method != "POST" {
+	// chech the http method , only allow POST and PUT method
+	if r.Method != "POST" && r.Method != "PUT" {
 		http.Error(w, "Method is not supported.", http.StatusNotFound)
 		return
 	}
@@ -101,7 +127,19 @@ func DirayCreateHandler(w http.ResponseWriter, r *http.Request) {
 
 	flogs.Infof("request body: %v", string(data))
 
-	dcm, object, err := DirayCreate(string(data))
+	repos, err := driver.NewRepositoriesFromEnv()
+	if err != nil {
+		flogs.Errorf("Error creating repositories: %v", err)
+		errorResponse(w, err)
+		return
+	}
+
+	if r.Method == "PUT" {
+		diaryUpdate(w, r, string(data), repos)
+		return
+	}
+
+	dcm, id, err := DirayCreate(r.Context(), string(data), repos.Diary)
 	if err != nil {
 		flogs.Errorf("Error parsing request body: %v", err)
 		errorResponse(w, err)
@@ -109,21 +147,63 @@ func DirayCreateHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	dcm.Mask = map[string]interface{}{
-		"weaviate": object.Object.ID.String(),
+		"weaviate": id,
 	}
 
-	err = createMongoRecord(dcm)
+	err = createMongoRecord(r.Context(), dcm, repos.DiaryArchive)
 	if err != nil {
 		flogs.Errorf("Error creating mongo record: %v", err)
 		errorResponse(w, err)
 		return
 	}
+
+	events.Shared().Publish(diaryEvent(types.EventDiaryCreated, id, dcm))
+
+	commonResponse(w, http.StatusOK, types.DirayCreateResponse{
+		Code: http.StatusOK,
+		Msg:  id,
+	})
+}
+
+func diaryUpdate(w http.ResponseWriter, r *http.Request, data string, repos *driver.Repositories) {
+	dcm, err := DiaryUpdate(r.Context(), data, repos)
+	if errors.Is(err, driver.ErrNotFound) {
+		commonResponse(w, http.StatusNotFound, types.DirayCreateResponse{
+			Code:    http.StatusNotFound,
+			Msg:     fmt.Sprintf("diary %s not found", dcm.ID),
+			Version: types.RequestVersionDefault,
+		})
+		return
+	}
+	if err != nil {
+		flogs.Errorf("Error updating diary: %v", err)
+		errorResponse(w, err)
+		return
+	}
+
+	events.Shared().Publish(diaryEvent(types.EventDiaryUpdated, dcm.ID, dcm))
+
 	commonResponse(w, http.StatusOK, types.DirayCreateResponse{
 		Code: http.StatusOK,
-		Msg:  object.Object.ID.String(),
+		Msg:  dcm.ID,
 	})
 }
 
+// diaryEvent is the event of the diary, diary.created and diary.updated have the same payload
+func diaryEvent(event, id string, dcm types.DirayCreateModel) types.Event {
+	return types.Event{
+		Type: event,
+		User: dcm.User,
+		Payload: map[string]interface{}{
+			"id":    id,
+			"title": dcm.Title,
+			"body":  dcm.Body,
+			"tags":  dcm.Tags,
+			"date":  dcm.Date,
+		},
+	}
+}
+
 // checkV1 check the request body for v1
 func checkV1(dcm types.DirayCreateModel) error {
 	if dcm.User == "" {
@@ -158,17 +238,10 @@ func commonResponse(w http.ResponseWriter, code int, data types.DirayCreateRespo
 // 	return nil
 // }
 
-func createMongoRecord(dcm types.DirayCreateModel) error {
+func createMongoRecord(ctx context.Context, dcm types.DirayCreateModel, repo driver.DiaryRepository) error {
 	flogs.Infof("createMongoRecord: %v", dcm)
-	uri := os.Getenv(types.EnvMONGOHOST)
-	db := os.Getenv(types.EnvMONGODB)
-	collection := os.Getenv(types.EnvMONGOCOLLECTION)
-
-	flogs.Infof("uri: %s db: %s collection: %s", uri, db, collection)
-	cli, err := fmongo.NewMongoCli(uri, db, collection)
-	if err != nil {
-		return fmt.Errorf("create mongo client error: %w", err)
-	}
 
-	return cli.SaveDataToMongo(dcm, map[string]interface{}{})
+	mask, _ := dcm.Mask.(map[string]interface{})
+	_, err := repo.SaveDiary(ctx, dcm.Diary(), mask)
+	return err
 }
@@ -0,0 +1,148 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/andy-zhangtao/Functions/driver"
+	"github.com/andy-zhangtao/Functions/events"
+	"github.com/andy-zhangtao/Functions/types"
+)
+
+// memoryRepositories makes the handlers use empty in-memory repositories during the test
+func memoryRepositories(t *testing.T) *driver.Repositories {
+	t.Setenv(types.EnvStorageBackend, types.StorageBackendMemory)
+	driver.ResetMemoryRepositories()
+	t.Cleanup(driver.ResetMemoryRepositories)
+	return driver.SharedMemoryRepositories()
+}
+
+func TestDirayCreateHandler(t *testing.T) {
+	repos := memoryRepositories(t)
+
+	body := `{"user":"diary-tester","title":"memory","body":"finish the memory store","date":"2023-08-01","tags":["dev"]}`
+	w := httptest.NewRecorder()
+	DirayCreateHandler(w, httptest.NewRequest(http.MethodPost, "/v1/diary", strings.NewReader(body)))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("code = %d, body = %s", w.Code, w.Body.String())
+	}
+
+	var res types.DirayCreateResponse
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatalf("decode response error: %v", err)
+	}
+
+	if res.Msg == "" {
+		t.Error("response should contain the diary id")
+	}
+
+	query := types.DirayQueryModel{User: "diary-tester", Keys: []string{"memory store"}, MaxDistance: new(float32)}
+	*query.MaxDistance = 0.9
+
+	for name, repo := range map[string]driver.DiaryRepository{"vector": repos.Diary, "archive": repos.DiaryArchive} {
+		found, err := repo.QueryDiary(context.Background(), query)
+		if err != nil {
+			t.Fatalf("%s QueryDiary error: %v", name, err)
+		}
+
+		if len(found.Results) != 1 || found.Results[0].Content != "finish the memory store" {
+			t.Errorf("%s diaries = %+v", name, found.Results)
+		}
+	}
+}
+
+func TestDirayCreateHandlerInvalid(t *testing.T) {
+	memoryRepositories(t)
+
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		code   int
+	}{
+		{"method", http.MethodGet, "", http.StatusNotFound},
+		{"user", http.MethodPost, `{"body":"no user"}`, http.StatusBadRequest},
+		{"date", http.MethodPost, `{"user":"u","body":"b","date":"2023/08/01"}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			DirayCreateHandler(w, httptest.NewRequest(tt.method, "/v1/diary", strings.NewReader(tt.body)))
+			if w.Code != tt.code {
+				t.Errorf("code = %d, want %d", w.Code, tt.code)
+			}
+		})
+	}
+}
+
+func TestDirayUpdateHandler(t *testing.T) {
+	repos := memoryRepositories(t)
+
+	var mu sync.Mutex
+	var updated []types.Event
+	events.Shared().Subscribe(types.EventDiaryUpdated, func(ctx context.Context, event types.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		if event.User == "diary-updater" {
+			updated = append(updated, event)
+		}
+	})
+
+	body := `{"user":"diary-updater","title":"draft","body":"write the draft","date":"2023-08-01"}`
+	w := httptest.NewRecorder()
+	DirayCreateHandler(w, httptest.NewRequest(http.MethodPost, "/v1/diary", strings.NewReader(body)))
+
+	var created types.DirayCreateResponse
+	if err := json.NewDecoder(w.Body).Decode(&created); err != nil || created.Msg == "" {
+		t.Fatalf("create diary: code = %d, error = %v", w.Code, err)
+	}
+
+	tests := []struct {
+		name string
+		body string
+		code int
+	}{
+		{"update", `{"id":"` + created.Msg + `","user":"diary-updater","title":"final","body":"publish the final","date":"2023-08-02"}`, http.StatusOK},
+		{"other user", `{"id":"` + created.Msg + `","user":"someone","body":"overwrite"}`, http.StatusNotFound},
+		{"unknown id", `{"id":"404","user":"diary-updater","body":"missing"}`, http.StatusNotFound},
+		{"no id", `{"user":"diary-updater","body":"no id"}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			DirayCreateHandler(w, httptest.NewRequest(http.MethodPut, "/v1/diary", strings.NewReader(tt.body)))
+			if w.Code != tt.code {
+				t.Errorf("code = %d, want %d, body = %s", w.Code, tt.code, w.Body.String())
+			}
+		})
+	}
+
+	if err := events.Shared().Wait(context.Background()); err != nil {
+		t.Fatalf("Wait error: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(updated) != 1 || updated[0].Payload["id"] != created.Msg || updated[0].Payload["body"] != "publish the final" {
+		t.Errorf("diary.updated events = %+v", updated)
+	}
+
+	for name, repo := range map[string]driver.DiaryRepository{"vector": repos.Diary, "archive": repos.DiaryArchive} {
+		found, err := repo.QueryDiary(context.Background(), types.DirayQueryModel{User: "diary-updater"})
+		if err != nil {
+			t.Fatalf("%s QueryDiary error: %v", name, err)
+		}
+
+		if len(found.Results) != 1 || found.Results[0].Title != "final" || found.Results[0].Content != "publish the final" {
+			t.Errorf("%s diaries = %+v", name, found.Results)
+		}
+	}
+}
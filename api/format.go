@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/andy-zhangtao/Functions/driver"
+	fformat "github.com/andy-zhangtao/Functions/service/f_format"
+	"github.com/andy-zhangtao/Functions/tools/flogs"
+	"github.com/andy-zhangtao/Functions/types"
+)
+
+// FormatHandler handle the format template request
+// @Summary add, query, update, delete or list the format templates
+// The action of the request is one of types.AddAction, QueryAction, UpdateAction, DeleteAction and ListAction
+// @Tags format
+// @Accept  json
+// @Produce  json
+func FormatHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method is not supported.", http.StatusNotFound)
+		return
+	}
+
+	var fm fformat.FormatModel
+	err := json.NewDecoder(r.Body).Decode(&fm)
+	if err != nil {
+		flogs.Errorf("Error parsing request body: %v", err)
+		formatResponse(w, http.StatusBadRequest, fformat.FormatResponse{Code: http.StatusBadRequest, Msg: err.Error()})
+		return
+	}
+
+	flogs.Infof("format request: %+v", fm)
+
+	if fm.User == "" {
+		formatResponse(w, http.StatusBadRequest, fformat.FormatResponse{Code: http.StatusBadRequest, Msg: "user is empty"})
+		return
+	}
+
+	formats, err := formatAction(r.Context(), &fm)
+	if err == fformat.ErrNotFound {
+		formatResponse(w, http.StatusNotFound, fformat.FormatResponse{Code: http.StatusNotFound, Msg: err.Error()})
+		return
+	}
+	if err != nil {
+		flogs.Errorf("Error handling format: %v", err)
+		formatResponse(w, http.StatusBadRequest, fformat.FormatResponse{Code: http.StatusBadRequest, Msg: err.Error()})
+		return
+	}
+
+	formatResponse(w, http.StatusOK, fformat.FormatResponse{Code: http.StatusOK, Formats: formats})
+}
+
+func formatAction(ctx context.Context, fm *fformat.FormatModel) ([]*fformat.FormatModel, error) {
+	repos, err := driver.NewRepositoriesFromEnv()
+	if err != nil {
+		return nil, fmt.Errorf("create repositories error: %w", err)
+	}
+	cli := repos.Format
+
+	switch fm.Action {
+	case types.ListAction:
+		return cli.ListFormat(ctx, fm.User, fm.Tags)
+	case types.DeleteAction:
+		return nil, cli.FormatAction(ctx, fm)
+	default:
+		if err := cli.FormatAction(ctx, fm); err != nil {
+			return nil, err
+		}
+		return []*fformat.FormatModel{fm}, nil
+	}
+}
+
+func formatResponse(w http.ResponseWriter, code int, data fformat.FormatResponse) {
+	if data.Version == "" {
+		data.Version = types.RequestVersionDefault
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	json.NewEncoder(w).Encode(data)
+}
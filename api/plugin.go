@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/andy-zhangtao/Functions/driver"
+	fplugin "github.com/andy-zhangtao/Functions/service/f_plugin"
+	"github.com/andy-zhangtao/Functions/tools/flogs"
+	"github.com/andy-zhangtao/Functions/types"
+)
+
+// PluginHandler handle the plugin catalog request
+// @Summary add, query, update, version, delete or list the plugins
+// The action of the request is one of types.AddAction, QueryAction, UpdateAction, VersionAction, DeleteAction and ListAction
+// @Tags plugin
+// @Accept  json
+// @Produce  json
+func PluginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method is not supported.", http.StatusNotFound)
+		return
+	}
+
+	var req types.PluginRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		flogs.Errorf("Error parsing request body: %v", err)
+		pluginResponse(w, http.StatusBadRequest, types.PluginResponse{Code: http.StatusBadRequest, Msg: err.Error()})
+		return
+	}
+
+	flogs.Infof("plugin request: %+v", req)
+
+	plugins, err := pluginAction(r.Context(), req)
+	if errors.Is(err, driver.ErrNotFound) {
+		pluginResponse(w, http.StatusNotFound, types.PluginResponse{Code: http.StatusNotFound, Msg: err.Error()})
+		return
+	}
+	if err != nil {
+		flogs.Errorf("Error handling plugin: %v", err)
+		pluginResponse(w, http.StatusBadRequest, types.PluginResponse{Code: http.StatusBadRequest, Msg: err.Error()})
+		return
+	}
+
+	pluginResponse(w, http.StatusOK, types.PluginResponse{Code: http.StatusOK, Plugins: plugins})
+}
+
+func pluginAction(ctx context.Context, req types.PluginRequest) ([]types.Plugin, error) {
+	repos, err := driver.NewRepositoriesFromEnv()
+	if err != nil {
+		return nil, fmt.Errorf("create repositories error: %w", err)
+	}
+
+	return fplugin.NewPluginClient(repos.Plugin).Action(ctx, req)
+}
+
+func pluginResponse(w http.ResponseWriter, code int, data types.PluginResponse) {
+	if data.Version == "" {
+		data.Version = types.RequestVersionDefault
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	json.NewEncoder(w).Encode(data)
+}
@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andy-zhangtao/Functions/types"
+)
+
+func TestPluginHandler(t *testing.T) {
+	memoryRepositories(t)
+
+	do := func(req types.PluginRequest) (int, types.PluginResponse) {
+		body, _ := json.Marshal(req)
+		w := httptest.NewRecorder()
+		PluginHandler(w, httptest.NewRequest(http.MethodPost, "/v1/plugin", bytes.NewReader(body)))
+
+		var res types.PluginResponse
+		if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+			t.Fatalf("decode response error: %v", err)
+		}
+		return w.Code, res
+	}
+
+	input := []types.PluginIO{{Name: "action", Value: types.PluginType{Type: "string"}}}
+
+	_, res := do(types.PluginRequest{Action: types.AddAction, Plugin: &types.Plugin{Name: "store", Module: "catalog", Input: input}})
+	if len(res.Plugins) != 1 || res.Plugins[0].PluginKey == 0 || res.Plugins[0].Version != 1 {
+		t.Fatalf("create response = %+v", res)
+	}
+	store := res.Plugins[0].PluginKey
+
+	_, res = do(types.PluginRequest{Action: types.AddAction, Plugin: &types.Plugin{
+		Name: "gpt", Module: "catalog", Reference: types.PluginReference{Up: types.PluginReferenceStart, Down: []int{store}},
+	}})
+	if len(res.Plugins) != 1 || res.Plugins[0].PluginKey == store {
+		t.Fatalf("create response = %+v", res)
+	}
+	gpt := res.Plugins[0].PluginKey
+
+	tests := []struct {
+		name    string
+		req     types.PluginRequest
+		code    int
+		version int
+		plugins int
+	}{
+		{name: "duplicated input", req: types.PluginRequest{Action: types.AddAction, Plugin: &types.Plugin{Name: "bad", Input: append(input, input...)}}, code: http.StatusBadRequest},
+		{name: "unsupported type", req: types.PluginRequest{Action: types.AddAction, Plugin: &types.Plugin{Name: "bad", Input: []types.PluginIO{{Name: "a", Value: types.PluginType{Type: "map"}}}}}, code: http.StatusBadRequest},
+		{name: "unknown down", req: types.PluginRequest{Action: types.AddAction, Plugin: &types.Plugin{Name: "bad", Reference: types.PluginReference{Down: []int{9999}}}}, code: http.StatusBadRequest},
+		{name: "cycle", req: types.PluginRequest{Action: types.UpdateAction, PluginKey: store, Plugin: &types.Plugin{Name: "store", Reference: types.PluginReference{Down: []int{gpt}}}}, code: http.StatusBadRequest},
+		{name: "update", req: types.PluginRequest{Action: types.UpdateAction, PluginKey: store, Plugin: &types.Plugin{Name: "store", Module: "catalog", Descript: "updated", Input: input}}, code: http.StatusOK, version: 2, plugins: 1},
+		{name: "version", req: types.PluginRequest{Action: types.VersionAction, PluginKey: store, Plugin: &types.Plugin{Name: "store", Module: "catalog", Descript: "v3"}}, code: http.StatusOK, version: 3, plugins: 1},
+		{name: "get latest", req: types.PluginRequest{Action: types.QueryAction, PluginKey: store}, code: http.StatusOK, version: 3, plugins: 1},
+		{name: "get version", req: types.PluginRequest{Action: types.QueryAction, PluginKey: store, Version: 1}, code: http.StatusOK, version: 1, plugins: 1},
+		{name: "rollback", req: types.PluginRequest{Action: types.RollbackAction, PluginKey: store, Version: 1}, code: http.StatusOK, version: 4, plugins: 1},
+		{name: "rollback unknown version", req: types.PluginRequest{Action: types.RollbackAction, PluginKey: store, Version: 99}, code: http.StatusNotFound},
+		{name: "list", req: types.PluginRequest{Action: types.ListAction, Module: "catalog"}, code: http.StatusOK, plugins: 2},
+		{name: "delete", req: types.PluginRequest{Action: types.DeleteAction, PluginKey: gpt}, code: http.StatusOK},
+		{name: "get deleted", req: types.PluginRequest{Action: types.QueryAction, PluginKey: gpt}, code: http.StatusNotFound},
+		{name: "update unknown", req: types.PluginRequest{Action: types.UpdateAction, PluginKey: 9999, Plugin: &types.Plugin{Name: "x"}}, code: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, res := do(tt.req)
+			if code != tt.code || len(res.Plugins) != tt.plugins {
+				t.Fatalf("code = %d, plugins = %d (%s), want %d and %d", code, len(res.Plugins), res.Msg, tt.code, tt.plugins)
+			}
+			if tt.version > 0 && res.Plugins[0].Version != tt.version {
+				t.Errorf("version = %d, want %d", res.Plugins[0].Version, tt.version)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/andy-zhangtao/Functions/driver"
+	freport "github.com/andy-zhangtao/Functions/service/f_report"
+	"github.com/andy-zhangtao/Functions/tools/flogs"
+	"github.com/andy-zhangtao/Functions/types"
+)
+
+// ReportHandler generate the work report of a date range
+// @Summary generate a markdown report from the diaries
+// @Tags report
+// @Accept  json
+// @Produce  json
+func ReportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method is not supported.", http.StatusNotFound)
+		return
+	}
+
+	var req types.ReportRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		flogs.Errorf("Error parsing request body: %v", err)
+		reportResponse(w, http.StatusBadRequest, types.ReportResponse{Code: http.StatusBadRequest, Msg: err.Error()})
+		return
+	}
+
+	flogs.Infof("report request: %+v", req)
+
+	report, err := generateReport(r.Context(), req)
+	if err != nil {
+		flogs.Errorf("Error generating report: %v", err)
+
+		// the diary store and gpt errors are not the errors of the request
+		code := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, freport.ErrInvalidRequest):
+			code = http.StatusBadRequest
+		case errors.Is(err, freport.ErrNoDiary):
+			code = http.StatusNotFound
+		case errors.Is(err, context.DeadlineExceeded):
+			code = http.StatusGatewayTimeout
+		}
+		reportResponse(w, code, types.ReportResponse{Code: code, Msg: err.Error()})
+		return
+	}
+
+	reportResponse(w, http.StatusOK, types.ReportResponse{Code: http.StatusOK, Report: report})
+}
+
+func generateReport(ctx context.Context, req types.ReportRequest) (string, error) {
+	repos, err := driver.NewRepositoriesFromEnv()
+	if err != nil {
+		return "", fmt.Errorf("create repositories error: %w", err)
+	}
+
+	chunkSize, _ := strconv.Atoi(os.Getenv(types.EnvReportChunkSize))
+
+	rc := freport.NewReportClient(repos.Diary, repos.Format, freport.ReportConfig{
+		Url:       types.GPTURL(),
+		SKey:      os.Getenv(types.PluginGPTSKey),
+		Model:     os.Getenv(types.EnvReportModel),
+		ChunkSize: chunkSize,
+	})
+
+	return rc.Generate(ctx, req)
+}
+
+func reportResponse(w http.ResponseWriter, code int, data types.ReportResponse) {
+	if data.Version == "" {
+		data.Version = types.RequestVersionDefault
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	json.NewEncoder(w).Encode(data)
+}
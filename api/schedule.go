@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/andy-zhangtao/Functions/driver"
+	"github.com/andy-zhangtao/Functions/scheduler"
+	"github.com/andy-zhangtao/Functions/tools/flogs"
+	"github.com/andy-zhangtao/Functions/types"
+)
+
+// ScheduleHandler handle the schedule request
+// @Summary add, query, update, delete or list the cron schedules of the workflows, or list the runs of a schedule
+// The action of the request is one of types.AddAction, QueryAction, UpdateAction, DeleteAction, ListAction and HistoryAction
+// @Tags schedule
+// @Accept  json
+// @Produce  json
+func ScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method is not supported.", http.StatusNotFound)
+		return
+	}
+
+	var req types.ScheduleRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		flogs.Errorf("Error parsing request body: %v", err)
+		scheduleResponse(w, http.StatusBadRequest, types.ScheduleResponse{Code: http.StatusBadRequest, Msg: err.Error()})
+		return
+	}
+
+	flogs.Infof("schedule request: %+v", req)
+
+	schedules, runs, err := scheduleAction(r.Context(), req)
+	if errors.Is(err, driver.ErrNotFound) {
+		scheduleResponse(w, http.StatusNotFound, types.ScheduleResponse{Code: http.StatusNotFound, Msg: err.Error()})
+		return
+	}
+	if err != nil {
+		flogs.Errorf("Error handling schedule: %v", err)
+		scheduleResponse(w, http.StatusBadRequest, types.ScheduleResponse{Code: http.StatusBadRequest, Msg: err.Error()})
+		return
+	}
+
+	scheduleResponse(w, http.StatusOK, types.ScheduleResponse{Code: http.StatusOK, Schedules: schedules, Runs: runs})
+}
+
+func scheduleAction(ctx context.Context, req types.ScheduleRequest) ([]types.Schedule, []types.ScheduleRun, error) {
+	repos, err := driver.NewRepositoriesFromEnv()
+	if err != nil {
+		return nil, nil, fmt.Errorf("create repositories error: %w", err)
+	}
+
+	return scheduler.NewService(repos).Action(ctx, req)
+}
+
+func scheduleResponse(w http.ResponseWriter, code int, data types.ScheduleResponse) {
+	if data.Version == "" {
+		data.Version = types.RequestVersionDefault
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	json.NewEncoder(w).Encode(data)
+}
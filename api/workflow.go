@@ -2,10 +2,9 @@ package handler
 
 import (
 	"net/http"
-	"os"
 
+	"github.com/andy-zhangtao/Functions/driver"
 	traceid "github.com/andy-zhangtao/Functions/tools/trace_id"
-	"github.com/andy-zhangtao/Functions/types"
 	"github.com/andy-zhangtao/Functions/workflow"
 	"github.com/sirupsen/logrus"
 )
@@ -15,19 +14,15 @@ func WorkFlowHandler(w http.ResponseWriter, r *http.Request) {
 	// Initialize MongoDB store
 	traceId := traceid.ID()
 
-	mongoStore := workflow.NewMongoStore(
-		os.Getenv(types.EnvMONGOHOST),
-		os.Getenv(types.EnvMONGODB),
-		traceId,
-	)
-
-	if mongoStore == nil {
-		w.Write([]byte("mongoStore is nil"))
-		w.WriteHeader(http.StatusInternalServerError)
+	repos, err := driver.NewRepositoriesFromEnv()
+	if err != nil {
+		logrus.Errorf("WorkFlowHandler with %s create repositories error: %v", traceId, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+
 	// Initialize WorkFlowService
-	service := workflow.NewWorkFlowService(mongoStore, traceId)
+	service := workflow.NewWorkFlowService(repos, traceId)
 
 	logrus.Infof("WorkFlowHandler with %s", traceId)
 
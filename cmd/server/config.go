@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/andy-zhangtao/Functions/types"
+	"github.com/pkg/errors"
+)
+
+const (
+	EnvServerAddr        = "SERVER_ADDR"
+	EnvServerConfig      = "SERVER_CONFIG"
+	EnvServerShutdown    = "SERVER_SHUTDOWN_TIMEOUT"
+	EnvSchedulerInterval = "SCHEDULER_INTERVAL"
+)
+
+// Config is the config of the server.
+// The values are loaded from the config file first, then overridden by the env.
+type Config struct {
+	Addr string `json:"addr"`
+	// ShutdownTimeout is the seconds to wait for the running requests when shutting down
+	ShutdownTimeout int `json:"shutdown_timeout"`
+	// SchedulerInterval is the seconds between the checks of the due schedules, a negative value disables the scheduler
+	SchedulerInterval int `json:"scheduler_interval"`
+
+	MongoHost             string `json:"mongo_host"`
+	MongoDB               string `json:"mongo_db"`
+	MongoCollection       string `json:"mongo_collection"`
+	MongoFormatCollection string `json:"mongo_format_collection"`
+	MongoMaxPoolSize      string `json:"mongo_max_pool_size"`
+	MongoMinPoolSize      string `json:"mongo_min_pool_size"`
+
+	WeaviateHost   string `json:"weaviate_host"`
+	WeaviateSchema string `json:"weaviate_schema"`
+	WeaviateKey    string `json:"weaviate_key"`
+	// WeaviateMaxIdleConns is the max idle http connections kept to weaviate
+	WeaviateMaxIdleConns string `json:"weaviate_max_idle_conns"`
+
+	GPTSKey string `json:"gpt_skey"`
+	GPTURL  string `json:"gpt_url"`
+
+	// StorageBackend is memory to run without mongo and weaviate
+	StorageBackend string `json:"storage_backend"`
+}
+
+// LoadConfig loads the config from file (if not empty) and the env
+func LoadConfig(file string) (*Config, error) {
+	c := &Config{
+		Addr:              ":8080",
+		ShutdownTimeout:   10,
+		SchedulerInterval: 30,
+	}
+
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "read config file [%s] error", file)
+		}
+
+		if err = json.Unmarshal(data, c); err != nil {
+			return nil, errors.WithMessagef(err, "parse config file [%s] error", file)
+		}
+	}
+
+	for env, field := range c.envs() {
+		if v := os.Getenv(env); v != "" {
+			*field = v
+		}
+	}
+
+	if v := os.Getenv(EnvServerShutdown); v != "" {
+		timeout, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "invalid %s [%s]", EnvServerShutdown, v)
+		}
+		c.ShutdownTimeout = timeout
+	}
+
+	if v := os.Getenv(EnvSchedulerInterval); v != "" {
+		interval, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "invalid %s [%s]", EnvSchedulerInterval, v)
+		}
+		c.SchedulerInterval = interval
+	}
+
+	return c, nil
+}
+
+// Export writes the config back to the env, the handlers read their config from the env
+func (c *Config) Export() {
+	for env, field := range c.envs() {
+		if *field != "" {
+			os.Setenv(env, *field)
+		}
+	}
+}
+
+func (c *Config) Shutdown() time.Duration {
+	return time.Duration(c.ShutdownTimeout) * time.Second
+}
+
+// Interval returns the scheduler interval, 0 if the scheduler is disabled
+func (c *Config) Interval() time.Duration {
+	if c.SchedulerInterval < 0 {
+		return 0
+	}
+	return time.Duration(c.SchedulerInterval) * time.Second
+}
+
+func (c *Config) envs() map[string]*string {
+	return map[string]*string{
+		EnvServerAddr:                  &c.Addr,
+		types.EnvMONGOHOST:             &c.MongoHost,
+		types.EnvMONGODB:               &c.MongoDB,
+		types.EnvMONGOCOLLECTION:       &c.MongoCollection,
+		types.EnvMONGOFORMATCOLLECTION: &c.MongoFormatCollection,
+		types.EnvMONGOMAXPOOLSIZE:      &c.MongoMaxPoolSize,
+		types.EnvMONGOMINPOOLSIZE:      &c.MongoMinPoolSize,
+		types.EnvWeaviateHost:          &c.WeaviateHost,
+		types.EnvWeaviateSchema:        &c.WeaviateSchema,
+		types.EnvWewaviateKey:          &c.WeaviateKey,
+		types.EnvWeaviateMaxIdleConns:  &c.WeaviateMaxIdleConns,
+		types.PluginGPTSKey:            &c.GPTSKey,
+		types.PluginGPTURL:             &c.GPTURL,
+		types.EnvStorageBackend:        &c.StorageBackend,
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/andy-zhangtao/Functions/driver"
+	"github.com/andy-zhangtao/Functions/events"
+	"github.com/andy-zhangtao/Functions/scheduler"
+	"github.com/andy-zhangtao/Functions/tools/flogs"
+	traceid "github.com/andy-zhangtao/Functions/tools/trace_id"
+	"github.com/andy-zhangtao/Functions/types"
+	"github.com/andy-zhangtao/Functions/workflow"
+)
+
+func main() {
+	file := flag.String("config", os.Getenv(EnvServerConfig), "the path of the json config file")
+	migrate := flag.Bool("migrate", false, "convert the legacy mongo documents and exit")
+	load := flag.String("import", "", "validate and upsert the plugins and workflows of the .yaml, .yml or .json definition file and exit")
+	dryRun := flag.Bool("dry-run", false, "print the changes of -import without saving them")
+	export := flag.String("export", "", "print the definition of the workflows of the user and their plugins and exit")
+	format := flag.String("format", types.DefinitionYAML, "the format of -export, yaml or json")
+	flag.Parse()
+
+	c, err := LoadConfig(*file)
+	if err != nil {
+		flogs.Errorf("load config error: %v", err)
+		os.Exit(1)
+	}
+	c.Export()
+
+	if *migrate {
+		if err := runMigrations(c); err != nil {
+			flogs.Errorf("migrate error: %v", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *load != "" {
+		if err := importDefinition(*load, *dryRun); err != nil {
+			flogs.Errorf("import %s error: %v", *load, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *export != "" {
+		if err := exportDefinition(*export, *format); err != nil {
+			flogs.Errorf("export %s error: %v", *export, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	srv := &http.Server{
+		Addr:    c.Addr,
+		Handler: NewRouter(c),
+	}
+
+	stopScheduler := startScheduler(c)
+	listenEvents()
+
+	go func() {
+		flogs.Infof("server listen on %s", c.Addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			flogs.Errorf("server listen error: %v", err)
+			os.Exit(1)
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+
+	flogs.Infof("server shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), c.Shutdown())
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		flogs.Errorf("server shutdown error: %v", err)
+	}
+	stopScheduler()
+	if err := events.Shared().Wait(ctx); err != nil {
+		flogs.Errorf("wait event handlers error: %v", err)
+	}
+
+	if err := driver.Close(ctx); err != nil {
+		flogs.Errorf("close clients error: %v", err)
+		os.Exit(1)
+	}
+
+	flogs.Infof("server exited")
+}
+
+// startScheduler runs the scheduler in background, the returned func stops it and waits until it exits
+func startScheduler(c *Config) func() {
+	if c.Interval() == 0 {
+		flogs.Infof("scheduler is disabled")
+		return func() {}
+	}
+
+	repos, err := driver.NewRepositoriesFromEnv()
+	if err != nil {
+		flogs.Errorf("create scheduler repositories error: %v", err)
+		os.Exit(1)
+	}
+
+	host, _ := os.Hostname()
+	s := scheduler.NewScheduler(repos, host+"-"+traceid.ID(), c.Interval())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.Run(ctx)
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// listenEvents executes the workflows triggered by the events of the shared bus
+func listenEvents() {
+	repos, err := driver.NewRepositoriesFromEnv()
+	if err != nil {
+		flogs.Errorf("create event repositories error: %v", err)
+		os.Exit(1)
+	}
+	workflow.Listen(events.Shared(), repos)
+}
+
+// runMigrations converts the legacy workflows and plugins of the mongo config and the legacy diaries of the weaviate config
+func runMigrations(c *Config) error {
+	defer driver.Close(context.Background())
+
+	mc, err := driver.NewMongoCli(driver.MongoCliConf{Uri: c.MongoHost, DB: c.MongoDB})
+	if err != nil {
+		return err
+	}
+
+	count, err := mc.MigrateWorkFlows(context.Background())
+	flogs.Infof("migrated %d workflows", count)
+	if err != nil {
+		return err
+	}
+
+	count, err = mc.MigratePlugins(context.Background())
+	flogs.Infof("migrated %d plugins", count)
+	if err != nil {
+		return err
+	}
+
+	if c.WeaviateHost == "" {
+		return nil
+	}
+
+	wc, err := driver.NewWeaviateClient(driver.WeaviateClientConf{Host: c.WeaviateHost, Schema: c.WeaviateSchema, Key: c.WeaviateKey})
+	if err != nil {
+		return err
+	}
+
+	count, err = wc.MigrateDiaries(context.Background())
+	flogs.Infof("migrated %d diaries", count)
+	return err
+}
+
+// importDefinition loads the definition file, the format is decided by the extension of the file
+func importDefinition(file string, dryRun bool) error {
+	defer driver.Close(context.Background())
+
+	format := types.DefinitionYAML
+	if strings.EqualFold(filepath.Ext(file), ".json") {
+		format = types.DefinitionJSON
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	def, err := workflow.ParseDefinition(data, format)
+	if err != nil {
+		return err
+	}
+
+	repos, err := driver.NewRepositoriesFromEnv()
+	if err != nil {
+		return err
+	}
+
+	changes, err := workflow.NewLoader(repos, traceid.ID()).Load(context.Background(), def, dryRun)
+	if err != nil && len(changes) > 0 {
+		// the changes before the failed one are saved, importing the file again saves the rest
+		fmt.Println("the import failed and is partly saved, the changes before the failure are saved:")
+	}
+	for _, c := range changes {
+		fmt.Printf("%s %s %s\n", c.Action, c.Kind, c.Name)
+		for _, d := range c.Diff {
+			fmt.Printf("    %s\n", d)
+		}
+	}
+	return err
+}
+
+// exportDefinition prints the definition of the workflows of the user
+func exportDefinition(user, format string) error {
+	defer driver.Close(context.Background())
+
+	repos, err := driver.NewRepositoriesFromEnv()
+	if err != nil {
+		return err
+	}
+
+	def, err := workflow.NewLoader(repos, traceid.ID()).Export(context.Background(), user)
+	if err != nil {
+		return err
+	}
+
+	data, err := workflow.MarshalDefinition(def, format)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(data)
+	return err
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	handler "github.com/andy-zhangtao/Functions/api"
+	"github.com/andy-zhangtao/Functions/driver"
+	"github.com/andy-zhangtao/Functions/tools/flogs"
+	"github.com/andy-zhangtao/Functions/types"
+)
+
+// NewRouter mounts every handler of the api package with versioned paths
+func NewRouter(c *Config) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/v1/hello", handler.Handler)
+	mux.HandleFunc("/v1/date", handler.Date)
+	mux.HandleFunc("/v1/diary", handler.DirayCreateHandler)
+	mux.HandleFunc("/v1/workflow", handler.WorkFlowHandler)
+	mux.HandleFunc("/v1/report", handler.ReportHandler)
+	mux.HandleFunc("/v1/format", handler.FormatHandler)
+	mux.HandleFunc("/v1/plugin", handler.PluginHandler)
+	mux.HandleFunc("/v1/schedule", handler.ScheduleHandler)
+
+	mux.HandleFunc("/healthz", healthz)
+	mux.HandleFunc("/readyz", readyz(c))
+
+	return accessLog(mux)
+}
+
+// healthz reports the process is alive
+func healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// readyz pings mongo and weaviate
+func readyz(c *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		checks := map[string]string{
+			"mongo":    "ok",
+			"weaviate": "ok",
+		}
+
+		code := http.StatusOK
+		if c.StorageBackend == types.StorageBackendMemory {
+			checks = map[string]string{"memory": "ok"}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(checks)
+			return
+		}
+
+		if err := pingMongo(ctx, c); err != nil {
+			checks["mongo"] = err.Error()
+			code = http.StatusServiceUnavailable
+		}
+
+		if err := pingWeaviate(ctx, c); err != nil {
+			checks["weaviate"] = err.Error()
+			code = http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(checks)
+	}
+}
+
+func pingMongo(ctx context.Context, c *Config) error {
+	cli, err := driver.NewMongoCli(driver.MongoCliConf{Uri: c.MongoHost, DB: c.MongoDB, Collection: c.MongoCollection})
+	if err != nil {
+		return err
+	}
+	return cli.Ping(ctx)
+}
+
+func pingWeaviate(ctx context.Context, c *Config) error {
+	wc, err := driver.NewWeaviateClient(driver.WeaviateClientConf{Host: c.WeaviateHost, Schema: c.WeaviateSchema, Key: c.WeaviateKey})
+	if err != nil {
+		return err
+	}
+	return wc.Ready(ctx)
+}
+
+func accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		next.ServeHTTP(w, r)
+		flogs.Infof("%s %s %s", r.Method, r.URL.Path, time.Since(start))
+	})
+}
@@ -0,0 +1,163 @@
+package driver
+
+// The clients are shared by the whole process, so the connections are reused
+// across the requests and the warm serverless invocations.
+// They are created lazily on first use, Close disconnects them on shutdown.
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/andy-zhangtao/Functions/tools/flogs"
+	"github.com/andy-zhangtao/Functions/types"
+	"github.com/weaviate/weaviate-go-client/v4/weaviate"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+var (
+	mu              sync.Mutex
+	mongoClients    = make(map[string]*mongoClient)
+	weaviateClients = make(map[string]*weaviate.Client)
+	weaviateHttp    = make(map[string]*http.Client)
+)
+
+// mongoClient connects the client of an uri, the lock is held while connecting
+// so the other uris and the weaviate clients are not blocked by the connect
+type mongoClient struct {
+	mu     sync.Mutex
+	client *mongo.Client
+}
+
+// SharedMongo returns the process wide mongo client of uri.
+// The pool size is configured by MONGO_MAX_POOL_SIZE and MONGO_MIN_POOL_SIZE.
+// A failed connect is retried by the next call.
+func SharedMongo(uri string) (*mongo.Client, error) {
+	mu.Lock()
+	mc, ok := mongoClients[uri]
+	if !ok {
+		mc = &mongoClient{}
+		mongoClients[uri] = mc
+	}
+	mu.Unlock()
+
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	if mc.client != nil {
+		return mc.client, nil
+	}
+
+	flogs.Infof("connect to mongo uri: %s", uri)
+
+	clientOpts := options.Client().ApplyURI(uri)
+	if size := envUint(types.EnvMONGOMAXPOOLSIZE); size > 0 {
+		clientOpts.SetMaxPoolSize(size)
+	}
+	if size := envUint(types.EnvMONGOMINPOOLSIZE); size > 0 {
+		clientOpts.SetMinPoolSize(size)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, clientOpts)
+	if err != nil {
+		return nil, fmt.Errorf("connect to mongo error: %w", err)
+	}
+
+	if err = client.Ping(ctx, readpref.Primary()); err != nil {
+		client.Disconnect(context.Background())
+		return nil, fmt.Errorf("ping mongo error: %w", err)
+	}
+
+	mc.client = client
+	return client, nil
+}
+
+// SharedWeaviate returns the process wide weaviate client of the conf.
+// The idle connections kept to weaviate is configured by WEAVIATE_MAX_IDLE_CONNS.
+func SharedWeaviate(conf WeaviateClientConf) (*weaviate.Client, error) {
+	key := conf.Schema + "://" + conf.Host + "#" + conf.Key
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if client, ok := weaviateClients[key]; ok {
+		return client, nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if size := int(envUint(types.EnvWeaviateMaxIdleConns)); size > 0 {
+		transport.MaxIdleConns = size
+		transport.MaxIdleConnsPerHost = size
+	}
+	httpClient := &http.Client{Transport: transport}
+
+	cfg := weaviate.Config{
+		Host:             conf.Host,
+		Scheme:           conf.Schema,
+		ConnectionClient: httpClient,
+	}
+	// the api key auth only adds the header, set it here so the pooled http client can be used
+	if conf.Key != "" {
+		cfg.Headers = map[string]string{"authorization": "Bearer " + conf.Key}
+	}
+
+	client, err := weaviate.NewClient(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("could not create weaviate client: %v", err)
+	}
+
+	weaviateClients[key] = client
+	weaviateHttp[key] = httpClient
+	return client, nil
+}
+
+// Close disconnects all the shared clients
+func Close(ctx context.Context) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	var lastErr error
+	for uri, mc := range mongoClients {
+		mc.mu.Lock()
+		if mc.client != nil {
+			if err := mc.client.Disconnect(ctx); err != nil {
+				flogs.Errorf("disconnect mongo %s error: %v", uri, err)
+				lastErr = err
+			}
+			mc.client = nil
+		}
+		mc.mu.Unlock()
+		delete(mongoClients, uri)
+	}
+
+	for key, client := range weaviateHttp {
+		client.CloseIdleConnections()
+		delete(weaviateHttp, key)
+		delete(weaviateClients, key)
+	}
+
+	return lastErr
+}
+
+func envUint(env string) uint64 {
+	v := os.Getenv(env)
+	if v == "" {
+		return 0
+	}
+
+	size, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		flogs.Errorf("invalid %s [%s]: %v", env, v, err)
+		return 0
+	}
+	return size
+}
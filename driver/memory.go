@@ -0,0 +1,1054 @@
+package driver
+
+// The in-memory repositories are used by the tests and the local development,
+// they are selected by STORAGE_BACKEND=memory and keep the data in the process only.
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	fformat "github.com/andy-zhangtao/Functions/service/f_format"
+	"github.com/andy-zhangtao/Functions/types"
+)
+
+// MemoryStore implements the workflow, run, schedule, lock, plugin, format and diary repositories in memory
+type MemoryStore struct {
+	mu           sync.RWMutex
+	workflows    []types.WorkFlow
+	versions     []types.WorkFlow
+	runs         []types.WorkFlowRun
+	schedules    []types.Schedule
+	scheduleRuns []types.ScheduleRun
+	locks        map[string]memoryLock
+	plugins      []types.Plugin
+	formats      []fformat.FormatModel
+	diaries      []memoryDiary
+	nextID       int
+}
+
+type memoryLock struct {
+	owner   string
+	expires time.Time
+}
+
+type memoryDiary struct {
+	id    string
+	diary types.Diary
+	mask  map[string]interface{}
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+// NewMemoryRepositories returns the repositories backed by a new MemoryStore and MemoryVectorStore
+func NewMemoryRepositories() *Repositories {
+	store := NewMemoryStore()
+	return &Repositories{
+		Diary:        NewMemoryVectorStore(),
+		DiaryArchive: store,
+		Workflow:     store,
+		Run:          store,
+		Schedule:     store,
+		Lock:         store,
+		Plugin:       store,
+		Format:       store,
+	}
+}
+
+var (
+	memoryMu    sync.Mutex
+	memoryRepos *Repositories
+)
+
+// SharedMemoryRepositories returns the process wide in-memory repositories
+func SharedMemoryRepositories() *Repositories {
+	memoryMu.Lock()
+	defer memoryMu.Unlock()
+
+	if memoryRepos == nil {
+		memoryRepos = NewMemoryRepositories()
+	}
+	return memoryRepos
+}
+
+// ResetMemoryRepositories drops the process wide in-memory repositories,
+// the next SharedMemoryRepositories returns empty ones. The tests call it to isolate their data.
+func ResetMemoryRepositories() {
+	memoryMu.Lock()
+	defer memoryMu.Unlock()
+	memoryRepos = nil
+}
+
+func (ms *MemoryStore) id() string {
+	ms.nextID++
+	return strconv.Itoa(ms.nextID)
+}
+
+// SavePlugin appends the plugin
+func (ms *MemoryStore) SavePlugin(plugin types.Plugin) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.plugins = append(ms.plugins, plugin)
+}
+
+func (ms *MemoryStore) GetWorkFlowByID(ctx context.Context, id string) (*types.WorkFlow, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	for _, f := range ms.workflows {
+		if f.ID == id {
+			flow := f
+			return &flow, nil
+		}
+	}
+	return nil, fmt.Errorf("get workflow error: workflow %s not found", id)
+}
+
+func (ms *MemoryStore) SaveWorkFlow(ctx context.Context, flow *types.WorkFlow) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if flow.Name != "" && ms.findWorkFlow(flow.Name, flow.User) >= 0 {
+		return ErrExists
+	}
+
+	if flow.ID == "" {
+		// the ids given by the callers are skipped
+		for flow.ID = ms.id(); ms.hasWorkFlow(flow.ID); flow.ID = ms.id() {
+		}
+	}
+	flow.Version = 1
+	ms.workflows = append(ms.workflows, *flow)
+	ms.versions = append(ms.versions, *flow)
+	return nil
+}
+
+func (ms *MemoryStore) hasWorkFlow(id string) bool {
+	for _, f := range ms.workflows {
+		if f.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// findWorkFlow returns the index of the workflow, -1 if not found
+func (ms *MemoryStore) findWorkFlow(name, user string) int {
+	for i, f := range ms.workflows {
+		if f.Name == name && f.User == user {
+			return i
+		}
+	}
+	return -1
+}
+
+func (ms *MemoryStore) FindWorkFlow(ctx context.Context, name, user string) (*types.WorkFlow, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	if i := ms.findWorkFlow(name, user); i >= 0 {
+		flow := ms.workflows[i]
+		return &flow, nil
+	}
+	return nil, nil
+}
+
+func (ms *MemoryStore) FindWorkFlows(ctx context.Context, user string) ([]*types.WorkFlow, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	var flows []*types.WorkFlow
+	for _, f := range ms.workflows {
+		if f.User == user {
+			flow := f
+			flows = append(flows, &flow)
+		}
+	}
+	return flows, nil
+}
+
+func (ms *MemoryStore) FindTriggered(ctx context.Context, event, user string) ([]*types.WorkFlow, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	var flows []*types.WorkFlow
+	for _, f := range ms.workflows {
+		if f.User != user {
+			continue
+		}
+		for _, t := range f.Triggers {
+			if t.Event == event {
+				flow := f
+				flows = append(flows, &flow)
+				break
+			}
+		}
+	}
+	return flows, nil
+}
+
+func (ms *MemoryStore) UpdateWorkFlow(ctx context.Context, flow *types.WorkFlow) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	i := ms.findWorkFlow(flow.Name, flow.User)
+	if i < 0 {
+		return ErrNotFound
+	}
+
+	exist := ms.workflows[i]
+	last := ms.lastWorkFlowVersion(exist.ID)
+	if last == 0 {
+		// the workflow saved before the versions is kept as version 1
+		exist.Version, last = 1, 1
+		ms.versions = append(ms.versions, exist)
+	}
+
+	flow.ID = exist.ID
+	flow.Version = last + 1
+	ms.workflows[i] = *flow
+	ms.versions = append(ms.versions, *flow)
+	return nil
+}
+
+func (ms *MemoryStore) lastWorkFlowVersion(id string) int {
+	last := 0
+	for _, v := range ms.versions {
+		if v.ID == id && v.Version > last {
+			last = v.Version
+		}
+	}
+	return last
+}
+
+func (ms *MemoryStore) GetWorkFlowVersion(ctx context.Context, id string, version int) (*types.WorkFlow, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	for _, v := range ms.versions {
+		if v.ID == id && v.Version == version {
+			flow := v
+			return &flow, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (ms *MemoryStore) ListWorkFlowVersions(ctx context.Context, id string) ([]types.WorkFlow, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	var flows []types.WorkFlow
+	for _, v := range ms.versions {
+		if v.ID == id {
+			flows = append(flows, v)
+		}
+	}
+	sort.Slice(flows, func(i, j int) bool { return flows[i].Version > flows[j].Version })
+	return flows, nil
+}
+
+func (ms *MemoryStore) RollbackWorkFlow(ctx context.Context, name, user string, version int) (*types.WorkFlow, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	i := ms.findWorkFlow(name, user)
+	if i < 0 {
+		return nil, ErrNotFound
+	}
+
+	for _, v := range ms.versions {
+		if v.ID == ms.workflows[i].ID && v.Version == version {
+			ms.workflows[i] = v
+			flow := v
+			return &flow, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (ms *MemoryStore) DeleteWorkFlow(ctx context.Context, name, user string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	i := ms.findWorkFlow(name, user)
+	if i < 0 {
+		return ErrNotFound
+	}
+
+	id := ms.workflows[i].ID
+	ms.workflows = append(ms.workflows[:i], ms.workflows[i+1:]...)
+
+	versions := ms.versions[:0]
+	for _, v := range ms.versions {
+		if v.ID != id {
+			versions = append(versions, v)
+		}
+	}
+	ms.versions = versions
+	return nil
+}
+
+func (ms *MemoryStore) SaveRun(ctx context.Context, run *types.WorkFlowRun) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.runs = append(ms.runs, *run)
+	return nil
+}
+
+func (ms *MemoryStore) GetRun(ctx context.Context, token string) (*types.WorkFlowRun, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	for _, r := range ms.runs {
+		if r.Token == token {
+			run := r
+			return &run, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (ms *MemoryStore) FinishRun(ctx context.Context, token, status string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for i := range ms.runs {
+		if ms.runs[i].Token == token && ms.runs[i].Status == types.RunStatusPending {
+			ms.runs[i].Status = status
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (ms *MemoryStore) SaveSchedule(ctx context.Context, schedule *types.Schedule) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if schedule.ID == "" {
+		schedule.ID = ms.id()
+	}
+	ms.schedules = append(ms.schedules, *schedule)
+	return nil
+}
+
+func (ms *MemoryStore) findSchedule(id, user string) int {
+	for i, s := range ms.schedules {
+		if s.ID == id && s.User == user {
+			return i
+		}
+	}
+	return -1
+}
+
+func (ms *MemoryStore) GetSchedule(ctx context.Context, id, user string) (*types.Schedule, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	i := ms.findSchedule(id, user)
+	if i < 0 {
+		return nil, ErrNotFound
+	}
+	schedule := ms.schedules[i]
+	return &schedule, nil
+}
+
+func (ms *MemoryStore) ListSchedules(ctx context.Context, user string) ([]types.Schedule, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	var schedules []types.Schedule
+	for _, s := range ms.schedules {
+		if s.User == user {
+			schedules = append(schedules, s)
+		}
+	}
+	return schedules, nil
+}
+
+func (ms *MemoryStore) UpdateSchedule(ctx context.Context, schedule *types.Schedule) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	i := ms.findSchedule(schedule.ID, schedule.User)
+	if i < 0 {
+		return ErrNotFound
+	}
+	ms.schedules[i] = *schedule
+	return nil
+}
+
+func (ms *MemoryStore) DeleteSchedule(ctx context.Context, id, user string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	i := ms.findSchedule(id, user)
+	if i < 0 {
+		return ErrNotFound
+	}
+	ms.schedules = append(ms.schedules[:i], ms.schedules[i+1:]...)
+	return nil
+}
+
+func (ms *MemoryStore) DueSchedules(ctx context.Context, now int64) ([]types.Schedule, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	var schedules []types.Schedule
+	for _, s := range ms.schedules {
+		if s.Enabled && s.NextRun <= now {
+			schedules = append(schedules, s)
+		}
+	}
+	return schedules, nil
+}
+
+func (ms *MemoryStore) ClaimSchedule(ctx context.Context, id string, prev, next, now int64) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for i := range ms.schedules {
+		if ms.schedules[i].ID == id && ms.schedules[i].NextRun == prev {
+			ms.schedules[i].NextRun = next
+			ms.schedules[i].LastRun = now
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (ms *MemoryStore) SaveScheduleRun(ctx context.Context, run *types.ScheduleRun) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.scheduleRuns = append(ms.scheduleRuns, *run)
+	return nil
+}
+
+func (ms *MemoryStore) ListScheduleRuns(ctx context.Context, id string, limit int) ([]types.ScheduleRun, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	var runs []types.ScheduleRun
+	for i := len(ms.scheduleRuns) - 1; i >= 0 && len(runs) < limit; i-- {
+		if ms.scheduleRuns[i].ScheduleID == id {
+			runs = append(runs, ms.scheduleRuns[i])
+		}
+	}
+	return runs, nil
+}
+
+func (ms *MemoryStore) AcquireLock(ctx context.Context, name, owner string, ttl time.Duration) (bool, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	now := time.Now()
+	if l, ok := ms.locks[name]; ok && l.owner != owner && l.expires.After(now) {
+		return false, nil
+	}
+
+	if ms.locks == nil {
+		ms.locks = make(map[string]memoryLock)
+	}
+	ms.locks[name] = memoryLock{owner: owner, expires: now.Add(ttl)}
+	return true, nil
+}
+
+func (ms *MemoryStore) ReleaseLock(ctx context.Context, name, owner string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if l, ok := ms.locks[name]; ok && l.owner == owner {
+		delete(ms.locks, name)
+	}
+	return nil
+}
+
+func (ms *MemoryStore) GetPluginByPluginKey(ctx context.Context, id int) ([]types.Plugin, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	if i := ms.latestPlugin(id); i >= 0 {
+		return []types.Plugin{ms.plugins[i]}, nil
+	}
+	return nil, nil
+}
+
+// latestPlugin returns the index of the latest version of the plugin, -1 if not found
+func (ms *MemoryStore) latestPlugin(id int) int {
+	latest := -1
+	for i, p := range ms.plugins {
+		if p.PluginKey == id && (latest < 0 || p.Version > ms.plugins[latest].Version) {
+			latest = i
+		}
+	}
+	return latest
+}
+
+func (ms *MemoryStore) GetPluginVersion(ctx context.Context, id, version int) (*types.Plugin, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	for _, p := range ms.plugins {
+		if p.PluginKey == id && p.Version == version {
+			plugin := p
+			return &plugin, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (ms *MemoryStore) ListPlugins(ctx context.Context, module string) ([]types.Plugin, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	var plugins []types.Plugin
+	for i, p := range ms.plugins {
+		if (module == "" || p.Module == module) && ms.latestPlugin(p.PluginKey) == i {
+			plugins = append(plugins, p)
+		}
+	}
+
+	sort.Slice(plugins, func(i, j int) bool { return plugins[i].PluginKey < plugins[j].PluginKey })
+	return plugins, nil
+}
+
+func (ms *MemoryStore) CreatePlugin(ctx context.Context, plugin *types.Plugin) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	plugin.PluginKey = 1
+	for _, p := range ms.plugins {
+		if p.PluginKey >= plugin.PluginKey {
+			plugin.PluginKey = p.PluginKey + 1
+		}
+	}
+	plugin.Version = 1
+
+	ms.plugins = append(ms.plugins, *plugin)
+	return nil
+}
+
+func (ms *MemoryStore) InsertPlugin(ctx context.Context, plugin *types.Plugin) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if ms.latestPlugin(plugin.PluginKey) >= 0 {
+		return fmt.Errorf("plugin key %d is taken", plugin.PluginKey)
+	}
+
+	plugin.Version = 1
+	ms.plugins = append(ms.plugins, *plugin)
+	return nil
+}
+
+func (ms *MemoryStore) SavePluginVersion(ctx context.Context, plugin *types.Plugin) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	i := ms.latestPlugin(plugin.PluginKey)
+	if i < 0 {
+		return ErrNotFound
+	}
+
+	plugin.Version = ms.plugins[i].Version + 1
+	ms.plugins = append(ms.plugins, *plugin)
+	return nil
+}
+
+func (ms *MemoryStore) DeletePlugin(ctx context.Context, id int) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	plugins := ms.plugins[:0]
+	for _, p := range ms.plugins {
+		if p.PluginKey != id {
+			plugins = append(plugins, p)
+		}
+	}
+
+	if len(plugins) == len(ms.plugins) {
+		return ErrNotFound
+	}
+	ms.plugins = plugins
+	return nil
+}
+
+// FormatAction implements FormatRepository with the same rules as MongoCli
+func (ms *MemoryStore) FormatAction(ctx context.Context, fm *fformat.FormatModel) error {
+	switch fm.Action {
+	case types.AddAction, types.UpdateAction:
+		return ms.saveFormat(fm, fm.Action == types.UpdateAction)
+	case types.QueryAction:
+		found, err := ms.queryFormat(fm.User, fm.Tags, fm.Version)
+		if err != nil {
+			return err
+		}
+		action := fm.Action
+		*fm = *found
+		fm.Action = action
+		return nil
+	case types.DeleteAction:
+		return ms.deleteFormat(*fm)
+	}
+
+	return fmt.Errorf("not support format action: %s", fm.Action)
+}
+
+func (ms *MemoryStore) GetFormat(ctx context.Context, user, tags string) (*fformat.FormatModel, error) {
+	fm := &fformat.FormatModel{Action: types.QueryAction, User: user, Tags: tags}
+	if err := ms.FormatAction(ctx, fm); err != nil {
+		return nil, err
+	}
+	return fm, nil
+}
+
+func (ms *MemoryStore) ListFormat(ctx context.Context, user, tags string) ([]*fformat.FormatModel, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	want := fformat.SplitTags(tags)
+	var formats []*fformat.FormatModel
+	for _, f := range ms.formats {
+		if f.User == user && containsAll(fformat.SplitTags(f.Tags), want) {
+			fm := f
+			fm.Action = types.ListAction
+			formats = append(formats, &fm)
+		}
+	}
+	return formats, nil
+}
+
+func (ms *MemoryStore) saveFormat(fm *fformat.FormatModel, update bool) error {
+	tags := fformat.SplitTags(fm.Tags)
+	if fm.User == "" || len(tags) == 0 {
+		return fmt.Errorf("user and tags are required")
+	}
+
+	if fm.Format == "" {
+		return fmt.Errorf("format is empty")
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	version := 0
+	for _, f := range ms.formats {
+		if f.User == fm.User && sameTags(fformat.SplitTags(f.Tags), tags) {
+			if v, _ := strconv.Atoi(f.Version); v > version {
+				version = v
+			}
+		}
+	}
+
+	if update && version == 0 {
+		return fformat.ErrNotFound
+	}
+
+	if !update && version > 0 {
+		return fmt.Errorf("format with tags %s already exists", fm.Tags)
+	}
+
+	fm.ID = ms.id()
+	fm.Version = strconv.Itoa(version + 1)
+	fm.Tags = strings.Join(tags, ",")
+	ms.formats = append(ms.formats, *fm)
+	return nil
+}
+
+func (ms *MemoryStore) queryFormat(user, tags, version string) (*fformat.FormatModel, error) {
+	want := fformat.SplitTags(tags)
+	if len(want) == 0 {
+		return nil, fmt.Errorf("tags is empty")
+	}
+
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	match := func(exact bool) *fformat.FormatModel {
+		var found *fformat.FormatModel
+		for i, f := range ms.formats {
+			have := fformat.SplitTags(f.Tags)
+			if f.User != user || (exact && !sameTags(have, want)) || !containsAll(have, want) {
+				continue
+			}
+			if version != "" && f.Version != version {
+				continue
+			}
+			if found == nil || atoi(f.Version) > atoi(found.Version) {
+				found = &ms.formats[i]
+			}
+		}
+		return found
+	}
+
+	if found := match(true); found != nil {
+		fm := *found
+		return &fm, nil
+	}
+
+	if found := match(false); found != nil {
+		fm := *found
+		return &fm, nil
+	}
+
+	return nil, fformat.ErrNotFound
+}
+
+func (ms *MemoryStore) deleteFormat(fm fformat.FormatModel) error {
+	tags := fformat.SplitTags(fm.Tags)
+	if len(tags) == 0 {
+		return fmt.Errorf("tags is empty")
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	kept := ms.formats[:0]
+	deleted := 0
+	for _, f := range ms.formats {
+		if f.User == fm.User && sameTags(fformat.SplitTags(f.Tags), tags) && (fm.Version == "" || f.Version == fm.Version) {
+			deleted++
+			continue
+		}
+		kept = append(kept, f)
+	}
+	ms.formats = kept
+
+	if deleted == 0 {
+		return fformat.ErrNotFound
+	}
+	return nil
+}
+
+// SaveDiary implements DiaryRepository, it's used as the diary archive
+func (ms *MemoryStore) SaveDiary(ctx context.Context, diary types.Diary, mask map[string]interface{}) (string, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	id := ms.id()
+	ms.diaries = append(ms.diaries, memoryDiary{id: id, diary: diary, mask: mask})
+	return id, nil
+}
+
+// UpdateDiary implements DiaryRepository, the id is the id of the archive or the weaviate id of the mask
+func (ms *MemoryStore) UpdateDiary(ctx context.Context, id string, diary types.Diary) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for i, d := range ms.diaries {
+		if (d.id == id || d.mask["weaviate"] == id) && d.diary.User == diary.User {
+			ms.diaries[i].diary = diary
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// QueryDiary implements DiaryRepository, it filters by user and date only
+func (ms *MemoryStore) QueryDiary(ctx context.Context, query types.DirayQueryModel) (types.DirayQueryResponse, error) {
+	ms.mu.RLock()
+	records := make([]types.DiaryRecord, 0, len(ms.diaries))
+	for _, d := range ms.diaries {
+		records = append(records, types.DiaryRecord{ID: d.id, Diary: d.diary})
+	}
+	ms.mu.RUnlock()
+
+	records, err := filterDiaries(records, query)
+	if err != nil {
+		return types.DirayQueryResponse{}, err
+	}
+
+	sortDiaries(records, query.Order)
+	return diaryResponse(page(records, query.Limit, query.Offset)), nil
+}
+
+// MemoryVectorStore is a fake vector store of the diaries.
+// The diaries are embedded as term frequency vectors and searched by cosine similarity,
+// which is good enough to check the search behaviors without weaviate.
+type MemoryVectorStore struct {
+	mu      sync.RWMutex
+	diaries []types.DiaryRecord
+	vectors []map[string]float64
+	nextID  int
+}
+
+func NewMemoryVectorStore() *MemoryVectorStore {
+	return &MemoryVectorStore{}
+}
+
+func (vs *MemoryVectorStore) SaveDiary(ctx context.Context, diary types.Diary, mask map[string]interface{}) (string, error) {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+
+	vs.nextID++
+	id := strconv.Itoa(vs.nextID)
+	vs.diaries = append(vs.diaries, types.DiaryRecord{ID: id, Diary: diary})
+	vs.vectors = append(vs.vectors, embed(diaryText(diary)))
+	return id, nil
+}
+
+// UpdateDiary implements DiaryRepository, the diary is embedded again
+func (vs *MemoryVectorStore) UpdateDiary(ctx context.Context, id string, diary types.Diary) error {
+	vs.mu.Lock()
+	defer vs.mu.Unlock()
+
+	for i, d := range vs.diaries {
+		if d.ID == id && d.User == diary.User {
+			vs.diaries[i].Diary = diary
+			// the queries read the vectors without the lock, so they are copied instead of changed in place
+			vectors := make([]map[string]float64, len(vs.vectors))
+			copy(vectors, vs.vectors)
+			vectors[i] = embed(diaryText(diary))
+			vs.vectors = vectors
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// QueryDiary implements DiaryRepository with the same options as WeaviateClient.GetRecords
+func (vs *MemoryVectorStore) QueryDiary(ctx context.Context, query types.DirayQueryModel) (types.DirayQueryResponse, error) {
+	if err := checkDiaryQuery(query); err != nil {
+		return types.DirayQueryResponse{}, err
+	}
+
+	vs.mu.RLock()
+	records := make([]types.DiaryRecord, len(vs.diaries))
+	copy(records, vs.diaries)
+	vectors := vs.vectors
+	vs.mu.RUnlock()
+
+	if len(query.Keys) > 0 {
+		mode := query.Mode
+		if mode == "" {
+			mode = types.DiarySearchNearText
+		}
+
+		q := embed(strings.Join(query.Keys, " "))
+		var scored []types.DiaryRecord
+		for i, r := range records {
+			similarity := cosine(q, vectors[i])
+			switch mode {
+			case types.DiarySearchNearText:
+				distance := 1 - similarity
+				certainty := 1 - distance/2
+				switch {
+				case query.Certainty != nil && certainty < float64(*query.Certainty):
+					continue
+				case query.Certainty == nil && query.MaxDistance != nil && distance > float64(*query.MaxDistance):
+					continue
+				case query.Certainty == nil && query.MaxDistance == nil && distance > float64(types.DefaultDiaryMaxDistance):
+					continue
+				}
+				r.Distance, r.Certainty = &distance, &certainty
+			case types.DiarySearchBM25, types.DiarySearchHybrid:
+				score := keywordScore(q, vectors[i])
+				if mode == types.DiarySearchHybrid {
+					alpha := 0.75
+					if query.Alpha != nil {
+						alpha = float64(*query.Alpha)
+					}
+					score = alpha*similarity + (1-alpha)*score
+				}
+				if score <= 0 {
+					continue
+				}
+				r.Score = &score
+			default:
+				return types.DirayQueryResponse{}, fmt.Errorf("not support mode: %s", mode)
+			}
+			scored = append(scored, r)
+		}
+		records = scored
+	}
+
+	records, err := filterDiaries(records, query)
+	if err != nil {
+		return types.DirayQueryResponse{}, err
+	}
+
+	if len(query.Keys) > 0 && query.Sort != types.DiarySortDate {
+		// the most relevant first
+		less := func(i, j int) bool { return relevance(records[i]) > relevance(records[j]) }
+		sort.SliceStable(records, func(i, j int) bool {
+			if query.Order == types.DiaryOrderAsc {
+				return less(j, i)
+			}
+			return less(i, j)
+		})
+	} else {
+		sortDiaries(records, query.Order)
+	}
+
+	return diaryResponse(page(records, query.Limit, query.Offset)), nil
+}
+
+func relevance(r types.DiaryRecord) float64 {
+	if r.Distance != nil {
+		return 1 - *r.Distance
+	}
+	if r.Score != nil {
+		return *r.Score
+	}
+	return 0
+}
+
+func filterDiaries(records []types.DiaryRecord, query types.DirayQueryModel) ([]types.DiaryRecord, error) {
+	var start, end int64 = math.MinInt64, math.MaxInt64
+	var err error
+	if query.Start != "" {
+		if start, err = types.ParseDiaryDate(query.Start); err != nil {
+			return nil, fmt.Errorf("parse start time error: %w", err)
+		}
+	}
+	if query.End != "" {
+		if end, err = types.ParseDiaryDate(query.End); err != nil {
+			return nil, fmt.Errorf("parse end time error: %w", err)
+		}
+	}
+
+	var result []types.DiaryRecord
+	for _, r := range records {
+		if query.User != "" && r.User != query.User {
+			continue
+		}
+		if r.Date < start || r.Date > end {
+			continue
+		}
+		result = append(result, r)
+	}
+	return result, nil
+}
+
+// sortDiaries sorts the records by date, the latest first unless order is asc
+func sortDiaries(records []types.DiaryRecord, order string) {
+	sort.SliceStable(records, func(i, j int) bool {
+		if order == types.DiaryOrderAsc {
+			return records[i].Date < records[j].Date
+		}
+		return records[i].Date > records[j].Date
+	})
+}
+
+func page(records []types.DiaryRecord, limit, offset int) []types.DiaryRecord {
+	if offset >= len(records) {
+		return nil
+	}
+	records = records[offset:]
+	if limit > 0 && limit < len(records) {
+		records = records[:limit]
+	}
+	return records
+}
+
+func diaryResponse(records []types.DiaryRecord) types.DirayQueryResponse {
+	res := types.DirayQueryResponse{
+		Version: types.RequestVersionV1,
+		Code:    200,
+		Results: records,
+	}
+	for _, r := range records {
+		res.Records = append(res.Records, fmt.Sprintf("%s记录的内容是  \n%s", time.Unix(r.Date, 0).Format("2006-01-02"), r.Content))
+	}
+	return res
+}
+
+func diaryText(d types.Diary) string {
+	return strings.Join(append([]string{d.Title, d.Content}, d.Tags...), " ")
+}
+
+// embed returns the term frequency vector of text, CJK characters are single terms
+func embed(text string) map[string]float64 {
+	vector := make(map[string]float64)
+	var word []rune
+	flush := func() {
+		if len(word) > 0 {
+			vector[string(word)]++
+			word = word[:0]
+		}
+	}
+
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case unicode.Is(unicode.Han, r):
+			flush()
+			vector[string(r)]++
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			word = append(word, r)
+		default:
+			flush()
+		}
+	}
+	flush()
+	return vector
+}
+
+func cosine(a, b map[string]float64) float64 {
+	var dot, na, nb float64
+	for k, v := range a {
+		dot += v * b[k]
+		na += v * v
+	}
+	for _, v := range b {
+		nb += v * v
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
+
+// keywordScore is the ratio of the query terms found in the document
+func keywordScore(query, doc map[string]float64) float64 {
+	if len(query) == 0 {
+		return 0
+	}
+	hit := 0
+	for k := range query {
+		if doc[k] > 0 {
+			hit++
+		}
+	}
+	return float64(hit) / float64(len(query))
+}
+
+func sameTags(a, b []string) bool {
+	return len(a) == len(b) && containsAll(a, b)
+}
+
+func containsAll(have, want []string) bool {
+	set := make(map[string]bool, len(have))
+	for _, t := range have {
+		set[t] = true
+	}
+	for _, t := range want {
+		if !set[t] {
+			return false
+		}
+	}
+	return true
+}
+
+func atoi(s string) int {
+	v, _ := strconv.Atoi(s)
+	return v
+}
+
+var (
+	_ DiaryRepository    = (*MemoryVectorStore)(nil)
+	_ DiaryRepository    = (*MemoryStore)(nil)
+	_ WorkflowRepository = (*MemoryStore)(nil)
+	_ PluginRepository   = (*MemoryStore)(nil)
+	_ FormatRepository   = (*MemoryStore)(nil)
+)
@@ -0,0 +1,88 @@
+package driver
+
+import (
+	"context"
+
+	"testing"
+
+	"github.com/andy-zhangtao/Functions/types"
+)
+
+func TestMemoryVectorStoreQuery(t *testing.T) {
+	vs := NewMemoryVectorStore()
+	vs.SaveDiary(context.Background(), types.Diary{User: "u", Content: "design the Father workflow engine", Date: 100}, nil)
+	vs.SaveDiary(context.Background(), types.Diary{User: "u", Content: "fix the report chunking", Date: 200}, nil)
+	vs.SaveDiary(context.Background(), types.Diary{User: "other", Content: "design the Father workflow engine", Date: 300}, nil)
+
+	loose := float32(0.9)
+	alpha := float32(0)
+
+	tests := []struct {
+		name  string
+		query types.DirayQueryModel
+		want  []int64
+	}{
+		{"all by date", types.DirayQueryModel{User: "u"}, []int64{200, 100}},
+		{"date asc", types.DirayQueryModel{User: "u", Order: types.DiaryOrderAsc}, []int64{100, 200}},
+		{"range", types.DirayQueryModel{User: "u", Start: "150", End: "250"}, []int64{200}},
+		{"near text", types.DirayQueryModel{User: "u", Keys: []string{"Father workflow"}, MaxDistance: &loose}, []int64{100}},
+		{"default distance", types.DirayQueryModel{User: "u", Keys: []string{"Father"}}, nil},
+		{"bm25", types.DirayQueryModel{User: "u", Keys: []string{"report"}, Mode: types.DiarySearchBM25}, []int64{200}},
+		{"hybrid keyword only", types.DirayQueryModel{User: "u", Keys: []string{"chunking"}, Mode: types.DiarySearchHybrid, Alpha: &alpha}, []int64{200}},
+		{"page", types.DirayQueryModel{User: "u", Limit: 1, Offset: 1}, []int64{100}},
+		{"relevance desc", types.DirayQueryModel{User: "u", Keys: []string{"Father workflow report"}, MaxDistance: &loose}, []int64{100, 200}},
+		{"relevance asc", types.DirayQueryModel{User: "u", Keys: []string{"Father workflow report"}, MaxDistance: &loose, Order: types.DiaryOrderAsc}, []int64{200, 100}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := vs.QueryDiary(context.Background(), tt.query)
+			if err != nil {
+				t.Fatalf("QueryDiary error: %v", err)
+			}
+
+			var got []int64
+			for _, r := range res.Results {
+				got = append(got, r.Date)
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("dates = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("dates = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryStoreQueryOrder(t *testing.T) {
+	ms := NewMemoryStore()
+	ms.SaveDiary(context.Background(), types.Diary{User: "u", Content: "first", Date: 100}, nil)
+	ms.SaveDiary(context.Background(), types.Diary{User: "u", Content: "second", Date: 200}, nil)
+
+	tests := []struct {
+		name  string
+		order string
+		want  []int64
+	}{
+		{"default", "", []int64{200, 100}},
+		{"desc", types.DiaryOrderDesc, []int64{200, 100}},
+		{"asc", types.DiaryOrderAsc, []int64{100, 200}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := ms.QueryDiary(context.Background(), types.DirayQueryModel{User: "u", Order: tt.order})
+			if err != nil {
+				t.Fatalf("QueryDiary error: %v", err)
+			}
+
+			if len(res.Results) != 2 || res.Results[0].Date != tt.want[0] || res.Results[1].Date != tt.want[1] {
+				t.Fatalf("diaries = %+v, want dates %v", res.Results, tt.want)
+			}
+		})
+	}
+}
@@ -2,18 +2,26 @@ package driver
 
 import (
 	"context"
-	"fmt"
+	"sync"
 
 	"github.com/andy-zhangtao/Functions/tools/flogs"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
 
+// indexes records the indexes created in the current process by database, collection and index name
+var indexes sync.Map
+
+// MongoCli is the mongo storage, it implements all the repositories.
+// The diaries and formats are stored in collection,
+// the workflows and plugins are stored in their fixed collections.
 type MongoCli struct {
 	cli        *mongo.Client
 	db         string
 	collection string
+	traceId    string
 }
 
 type MongoCliConf struct {
@@ -26,46 +34,66 @@ func NewMongoCli(conf MongoCliConf) (*MongoCli, error) {
 
 	flogs.Infof("NewMongoCli uri: %s", conf.Uri)
 
-	clientOpts := options.Client().ApplyURI(conf.Uri)
-	client, err := mongo.Connect(context.TODO(), clientOpts)
+	client, err := SharedMongo(conf.Uri)
 	if err != nil {
-		return nil, fmt.Errorf("connect to mongo error: %w", err)
+		return nil, err
 	}
 
-	err = client.Ping(context.Background(), readpref.Primary())
+	return NewMongoCliWithClient(client, conf.DB, conf.Collection), nil
+}
+
+// NewMongoCliWithClient reuses a connected client
+func NewMongoCliWithClient(client *mongo.Client, db, collection string) *MongoCli {
 	return &MongoCli{
 		cli:        client,
-		db:         conf.DB,
-		collection: conf.Collection,
-	}, err
+		db:         db,
+		collection: collection,
+	}
 }
 
-func (mc *MongoCli) SaveDataToMongo(data interface{}) error {
-	collection := mc.cli.Database(mc.db).Collection(mc.collection)
-	_, err := collection.InsertOne(context.Background(), data)
-	return err
+// WithCollection returns a copy of the client using another collection
+func (mc *MongoCli) WithCollection(collection string) *MongoCli {
+	c := *mc
+	c.collection = collection
+	return &c
 }
 
-func (mc *MongoCli) FindWorkFlow(filter interface{}, object interface{}) (find bool, err error) {
-	collection := mc.cli.Database(mc.db).Collection(mc.collection)
-	err = collection.FindOne(context.Background(), filter).Decode(object)
-	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			// This error means your query did not match any documents.
-			return false, nil
-		}
-		return false, err
-	}
+// WithTraceID returns a copy of the client logging with traceId
+func (mc *MongoCli) WithTraceID(traceId string) *MongoCli {
+	c := *mc
+	c.traceId = traceId
+	return &c
+}
 
-	return true, nil
+func (mc *MongoCli) log(format string, args ...interface{}) {
+	format = "[MongoCli]-[info]-[%s] " + format
+	args = append([]interface{}{mc.traceId}, args...)
+	logrus.Infof(format, args...)
 }
 
-func (mc *MongoCli) FindAllWorkFlows(filter interface{}, objects interface{}) error {
-	collection := mc.cli.Database(mc.db).Collection(mc.collection)
-	cursor, err := collection.Find(context.Background(), filter)
-	if err != nil {
-		return err
+func (mc *MongoCli) error(format string, args ...interface{}) {
+	format = "[MongoCli]-[error]-[%s] " + format
+	args = append([]interface{}{mc.traceId}, args...)
+	logrus.Errorf(format, args...)
+}
+
+// Ping checks the connection of mongo
+func (mc *MongoCli) Ping(ctx context.Context) error {
+	return mc.cli.Ping(ctx, readpref.Primary())
+}
+
+// ensureIndex creates the index of the collection once per process, the index must have a name
+func ensureIndex(ctx context.Context, coll *mongo.Collection, model mongo.IndexModel) error {
+	key := coll.Database().Name() + "." + coll.Name() + "." + *model.Options.Name
+	if _, ok := indexes.Load(key); ok {
+		return nil
+	}
+
+	if _, err := coll.Indexes().CreateOne(ctx, model); err != nil {
+		flogs.Errorf("create index %s error: %v", key, err)
+		return errors.WithMessagef(err, "create index %s error", key)
 	}
 
-	return cursor.All(context.Background(), objects)
+	indexes.Store(key, true)
+	return nil
 }
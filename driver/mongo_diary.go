@@ -0,0 +1,143 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/andy-zhangtao/Functions/tools/flogs"
+	"github.com/andy-zhangtao/Functions/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SaveDiary implements DiaryRepository
+// mask: map[string]interface{}{"key": "value"} is stored along with the diary
+func (mc *MongoCli) SaveDiary(ctx context.Context, diary types.Diary, mask map[string]interface{}) (string, error) {
+	ctx, cancel := opContext(ctx)
+	defer cancel()
+
+	_bData := bson.M{
+		types.DiaryPropUser:    diary.User,
+		types.DiaryPropTitle:   diary.Title,
+		types.DiaryPropContent: diary.Content,
+		types.DiaryPropTags:    diary.Tags,
+		types.DiaryPropDate:    diary.Date,
+	}
+
+	if len(mask) > 0 {
+		for k, v := range mask {
+			_bData[k] = v
+		}
+	}
+
+	collection := mc.cli.Database(mc.db).Collection(mc.collection)
+	res, err := collection.InsertOne(ctx, _bData)
+	if err != nil {
+		return "", err
+	}
+
+	if id, ok := res.InsertedID.(primitive.ObjectID); ok {
+		return id.Hex(), nil
+	}
+	return fmt.Sprintf("%v", res.InsertedID), nil
+}
+
+// UpdateDiary implements DiaryRepository, the id is the weaviate id kept in the mask or the mongo id
+func (mc *MongoCli) UpdateDiary(ctx context.Context, id string, diary types.Diary) error {
+	ctx, cancel := opContext(ctx)
+	defer cancel()
+
+	ids := bson.A{bson.M{"weaviate": id}}
+	if oid, err := primitive.ObjectIDFromHex(id); err == nil {
+		ids = append(ids, bson.M{"_id": oid})
+	}
+
+	collection := mc.cli.Database(mc.db).Collection(mc.collection)
+	res, err := collection.UpdateOne(ctx, bson.M{"$or": ids, types.DiaryPropUser: diary.User}, bson.M{"$set": bson.M{
+		types.DiaryPropTitle:   diary.Title,
+		types.DiaryPropContent: diary.Content,
+		types.DiaryPropTags:    diary.Tags,
+		types.DiaryPropDate:    diary.Date,
+	}})
+	if err != nil {
+		return fmt.Errorf("update mongo diary %s error: %w", id, err)
+	}
+
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// QueryDiary implements DiaryRepository
+// Mongo has no vector index, so Keys, Mode and the relevance options are ignored.
+func (mc *MongoCli) QueryDiary(ctx context.Context, query types.DirayQueryModel) (results types.DirayQueryResponse, err error) {
+	ctx, cancel := opContext(ctx)
+	defer cancel()
+
+	collection := mc.cli.Database(mc.db).Collection(mc.collection)
+
+	_bData := bson.M{}
+
+	if query.User != "" {
+		_bData["user"] = query.User
+	}
+
+	date := bson.M{}
+	if query.Start != "" {
+		start, err := types.ParseDiaryDate(query.Start)
+		if err != nil {
+			return results, fmt.Errorf("parse start time error: %w", err)
+		}
+		date["$gte"] = start
+	}
+
+	if query.End != "" {
+		end, err := types.ParseDiaryDate(query.End)
+		if err != nil {
+			return results, fmt.Errorf("parse end time error: %w", err)
+		}
+		date["$lte"] = end
+	}
+
+	if len(date) > 0 {
+		_bData[types.DiaryPropDate] = date
+	}
+
+	// the latest first unless order is asc, the same as the vector stores
+	opts := options.Find().SetSort(bson.M{types.DiaryPropDate: -1})
+	if query.Order == types.DiaryOrderAsc {
+		opts.SetSort(bson.M{types.DiaryPropDate: 1})
+	}
+	if query.Limit > 0 {
+		opts.SetLimit(int64(query.Limit))
+	}
+	if query.Offset > 0 {
+		opts.SetSkip(int64(query.Offset))
+	}
+
+	flogs.Infof("QueryDiary _bData: %+v", _bData)
+	cur, err := collection.Find(ctx, _bData, opts)
+	if err != nil {
+		return results, fmt.Errorf("query mongo error: %w", err)
+	}
+
+	var diaries []types.Diary
+	if err = cur.All(ctx, &diaries); err != nil {
+		return results, fmt.Errorf("query mongo error: %w", err)
+	}
+
+	results = types.DirayQueryResponse{
+		Version: types.RequestVersionV1,
+		Code:    http.StatusOK,
+	}
+	for _, d := range diaries {
+		results.Records = append(results.Records, fmt.Sprintf("%s %s", time.Unix(d.Date, 0).Format("2006-01-02"), d.Content))
+		results.Results = append(results.Results, types.DiaryRecord{Diary: d})
+	}
+
+	return results, nil
+}
@@ -0,0 +1,303 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	fformat "github.com/andy-zhangtao/Functions/service/f_format"
+	"github.com/andy-zhangtao/Functions/tools/flogs"
+	"github.com/andy-zhangtao/Functions/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// saveFormatRetries is how many times saveFormatToMongo retries when the new version is taken concurrently
+const saveFormatRetries = 5
+
+// EnsureFormatIndex creates the unique index of user, tags and version once per process.
+// tags is an array, so the index is built on tags_key, the sorted tags joined by comma.
+// The formats saved before the index have no tags_key and are not indexed.
+func (mc *MongoCli) EnsureFormatIndex(ctx context.Context) error {
+	return ensureIndex(ctx, mc.cli.Database(mc.db).Collection(mc.collection), mongo.IndexModel{
+		Keys: bson.D{{Key: "user", Value: 1}, {Key: "tags_key", Value: 1}, {Key: "version", Value: 1}},
+		Options: options.Index().SetUnique(true).SetName("user_tags_version").
+			SetPartialFilterExpression(bson.M{"tags_key": bson.M{"$exists": true}}),
+	})
+}
+
+// tagsKey returns the sorted and distinct tags joined by comma, the same tags in any order have the same key
+func tagsKey(tags []string) string {
+	sorted := append([]string(nil), tags...)
+	sort.Strings(sorted)
+
+	var distinct []string
+	for i, t := range sorted {
+		if i == 0 || t != sorted[i-1] {
+			distinct = append(distinct, t)
+		}
+	}
+	return strings.Join(distinct, ",")
+}
+
+// FormatAction add, query, update or delete the format by fm.Action.
+// The query result is filled back into fm.
+func (mc *MongoCli) FormatAction(ctx context.Context, fm *fformat.FormatModel) error {
+	switch fm.Action {
+	case types.AddAction:
+		return mc.saveFormatToMongo(ctx, fm, false)
+	case types.QueryAction:
+		return mc.QueryFormat(ctx, fm)
+	case types.UpdateAction:
+		return mc.saveFormatToMongo(ctx, fm, true)
+	case types.DeleteAction:
+		return mc.DeleteFormat(ctx, *fm)
+	}
+
+	return fmt.Errorf("not support format action: %s", fm.Action)
+}
+
+// formatFilter matches the formats of the user whose tags are exactly the given tags
+func formatFilter(user string, tags []string) bson.M {
+	return bson.M{
+		"user": user,
+		"tags": bson.M{"$all": tags, "$size": len(tags)},
+	}
+}
+
+// saveFormatToMongo saves fm as a new version.
+// Update requires an existing format, add requires none.
+func (mc *MongoCli) saveFormatToMongo(ctx context.Context, fm *fformat.FormatModel, update bool) error {
+	tags := fformat.SplitTags(fm.Tags)
+	if fm.User == "" || len(tags) == 0 {
+		return fmt.Errorf("user and tags are required")
+	}
+
+	if fm.Format == "" {
+		return fmt.Errorf("format is empty")
+	}
+
+	ctx, cancel := opContext(ctx)
+	defer cancel()
+
+	if err := mc.EnsureFormatIndex(ctx); err != nil {
+		return err
+	}
+
+	collection := mc.cli.Database(mc.db).Collection(mc.collection)
+
+	// the version is read and then inserted, a concurrent save of the same version fails with the unique index
+	for i := 0; i < saveFormatRetries; i++ {
+		var latest bson.M
+		opts := options.FindOne().SetSort(bson.M{"version": -1})
+		err := collection.FindOne(ctx, formatFilter(fm.User, tags), opts).Decode(&latest)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return fmt.Errorf("query mongo error: %w", err)
+		}
+
+		exist := err == nil
+		if update && !exist {
+			return fformat.ErrNotFound
+		}
+
+		if !update && exist {
+			return fmt.Errorf("format with tags %s already exists", fm.Tags)
+		}
+
+		version := 1
+		if exist {
+			version = toInt(latest["version"]) + 1
+		}
+
+		_bData := bson.M{
+			"user":       fm.User,
+			"tags":       tags,
+			"tags_key":   tagsKey(tags),
+			"format":     fm.Format,
+			"example":    fm.Example,
+			"version":    version,
+			"created_at": time.Now().Unix(),
+		}
+
+		res, err := collection.InsertOne(ctx, _bData)
+		if mongo.IsDuplicateKeyError(err) {
+			mc.log("format version %d of %s is taken, retry", version, fm.Tags)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		fm.Version = strconv.Itoa(version)
+		if id, ok := res.InsertedID.(primitive.ObjectID); ok {
+			fm.ID = id.Hex()
+		}
+		return nil
+	}
+
+	return fmt.Errorf("save format %s error: the version is taken %d times", fm.Tags, saveFormatRetries)
+}
+
+// QueryFormat fills fm with the format of the user and tags.
+// The format with exactly the same tags is preferred, then the one contains all the tags.
+// The latest version is returned when query.Version is empty.
+func (mc *MongoCli) QueryFormat(ctx context.Context, query *fformat.FormatModel) (err error) {
+	ctx, cancel := opContext(ctx)
+	defer cancel()
+
+	collection := mc.cli.Database(mc.db).Collection(mc.collection)
+
+	tags := fformat.SplitTags(query.Tags)
+	if len(tags) == 0 {
+		return fmt.Errorf("tags is empty")
+	}
+
+	filters := []bson.M{
+		formatFilter(query.User, tags),
+		{"user": query.User, "tags": bson.M{"$all": tags}},
+	}
+
+	for _, _bData := range filters {
+		if query.Version != "" {
+			version, err := strconv.Atoi(query.Version)
+			if err != nil {
+				return fmt.Errorf("invalid version %s: %w", query.Version, err)
+			}
+			_bData["version"] = version
+		}
+
+		flogs.Infof("QueryFormat _bData: %+v", _bData)
+
+		var episode bson.M
+		opts := options.FindOne().SetSort(bson.M{"version": -1})
+		err = collection.FindOne(ctx, _bData, opts).Decode(&episode)
+		if err == mongo.ErrNoDocuments {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("query mongo error: %w", err)
+		}
+
+		*query = *toFormatModel(episode, query.Action)
+		return nil
+	}
+
+	return fformat.ErrNotFound
+}
+
+// ListFormat lists all versions of the user's formats, the formats must contain all the tags if tags is not empty
+func (mc *MongoCli) ListFormat(ctx context.Context, user, tags string) ([]*fformat.FormatModel, error) {
+	ctx, cancel := opContext(ctx)
+	defer cancel()
+
+	collection := mc.cli.Database(mc.db).Collection(mc.collection)
+
+	_bData := bson.M{"user": user}
+	if t := fformat.SplitTags(tags); len(t) > 0 {
+		_bData["tags"] = bson.M{"$all": t}
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "tags", Value: 1}, {Key: "version", Value: -1}})
+	cur, err := collection.Find(ctx, _bData, opts)
+	if err != nil {
+		return nil, fmt.Errorf("query mongo error: %w", err)
+	}
+
+	var episodes []bson.M
+	if err = cur.All(ctx, &episodes); err != nil {
+		return nil, fmt.Errorf("query mongo error: %w", err)
+	}
+
+	formats := make([]*fformat.FormatModel, 0, len(episodes))
+	for _, episode := range episodes {
+		formats = append(formats, toFormatModel(episode, types.ListAction))
+	}
+
+	return formats, nil
+}
+
+// DeleteFormat deletes the format with exactly the tags, all versions are deleted when fm.Version is empty
+func (mc *MongoCli) DeleteFormat(ctx context.Context, fm fformat.FormatModel) error {
+	ctx, cancel := opContext(ctx)
+	defer cancel()
+
+	collection := mc.cli.Database(mc.db).Collection(mc.collection)
+
+	tags := fformat.SplitTags(fm.Tags)
+	if len(tags) == 0 {
+		return fmt.Errorf("tags is empty")
+	}
+
+	_bData := formatFilter(fm.User, tags)
+	if fm.Version != "" {
+		version, err := strconv.Atoi(fm.Version)
+		if err != nil {
+			return fmt.Errorf("invalid version %s: %w", fm.Version, err)
+		}
+		_bData["version"] = version
+	}
+
+	res, err := collection.DeleteMany(ctx, _bData)
+	if err != nil {
+		return fmt.Errorf("delete mongo error: %w", err)
+	}
+
+	if res.DeletedCount == 0 {
+		return fformat.ErrNotFound
+	}
+	return nil
+}
+
+// GetFormat returns the latest format of the user and tags
+func (mc *MongoCli) GetFormat(ctx context.Context, user, tags string) (*fformat.FormatModel, error) {
+	fm := &fformat.FormatModel{Action: types.QueryAction, User: user, Tags: tags}
+	if err := mc.QueryFormat(ctx, fm); err != nil {
+		return nil, err
+	}
+	return fm, nil
+}
+
+func toFormatModel(episode bson.M, action string) *fformat.FormatModel {
+	fm := &fformat.FormatModel{Action: action}
+	if id, ok := episode["_id"].(primitive.ObjectID); ok {
+		fm.ID = id.Hex()
+	}
+	fm.User, _ = episode["user"].(string)
+	fm.Format, _ = episode["format"].(string)
+	fm.Example, _ = episode["example"].(string)
+
+	var tags []string
+	if t, ok := episode["tags"].(primitive.A); ok {
+		for _, v := range t {
+			tags = append(tags, fmt.Sprintf("%v", v))
+		}
+	}
+	fm.Tags = strings.Join(tags, ",")
+
+	// the formats saved before versioning have no version, treat them as the first one
+	version := toInt(episode["version"])
+	if version == 0 {
+		version = 1
+	}
+	fm.Version = strconv.Itoa(version)
+	return fm
+}
+
+func toInt(v interface{}) int {
+	switch n := v.(type) {
+	case int32:
+		return int(n)
+	case int64:
+		return int(n)
+	case int:
+		return n
+	case float64:
+		return int(n)
+	}
+	return 0
+}
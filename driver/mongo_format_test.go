@@ -0,0 +1,23 @@
+package driver
+
+import "testing"
+
+func TestTagsKey(t *testing.T) {
+	tests := []struct {
+		name string
+		tags []string
+		want string
+	}{
+		{"one", []string{"weekly"}, "weekly"},
+		{"sorted", []string{"weekly", "team"}, "team,weekly"},
+		{"distinct", []string{"weekly", "team", "weekly"}, "team,weekly"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tagsKey(tt.tags); got != tt.want {
+				t.Fatalf("tagsKey = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package driver
+
+import (
+	"context"
+	"time"
+
+	"github.com/andy-zhangtao/Functions/types"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (mc *MongoCli) locks() *mongo.Collection {
+	return mc.cli.Database(mc.db).Collection(types.MongoDBLocks)
+}
+
+// EnsureLockIndex creates the unique index of the lock name once per process
+func (mc *MongoCli) EnsureLockIndex(ctx context.Context) error {
+	return ensureIndex(ctx, mc.locks(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "name", Value: 1}},
+		Options: options.Index().SetUnique(true).SetName("name"),
+	})
+}
+
+// AcquireLock upserts the lock if it's held by owner or expired.
+// The upsert of an existing name held by another owner fails with the unique index, then false is returned.
+func (mc *MongoCli) AcquireLock(ctx context.Context, name, owner string, ttl time.Duration) (bool, error) {
+	ctx, cancel := opContext(ctx)
+	defer cancel()
+
+	if err := mc.EnsureLockIndex(ctx); err != nil {
+		return false, err
+	}
+
+	now := time.Now()
+	_, err := mc.locks().UpdateOne(ctx,
+		bson.M{"name": name, "$or": bson.A{
+			bson.M{"owner": owner},
+			bson.M{"expires_at": bson.M{"$lt": now.UnixMilli()}},
+		}},
+		bson.M{"$set": bson.M{"owner": owner, "expires_at": now.Add(ttl).UnixMilli()}},
+		options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		mc.error("acquire lock %s error: %v", name, err)
+		return false, errors.WithMessage(err, "acquire lock error")
+	}
+	return true, nil
+}
+
+// ReleaseLock deletes the lock if it's held by owner
+func (mc *MongoCli) ReleaseLock(ctx context.Context, name, owner string) error {
+	ctx, cancel := opContext(ctx)
+	defer cancel()
+
+	if _, err := mc.locks().DeleteOne(ctx, bson.M{"name": name, "owner": owner}); err != nil {
+		mc.error("release lock %s error: %v", name, err)
+		return errors.WithMessage(err, "release lock error")
+	}
+	return nil
+}
@@ -0,0 +1,242 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/andy-zhangtao/Functions/types"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// legacyWorkFlow holds the fields of the two workflow documents saved before types.WorkFlow had steps:
+// the executable workflows (id, action, step_ids) and the workflow models (workflow_id, user, name, step_ids, flows)
+type legacyWorkFlow struct {
+	ObjectID   primitive.ObjectID `bson:"_id"`
+	ID         string             `bson:"id"`
+	WorkFlowId int                `bson:"workflow_id"`
+	User       string             `bson:"user"`
+	Name       string             `bson:"name"`
+	Action     string             `bson:"action"`
+	StepIDs    []int              `bson:"step_ids"`
+	Flows      []legacyFlow       `bson:"flows"`
+}
+
+type legacyFlow struct {
+	StepId int                    `bson:"step_id"`
+	Name   string                 `bson:"name"`
+	Desc   string                 `bson:"desc"`
+	Input  map[string]interface{} `bson:"input"`
+	Output map[string]interface{} `bson:"output"`
+}
+
+// convert returns the workflow of the legacy document.
+// The flows become the steps, the step_ids are used when there is no flow.
+// The workflow_id becomes the id unless it's taken by another workflow, then the ObjectID hex is used.
+func (l legacyWorkFlow) convert(taken map[string]bool) types.WorkFlow {
+	flow := types.WorkFlow{
+		ID:     l.ID,
+		User:   l.User,
+		Name:   l.Name,
+		Action: l.Action,
+	}
+
+	if flow.ID == "" {
+		flow.ID = l.ObjectID.Hex()
+		if id := strconv.Itoa(l.WorkFlowId); l.WorkFlowId != 0 && !taken[id] {
+			flow.ID = id
+		}
+	}
+	taken[flow.ID] = true
+
+	// the workflow models were only created by the api, they were meant to be executed
+	if flow.Action == "" {
+		flow.Action = types.WorkFlowExecute
+	}
+
+	for _, f := range l.Flows {
+		step := types.WorkFlowStep{
+			Name:      f.Name,
+			Desc:      f.Desc,
+			PluginKey: f.StepId,
+			Input:     f.Input,
+		}
+
+		for field, key := range f.Output {
+			if step.Output == nil {
+				step.Output = make(map[string]string)
+			}
+			step.Output[field] = fmt.Sprintf("%v", key)
+		}
+		flow.Steps = append(flow.Steps, step)
+	}
+
+	if len(flow.Steps) == 0 {
+		for _, id := range l.StepIDs {
+			flow.Steps = append(flow.Steps, types.WorkFlowStep{PluginKey: id})
+		}
+	}
+
+	return flow
+}
+
+// MigrateWorkFlows converts the legacy documents of the workflows collection to types.WorkFlow.
+// The documents with steps are skipped, so it's safe to run more than once.
+// It returns the number of the converted documents.
+func (mc *MongoCli) MigrateWorkFlows(ctx context.Context) (int, error) {
+	ids, err := mc.workflows().Distinct(ctx, "id", bson.M{"id": bson.M{"$exists": true}})
+	if err != nil {
+		return 0, errors.WithMessage(err, "find workflow ids error")
+	}
+
+	taken := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		taken[fmt.Sprintf("%v", id)] = true
+	}
+
+	cursor, err := mc.workflows().Find(ctx, bson.M{"steps": bson.M{"$exists": false}})
+	if err != nil {
+		return 0, errors.WithMessage(err, "find legacy workflows error")
+	}
+	defer cursor.Close(ctx)
+
+	count := 0
+	for cursor.Next(ctx) {
+		var legacy legacyWorkFlow
+		if err := cursor.Decode(&legacy); err != nil {
+			return count, errors.WithMessagef(err, "decode legacy workflow %v error", cursor.Current.Lookup("_id"))
+		}
+
+		flow := legacy.convert(taken)
+		if _, err := mc.workflows().ReplaceOne(ctx, bson.M{"_id": legacy.ObjectID}, flow); err != nil {
+			return count, errors.WithMessagef(err, "replace legacy workflow %s error", legacy.ObjectID.Hex())
+		}
+
+		mc.log("migrate workflow %s (%s) with %d steps", flow.ID, flow.Name, len(flow.Steps))
+		count++
+	}
+	if err := cursor.Err(); err != nil {
+		return count, err
+	}
+
+	renamed, err := mc.renameDuplicateWorkFlows(ctx)
+	if err != nil {
+		return count + renamed, err
+	}
+	return count + renamed, mc.EnsureWorkFlowNameIndex(ctx)
+}
+
+// renameDuplicateWorkFlows renames the workflows of a user sharing a name, created before the unique index,
+// to <name>-<id> except the first created one, so the unique index of user and name can be created
+func (mc *MongoCli) renameDuplicateWorkFlows(ctx context.Context) (int, error) {
+	cursor, err := mc.workflows().Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"name": bson.M{"$gt": ""}}}},
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"user": "$user", "name": "$name"},
+			"ids":   bson.M{"$push": "$id"},
+			"count": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+	})
+	if err != nil {
+		return 0, errors.WithMessage(err, "find the duplicate workflows error")
+	}
+
+	var groups []struct {
+		Key struct {
+			User string `bson:"user"`
+			Name string `bson:"name"`
+		} `bson:"_id"`
+		IDs []string `bson:"ids"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return 0, errors.WithMessage(err, "decode the duplicate workflows error")
+	}
+
+	count := 0
+	for _, g := range groups {
+		for _, id := range g.IDs[1:] {
+			name := g.Key.Name + "-" + id
+			if _, err := mc.workflows().UpdateOne(ctx, bson.M{"id": id}, bson.M{"$set": bson.M{"name": name}}); err != nil {
+				return count, errors.WithMessagef(err, "rename workflow %s error", id)
+			}
+			mc.log("rename the duplicate workflow %s of %s to %s", g.Key.Name, g.Key.User, name)
+			count++
+		}
+	}
+	return count, nil
+}
+
+// pluginVersion is the version of a plugin document, the plugins inserted by hand may share it
+type pluginVersion struct {
+	ID      interface{} `bson:"_id"`
+	Version int         `bson:"version"`
+}
+
+// renumberPlugins returns the documents of a plugin key whose version has to change,
+// the documents sorted by version and insertion become the versions 1 to n
+func renumberPlugins(docs []pluginVersion) []pluginVersion {
+	var changes []pluginVersion
+	for i, doc := range docs {
+		if doc.Version != i+1 {
+			changes = append(changes, pluginVersion{ID: doc.ID, Version: i + 1})
+		}
+	}
+	return changes
+}
+
+// MigratePlugins prepares the plugins inserted by hand for the unique index of plugin_key and version, then creates it.
+// The plugins without version become version 1, the plugins of a key sharing a version are renumbered
+// as the versions of the key in the order they were inserted, so the last inserted one is the latest.
+// It returns the number of the changed documents.
+func (mc *MongoCli) MigratePlugins(ctx context.Context) (int, error) {
+	res, err := mc.plugins().UpdateMany(ctx, bson.M{"version": bson.M{"$exists": false}}, bson.M{"$set": bson.M{"version": 1}})
+	if err != nil {
+		return 0, errors.WithMessage(err, "set the version of the legacy plugins error")
+	}
+	count := int(res.ModifiedCount)
+
+	cursor, err := mc.plugins().Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{"_id": bson.M{"plugin_key": "$plugin_key", "version": "$version"}, "count": bson.M{"$sum": 1}}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$_id.plugin_key"}}},
+	})
+	if err != nil {
+		return count, errors.WithMessage(err, "find the duplicate plugins error")
+	}
+
+	var keys []struct {
+		Key int `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &keys); err != nil {
+		return count, errors.WithMessage(err, "decode the duplicate plugins error")
+	}
+
+	for _, k := range keys {
+		opts := options.Find().SetSort(bson.D{{Key: "version", Value: 1}, {Key: "_id", Value: 1}}).SetProjection(bson.M{"version": 1})
+		cursor, err := mc.plugins().Find(ctx, bson.M{"plugin_key": k.Key}, opts)
+		if err != nil {
+			return count, errors.WithMessagef(err, "find the plugins of key %d error", k.Key)
+		}
+
+		var docs []pluginVersion
+		if err := cursor.All(ctx, &docs); err != nil {
+			return count, errors.WithMessagef(err, "decode the plugins of key %d error", k.Key)
+		}
+
+		for _, change := range renumberPlugins(docs) {
+			if _, err := mc.plugins().UpdateOne(ctx, bson.M{"_id": change.ID}, bson.M{"$set": bson.M{"version": change.Version}}); err != nil {
+				return count, errors.WithMessagef(err, "renumber plugin %d error", k.Key)
+			}
+			count++
+		}
+		mc.log("renumber the %d plugins of key %d", len(docs), k.Key)
+	}
+
+	return count, mc.EnsurePluginIndex(ctx)
+}
@@ -0,0 +1,84 @@
+package driver
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/andy-zhangtao/Functions/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestLegacyWorkFlowConvert(t *testing.T) {
+	oid := primitive.NewObjectID()
+
+	tests := []struct {
+		name   string
+		legacy legacyWorkFlow
+		taken  []string
+		want   types.WorkFlow
+	}{
+		{
+			name:   "executable workflow",
+			legacy: legacyWorkFlow{ObjectID: oid, ID: "1", Name: "diary", Action: "execute", StepIDs: []int{2, 1}},
+			want: types.WorkFlow{ID: "1", Name: "diary", Action: types.WorkFlowExecute, Steps: []types.WorkFlowStep{
+				{PluginKey: 2}, {PluginKey: 1},
+			}},
+		},
+		{
+			name: "workflow model",
+			legacy: legacyWorkFlow{ObjectID: oid, WorkFlowId: 7, User: "tester", Name: "report", StepIDs: []int{3}, Flows: []legacyFlow{
+				{StepId: 3, Name: "gpt", Desc: "ask gpt", Input: map[string]interface{}{"model": "gpt-4"}, Output: map[string]interface{}{"title": "report_title"}},
+			}},
+			want: types.WorkFlow{ID: "7", User: "tester", Name: "report", Action: types.WorkFlowExecute, Steps: []types.WorkFlowStep{
+				{Name: "gpt", Desc: "ask gpt", PluginKey: 3, Input: map[string]interface{}{"model": "gpt-4"}, Output: map[string]string{"title": "report_title"}},
+			}},
+		},
+		{
+			name:   "workflow id taken",
+			legacy: legacyWorkFlow{ObjectID: oid, WorkFlowId: 7, User: "tester", Name: "report", StepIDs: []int{3}},
+			taken:  []string{"7"},
+			want:   types.WorkFlow{ID: oid.Hex(), User: "tester", Name: "report", Action: types.WorkFlowExecute, Steps: []types.WorkFlowStep{{PluginKey: 3}}},
+		},
+		{
+			name:   "without id",
+			legacy: legacyWorkFlow{ObjectID: oid, User: "tester", Name: "empty"},
+			want:   types.WorkFlow{ID: oid.Hex(), User: "tester", Name: "empty", Action: types.WorkFlowExecute},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			taken := make(map[string]bool)
+			for _, id := range tt.taken {
+				taken[id] = true
+			}
+
+			if got := tt.legacy.convert(taken); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("convert() = %+v, want %+v", got, tt.want)
+			}
+			if !taken[tt.want.ID] {
+				t.Errorf("id %s is not taken after convert", tt.want.ID)
+			}
+		})
+	}
+}
+
+func TestRenumberPlugins(t *testing.T) {
+	tests := []struct {
+		name string
+		docs []pluginVersion
+		want []pluginVersion
+	}{
+		{"unique", []pluginVersion{{ID: 1, Version: 1}, {ID: 2, Version: 2}}, nil},
+		{"same version", []pluginVersion{{ID: 1, Version: 1}, {ID: 2, Version: 1}, {ID: 3, Version: 1}}, []pluginVersion{{ID: 2, Version: 2}, {ID: 3, Version: 3}}},
+		{"after the versions", []pluginVersion{{ID: 3, Version: 1}, {ID: 1, Version: 2}, {ID: 2, Version: 2}, {ID: 4, Version: 3}}, []pluginVersion{{ID: 2, Version: 3}, {ID: 4, Version: 4}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := renumberPlugins(tt.docs); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("renumberPlugins() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
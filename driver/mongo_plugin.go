@@ -0,0 +1,220 @@
+package driver
+
+import (
+	"context"
+
+	"github.com/andy-zhangtao/Functions/types"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// createPluginRetries is how many times CreatePlugin retries when the new key is taken concurrently
+const createPluginRetries = 5
+
+// savePluginVersionRetries is how many times SavePluginVersion retries when the new version is taken concurrently
+const savePluginVersionRetries = 5
+
+func (mc *MongoCli) plugins() *mongo.Collection {
+	return mc.cli.Database(mc.db).Collection(types.MongoDBPlugins)
+}
+
+// EnsurePluginIndex creates the unique index of plugin_key and version once per process.
+// It fails on the plugins inserted by hand without version or sharing a key until MigratePlugins converts them.
+func (mc *MongoCli) EnsurePluginIndex(ctx context.Context) error {
+	err := ensureIndex(ctx, mc.plugins(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "plugin_key", Value: 1}, {Key: "version", Value: 1}},
+		Options: options.Index().SetUnique(true).SetName("plugin_key_version"),
+	})
+	return errors.WithMessage(err, "run -migrate to convert the legacy plugins")
+}
+
+// GetPluginByPluginKey returns the latest version of the plugin
+func (mc *MongoCli) GetPluginByPluginKey(ctx context.Context, id int) ([]types.Plugin, error) {
+	mc.log("get plugin with id: %d", id)
+	ctx, cancel := opContext(ctx)
+	defer cancel()
+
+	plugin, err := mc.latestPlugin(ctx, id)
+	if err == ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return []types.Plugin{*plugin}, nil
+}
+
+func (mc *MongoCli) latestPlugin(ctx context.Context, id int) (*types.Plugin, error) {
+	var plugin types.Plugin
+
+	opts := options.FindOne().SetSort(bson.M{"version": -1})
+	err := mc.plugins().FindOne(ctx, bson.M{"plugin_key": id}, opts).Decode(&plugin)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		mc.error("get plugin with PluginKey: %d error: %v", id, err)
+		return nil, errors.WithMessage(err, "get plugins error")
+	}
+
+	return &plugin, nil
+}
+
+// GetPluginVersion returns the version of the plugin
+func (mc *MongoCli) GetPluginVersion(ctx context.Context, id, version int) (*types.Plugin, error) {
+	ctx, cancel := opContext(ctx)
+	defer cancel()
+
+	var plugin types.Plugin
+	err := mc.plugins().FindOne(ctx, bson.M{"plugin_key": id, "version": version}).Decode(&plugin)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		mc.error("get plugin %d version %d error: %v", id, version, err)
+		return nil, errors.WithMessage(err, "get plugin version error")
+	}
+
+	return &plugin, nil
+}
+
+// ListPlugins returns the latest version of every plugin, ordered by PluginKey
+func (mc *MongoCli) ListPlugins(ctx context.Context, module string) ([]types.Plugin, error) {
+	ctx, cancel := opContext(ctx)
+	defer cancel()
+
+	match := bson.M{}
+	if module != "" {
+		match["module"] = module
+	}
+
+	cursor, err := mc.plugins().Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$sort", Value: bson.D{{Key: "plugin_key", Value: 1}, {Key: "version", Value: -1}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$plugin_key", "doc": bson.M{"$first": "$$ROOT"}}}},
+		{{Key: "$replaceRoot", Value: bson.M{"newRoot": "$doc"}}},
+		{{Key: "$sort", Value: bson.M{"plugin_key": 1}}},
+	})
+	if err != nil {
+		mc.error("list plugins of module %s error: %v", module, err)
+		return nil, errors.WithMessage(err, "list plugins error")
+	}
+
+	var plugins []types.Plugin
+	if err := cursor.All(ctx, &plugins); err != nil {
+		return nil, errors.WithMessage(err, "decode plugins error")
+	}
+	return plugins, nil
+}
+
+// CreatePlugin saves the plugin as version 1 with the next PluginKey.
+// The unique index rejects a key taken concurrently, then the next key is tried.
+func (mc *MongoCli) CreatePlugin(ctx context.Context, plugin *types.Plugin) error {
+	ctx, cancel := opContext(ctx)
+	defer cancel()
+
+	if err := mc.EnsurePluginIndex(ctx); err != nil {
+		return err
+	}
+
+	for i := 0; i < createPluginRetries; i++ {
+		var last types.Plugin
+		opts := options.FindOne().SetSort(bson.M{"plugin_key": -1})
+		err := mc.plugins().FindOne(ctx, bson.M{}, opts).Decode(&last)
+		if err != nil && err != mongo.ErrNoDocuments {
+			mc.error("find the last plugin key error: %v", err)
+			return errors.WithMessage(err, "find the last plugin key error")
+		}
+
+		plugin.PluginKey = last.PluginKey + 1
+		plugin.Version = 1
+
+		_, err = mc.plugins().InsertOne(ctx, plugin)
+		if mongo.IsDuplicateKeyError(err) {
+			mc.log("plugin key %d is taken, retry", plugin.PluginKey)
+			continue
+		}
+		if err != nil {
+			mc.error("create plugin %s error: %v", plugin.Name, err)
+			return errors.WithMessage(err, "create plugin error")
+		}
+
+		mc.log("create plugin %s with key %d", plugin.Name, plugin.PluginKey)
+		return nil
+	}
+
+	return errors.Errorf("create plugin error: no free plugin key after %d retries", createPluginRetries)
+}
+
+// InsertPlugin saves the plugin as version 1 with its PluginKey, the unique index rejects a taken key
+func (mc *MongoCli) InsertPlugin(ctx context.Context, plugin *types.Plugin) error {
+	ctx, cancel := opContext(ctx)
+	defer cancel()
+
+	if err := mc.EnsurePluginIndex(ctx); err != nil {
+		return err
+	}
+
+	plugin.Version = 1
+	_, err := mc.plugins().InsertOne(ctx, plugin)
+	if mongo.IsDuplicateKeyError(err) {
+		return errors.Errorf("plugin key %d is taken", plugin.PluginKey)
+	}
+	if err != nil {
+		mc.error("insert plugin %d error: %v", plugin.PluginKey, err)
+		return errors.WithMessage(err, "insert plugin error")
+	}
+	return nil
+}
+
+// SavePluginVersion saves the plugin as the version after the latest one.
+// The unique index rejects a version taken by a concurrent save, then the next version is tried.
+func (mc *MongoCli) SavePluginVersion(ctx context.Context, plugin *types.Plugin) error {
+	ctx, cancel := opContext(ctx)
+	defer cancel()
+
+	if err := mc.EnsurePluginIndex(ctx); err != nil {
+		return err
+	}
+
+	for i := 0; i < savePluginVersionRetries; i++ {
+		latest, err := mc.latestPlugin(ctx, plugin.PluginKey)
+		if err != nil {
+			return err
+		}
+
+		plugin.Version = latest.Version + 1
+		_, err = mc.plugins().InsertOne(ctx, plugin)
+		if mongo.IsDuplicateKeyError(err) {
+			mc.log("plugin %d version %d is taken, retry", plugin.PluginKey, plugin.Version)
+			continue
+		}
+		if err != nil {
+			mc.error("save plugin %d version %d error: %v", plugin.PluginKey, plugin.Version, err)
+			return errors.WithMessage(err, "save plugin version error")
+		}
+		return nil
+	}
+
+	return errors.Errorf("save plugin version error: no free version after %d retries", savePluginVersionRetries)
+}
+
+// DeletePlugin deletes all the versions of the plugin
+func (mc *MongoCli) DeletePlugin(ctx context.Context, id int) error {
+	ctx, cancel := opContext(ctx)
+	defer cancel()
+
+	res, err := mc.plugins().DeleteMany(ctx, bson.M{"plugin_key": id})
+	if err != nil {
+		mc.error("delete plugin %d error: %v", id, err)
+		return errors.WithMessage(err, "delete plugin error")
+	}
+
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package driver
+
+import (
+	"context"
+
+	"github.com/andy-zhangtao/Functions/types"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func (mc *MongoCli) runs() *mongo.Collection {
+	return mc.cli.Database(mc.db).Collection(types.MongoDBWorkFlowRuns)
+}
+
+// SaveRun saves the paused execution
+func (mc *MongoCli) SaveRun(ctx context.Context, run *types.WorkFlowRun) error {
+	ctx, cancel := opContext(ctx)
+	defer cancel()
+
+	if _, err := mc.runs().InsertOne(ctx, run); err != nil {
+		mc.error("save run of workflow %s error: %v", run.WorkFlowID, err)
+		return errors.WithMessage(err, "save run error")
+	}
+	return nil
+}
+
+// GetRun returns the run of the token
+func (mc *MongoCli) GetRun(ctx context.Context, token string) (*types.WorkFlowRun, error) {
+	ctx, cancel := opContext(ctx)
+	defer cancel()
+
+	var run types.WorkFlowRun
+	err := mc.runs().FindOne(ctx, bson.M{"token": token}).Decode(&run)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		mc.error("get run %s error: %v", token, err)
+		return nil, errors.WithMessage(err, "get run error")
+	}
+	return &run, nil
+}
+
+// FinishRun sets the status of the run only if it's still pending
+func (mc *MongoCli) FinishRun(ctx context.Context, token, status string) error {
+	ctx, cancel := opContext(ctx)
+	defer cancel()
+
+	res, err := mc.runs().UpdateOne(ctx,
+		bson.M{"token": token, "status": types.RunStatusPending},
+		bson.M{"$set": bson.M{"status": status}})
+	if err != nil {
+		mc.error("finish run %s error: %v", token, err)
+		return errors.WithMessage(err, "finish run error")
+	}
+
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
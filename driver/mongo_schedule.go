@@ -0,0 +1,164 @@
+package driver
+
+import (
+	"context"
+
+	"github.com/andy-zhangtao/Functions/types"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func (mc *MongoCli) schedules() *mongo.Collection {
+	return mc.cli.Database(mc.db).Collection(types.MongoDBSchedules)
+}
+
+func (mc *MongoCli) scheduleRuns() *mongo.Collection {
+	return mc.cli.Database(mc.db).Collection(types.MongoDBScheduleRuns)
+}
+
+// SaveSchedule saves the schedule, an object id is assigned as ID if it's empty
+func (mc *MongoCli) SaveSchedule(ctx context.Context, schedule *types.Schedule) error {
+	ctx, cancel := opContext(ctx)
+	defer cancel()
+
+	if schedule.ID == "" {
+		schedule.ID = primitive.NewObjectID().Hex()
+	}
+
+	if _, err := mc.schedules().InsertOne(ctx, schedule); err != nil {
+		mc.error("save schedule of %s error: %v", schedule.User, err)
+		return errors.WithMessage(err, "save schedule error")
+	}
+	return nil
+}
+
+// GetSchedule returns the schedule of the user
+func (mc *MongoCli) GetSchedule(ctx context.Context, id, user string) (*types.Schedule, error) {
+	ctx, cancel := opContext(ctx)
+	defer cancel()
+
+	var schedule types.Schedule
+	err := mc.schedules().FindOne(ctx, bson.M{"id": id, "user": user}).Decode(&schedule)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		mc.error("get schedule %s error: %v", id, err)
+		return nil, errors.WithMessage(err, "get schedule error")
+	}
+	return &schedule, nil
+}
+
+// ListSchedules returns the schedules of the user
+func (mc *MongoCli) ListSchedules(ctx context.Context, user string) ([]types.Schedule, error) {
+	return mc.findSchedules(ctx, bson.M{"user": user})
+}
+
+// DueSchedules returns the enabled schedules whose NextRun is not after now
+func (mc *MongoCli) DueSchedules(ctx context.Context, now int64) ([]types.Schedule, error) {
+	return mc.findSchedules(ctx, bson.M{"enabled": true, "next_run": bson.M{"$lte": now}})
+}
+
+func (mc *MongoCli) findSchedules(ctx context.Context, filter bson.M) ([]types.Schedule, error) {
+	ctx, cancel := opContext(ctx)
+	defer cancel()
+
+	cursor, err := mc.schedules().Find(ctx, filter)
+	if err != nil {
+		mc.error("find schedules %v error: %v", filter, err)
+		return nil, errors.WithMessage(err, "find schedules error")
+	}
+
+	var schedules []types.Schedule
+	if err := cursor.All(ctx, &schedules); err != nil {
+		return nil, errors.WithMessage(err, "decode schedules error")
+	}
+	return schedules, nil
+}
+
+// UpdateSchedule replaces the schedule with the same ID and user
+func (mc *MongoCli) UpdateSchedule(ctx context.Context, schedule *types.Schedule) error {
+	ctx, cancel := opContext(ctx)
+	defer cancel()
+
+	res, err := mc.schedules().ReplaceOne(ctx, bson.M{"id": schedule.ID, "user": schedule.User}, schedule)
+	if err != nil {
+		mc.error("update schedule %s error: %v", schedule.ID, err)
+		return errors.WithMessage(err, "update schedule error")
+	}
+
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteSchedule deletes the schedule of the user, its runs are kept
+func (mc *MongoCli) DeleteSchedule(ctx context.Context, id, user string) error {
+	ctx, cancel := opContext(ctx)
+	defer cancel()
+
+	res, err := mc.schedules().DeleteOne(ctx, bson.M{"id": id, "user": user})
+	if err != nil {
+		mc.error("delete schedule %s error: %v", id, err)
+		return errors.WithMessage(err, "delete schedule error")
+	}
+
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ClaimSchedule moves NextRun only if it's still prev, the instance which moves it executes the run
+func (mc *MongoCli) ClaimSchedule(ctx context.Context, id string, prev, next, now int64) error {
+	ctx, cancel := opContext(ctx)
+	defer cancel()
+
+	res, err := mc.schedules().UpdateOne(ctx,
+		bson.M{"id": id, "next_run": prev},
+		bson.M{"$set": bson.M{"next_run": next, "last_run": now}})
+	if err != nil {
+		mc.error("claim schedule %s error: %v", id, err)
+		return errors.WithMessage(err, "claim schedule error")
+	}
+
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SaveScheduleRun saves the run history of the schedule
+func (mc *MongoCli) SaveScheduleRun(ctx context.Context, run *types.ScheduleRun) error {
+	ctx, cancel := opContext(ctx)
+	defer cancel()
+
+	if _, err := mc.scheduleRuns().InsertOne(ctx, run); err != nil {
+		mc.error("save run of schedule %s error: %v", run.ScheduleID, err)
+		return errors.WithMessage(err, "save schedule run error")
+	}
+	return nil
+}
+
+// ListScheduleRuns returns the latest runs of the schedule
+func (mc *MongoCli) ListScheduleRuns(ctx context.Context, id string, limit int) ([]types.ScheduleRun, error) {
+	ctx, cancel := opContext(ctx)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.M{"started_at": -1}).SetLimit(int64(limit))
+	cursor, err := mc.scheduleRuns().Find(ctx, bson.M{"schedule_id": id}, opts)
+	if err != nil {
+		mc.error("list runs of schedule %s error: %v", id, err)
+		return nil, errors.WithMessage(err, "list schedule runs error")
+	}
+
+	var runs []types.ScheduleRun
+	if err := cursor.All(ctx, &runs); err != nil {
+		return nil, errors.WithMessage(err, "decode schedule runs error")
+	}
+	return runs, nil
+}
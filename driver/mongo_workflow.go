@@ -0,0 +1,331 @@
+package driver
+
+import (
+	"context"
+
+	"github.com/andy-zhangtao/Functions/types"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// updateWorkFlowRetries is how many times UpdateWorkFlow retries when the new version is taken concurrently
+const updateWorkFlowRetries = 5
+
+func (mc *MongoCli) workflows() *mongo.Collection {
+	return mc.cli.Database(mc.db).Collection(types.MongoDBWorkFlow)
+}
+
+func (mc *MongoCli) workflowVersions() *mongo.Collection {
+	return mc.cli.Database(mc.db).Collection(types.MongoDBWorkFlowVersions)
+}
+
+// EnsureWorkFlowVersionIndex creates the unique index of id and version once per process,
+// so two concurrent updates can't save the same version
+func (mc *MongoCli) EnsureWorkFlowVersionIndex(ctx context.Context) error {
+	return ensureIndex(ctx, mc.workflowVersions(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "id", Value: 1}, {Key: "version", Value: 1}},
+		Options: options.Index().SetUnique(true).SetName("id_version"),
+	})
+}
+
+// EnsureWorkFlowNameIndex creates the unique index of user and name once per process,
+// so two concurrent creates can't save the same name. The legacy workflows without name are not indexed.
+func (mc *MongoCli) EnsureWorkFlowNameIndex(ctx context.Context) error {
+	err := ensureIndex(ctx, mc.workflows(), mongo.IndexModel{
+		Keys: bson.D{{Key: "user", Value: 1}, {Key: "name", Value: 1}},
+		Options: options.Index().SetUnique(true).SetName("user_name").
+			SetPartialFilterExpression(bson.M{"name": bson.M{"$gt": ""}}),
+	})
+	return errors.WithMessage(err, "run -migrate to rename the duplicate workflows")
+}
+
+// GetWorkFlowByID fetches a WorkFlow by its ID from MongoDB
+func (mc *MongoCli) GetWorkFlowByID(ctx context.Context, id string) (*types.WorkFlow, error) {
+	mc.log("get workflow with id: %s", id)
+	ctx, cancel := opContext(ctx)
+	defer cancel()
+
+	var workflow types.WorkFlow
+
+	err := mc.workflows().FindOne(ctx, bson.M{"id": id}).Decode(&workflow)
+	if err != nil {
+		mc.error("get workflow with id: %s error: %v", id, err)
+		return nil, errors.WithMessage(err, "get workflow error")
+	}
+
+	return &workflow, nil
+}
+
+// SaveWorkFlow saves the workflow as version 1, an object id is assigned as ID if it's empty.
+// The unique index rejects a name taken by a concurrent create with ErrExists.
+func (mc *MongoCli) SaveWorkFlow(ctx context.Context, flow *types.WorkFlow) error {
+	if flow.ID == "" {
+		flow.ID = primitive.NewObjectID().Hex()
+	}
+
+	ctx, cancel := opContext(ctx)
+	defer cancel()
+
+	if err := mc.EnsureWorkFlowNameIndex(ctx); err != nil {
+		return err
+	}
+
+	flow.Version = 1
+	_, err := mc.workflows().InsertOne(ctx, flow)
+	if mongo.IsDuplicateKeyError(err) {
+		return errors.WithMessagef(ErrExists, "workflow %s of %s", flow.Name, flow.User)
+	}
+	if err != nil {
+		mc.error("save workflow %s of %s error: %v", flow.Name, flow.User, err)
+		return errors.WithMessage(err, "save workflow error")
+	}
+
+	if err := mc.saveWorkFlowVersion(ctx, flow); err != nil {
+		// remove the workflow so the create fails as a whole
+		if _, derr := mc.workflows().DeleteOne(ctx, bson.M{"id": flow.ID}); derr != nil {
+			mc.error("remove workflow %s without version error: %v", flow.ID, derr)
+		}
+		return err
+	}
+	return nil
+}
+
+// FindWorkFlow finds the workflow by name and user, nil is returned if not found
+func (mc *MongoCli) FindWorkFlow(ctx context.Context, name, user string) (*types.WorkFlow, error) {
+	ctx, cancel := opContext(ctx)
+	defer cancel()
+
+	flow := &types.WorkFlow{}
+	err := mc.workflows().FindOne(ctx, bson.M{"name": name, "user": user}).Decode(flow)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			// This error means your query did not match any documents.
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return flow, nil
+}
+
+// FindWorkFlows finds all the workflows of the user
+func (mc *MongoCli) FindWorkFlows(ctx context.Context, user string) ([]*types.WorkFlow, error) {
+	ctx, cancel := opContext(ctx)
+	defer cancel()
+
+	var flows []*types.WorkFlow
+	cursor, err := mc.workflows().Find(ctx, bson.M{"user": user})
+	if err != nil {
+		return nil, err
+	}
+
+	err = cursor.All(ctx, &flows)
+	return flows, err
+}
+
+// FindTriggered finds the workflows of the user triggered by the event
+func (mc *MongoCli) FindTriggered(ctx context.Context, event, user string) ([]*types.WorkFlow, error) {
+	ctx, cancel := opContext(ctx)
+	defer cancel()
+
+	var flows []*types.WorkFlow
+	cursor, err := mc.workflows().Find(ctx, bson.M{"user": user, "triggers.event": event})
+	if err != nil {
+		mc.error("find workflows of %s triggered by %s error: %v", user, event, err)
+		return nil, errors.WithMessage(err, "find triggered workflows error")
+	}
+
+	err = cursor.All(ctx, &flows)
+	return flows, err
+}
+
+// UpdateWorkFlow saves the workflow with the same name and user as the next version and replaces the current one,
+// the ID is kept. The current one is only replaced by a later version, so a slower concurrent update
+// can't make its earlier version current again, ErrConflict is returned to it instead.
+func (mc *MongoCli) UpdateWorkFlow(ctx context.Context, flow *types.WorkFlow) error {
+	exist, err := mc.FindWorkFlow(ctx, flow.Name, flow.User)
+	if err != nil {
+		mc.error("find workflow %s of %s error: %v", flow.Name, flow.User, err)
+		return errors.WithMessage(err, "update workflow error")
+	}
+	if exist == nil {
+		return ErrNotFound
+	}
+
+	flow.ID = exist.ID
+	if err := mc.saveNextWorkFlowVersion(ctx, exist, flow); err != nil {
+		return err
+	}
+
+	ctx, cancel := opContext(ctx)
+	defer cancel()
+
+	// the workflows saved before the versions have no version
+	filter := bson.M{"id": flow.ID, "$or": bson.A{
+		bson.M{"version": bson.M{"$lt": flow.Version}},
+		bson.M{"version": bson.M{"$exists": false}},
+	}}
+	res, err := mc.workflows().ReplaceOne(ctx, filter, flow)
+	if err != nil {
+		mc.error("update workflow %s of %s error: %v", flow.Name, flow.User, err)
+		return errors.WithMessage(err, "update workflow error")
+	}
+
+	if res.MatchedCount == 0 {
+		mc.log("workflow %s version %d is saved but a later version is current", flow.ID, flow.Version)
+		return errors.WithMessagef(ErrConflict, "workflow %s was updated concurrently, version %d is not current", flow.Name, flow.Version)
+	}
+	return nil
+}
+
+// saveNextWorkFlowVersion saves flow as the version after the last one.
+// The unique index rejects a version taken by a concurrent update, then the next version is tried.
+func (mc *MongoCli) saveNextWorkFlowVersion(ctx context.Context, exist, flow *types.WorkFlow) error {
+	for i := 0; i < updateWorkFlowRetries; i++ {
+		last, err := mc.lastWorkFlowVersion(ctx, exist)
+		if err != nil {
+			return err
+		}
+
+		flow.Version = last + 1
+		err = mc.saveWorkFlowVersion(ctx, flow)
+		if mongo.IsDuplicateKeyError(err) {
+			mc.log("workflow %s version %d is taken, retry", flow.ID, flow.Version)
+			continue
+		}
+		return err
+	}
+
+	return errors.Errorf("update workflow error: no free version after %d retries", updateWorkFlowRetries)
+}
+
+func (mc *MongoCli) saveWorkFlowVersion(ctx context.Context, flow *types.WorkFlow) error {
+	ctx, cancel := opContext(ctx)
+	defer cancel()
+
+	if err := mc.EnsureWorkFlowVersionIndex(ctx); err != nil {
+		return err
+	}
+
+	if _, err := mc.workflowVersions().InsertOne(ctx, flow); err != nil {
+		mc.error("save workflow %s version %d error: %v", flow.ID, flow.Version, err)
+		return errors.WithMessage(err, "save workflow version error")
+	}
+	return nil
+}
+
+// lastWorkFlowVersion returns the largest version of the workflow,
+// the workflow saved before the versions is saved as version 1 first
+func (mc *MongoCli) lastWorkFlowVersion(ctx context.Context, exist *types.WorkFlow) (int, error) {
+	ctx, cancel := opContext(ctx)
+	defer cancel()
+
+	var last types.WorkFlow
+	opts := options.FindOne().SetSort(bson.M{"version": -1})
+	err := mc.workflowVersions().FindOne(ctx, bson.M{"id": exist.ID}, opts).Decode(&last)
+	if err == mongo.ErrNoDocuments {
+		legacy := *exist
+		legacy.Version = 1
+		// a concurrent update may have saved it already
+		if err := mc.saveWorkFlowVersion(ctx, &legacy); err != nil && !mongo.IsDuplicateKeyError(err) {
+			return 0, err
+		}
+		return 1, nil
+	}
+	if err != nil {
+		mc.error("find the last version of workflow %s error: %v", exist.ID, err)
+		return 0, errors.WithMessage(err, "find the last workflow version error")
+	}
+	return last.Version, nil
+}
+
+// GetWorkFlowVersion returns the version of the workflow
+func (mc *MongoCli) GetWorkFlowVersion(ctx context.Context, id string, version int) (*types.WorkFlow, error) {
+	ctx, cancel := opContext(ctx)
+	defer cancel()
+
+	var flow types.WorkFlow
+	err := mc.workflowVersions().FindOne(ctx, bson.M{"id": id, "version": version}).Decode(&flow)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		mc.error("get workflow %s version %d error: %v", id, version, err)
+		return nil, errors.WithMessage(err, "get workflow version error")
+	}
+	return &flow, nil
+}
+
+// ListWorkFlowVersions returns the versions of the workflow, the latest first
+func (mc *MongoCli) ListWorkFlowVersions(ctx context.Context, id string) ([]types.WorkFlow, error) {
+	ctx, cancel := opContext(ctx)
+	defer cancel()
+
+	cursor, err := mc.workflowVersions().Find(ctx, bson.M{"id": id}, options.Find().SetSort(bson.M{"version": -1}))
+	if err != nil {
+		mc.error("list versions of workflow %s error: %v", id, err)
+		return nil, errors.WithMessage(err, "list workflow versions error")
+	}
+
+	var flows []types.WorkFlow
+	if err := cursor.All(ctx, &flows); err != nil {
+		return nil, errors.WithMessage(err, "decode workflow versions error")
+	}
+	return flows, nil
+}
+
+// RollbackWorkFlow replaces the current workflow with the version
+func (mc *MongoCli) RollbackWorkFlow(ctx context.Context, name, user string, version int) (*types.WorkFlow, error) {
+	exist, err := mc.FindWorkFlow(ctx, name, user)
+	if err != nil {
+		mc.error("find workflow %s of %s error: %v", name, user, err)
+		return nil, errors.WithMessage(err, "rollback workflow error")
+	}
+	if exist == nil {
+		return nil, ErrNotFound
+	}
+
+	flow, err := mc.GetWorkFlowVersion(ctx, exist.ID, version)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := opContext(ctx)
+	defer cancel()
+
+	_, err = mc.workflows().ReplaceOne(ctx, bson.M{"id": exist.ID}, flow)
+	if err != nil {
+		mc.error("rollback workflow %s to version %d error: %v", exist.ID, version, err)
+		return nil, errors.WithMessage(err, "rollback workflow error")
+	}
+	return flow, nil
+}
+
+// DeleteWorkFlow deletes the workflow by name and user along with its versions
+func (mc *MongoCli) DeleteWorkFlow(ctx context.Context, name, user string) error {
+	exist, err := mc.FindWorkFlow(ctx, name, user)
+	if err != nil {
+		mc.error("find workflow %s of %s error: %v", name, user, err)
+		return errors.WithMessage(err, "delete workflow error")
+	}
+	if exist == nil {
+		return ErrNotFound
+	}
+
+	ctx, cancel := opContext(ctx)
+	defer cancel()
+
+	if _, err := mc.workflows().DeleteOne(ctx, bson.M{"id": exist.ID}); err != nil {
+		mc.error("delete workflow %s of %s error: %v", name, user, err)
+		return errors.WithMessage(err, "delete workflow error")
+	}
+
+	if _, err := mc.workflowVersions().DeleteMany(ctx, bson.M{"id": exist.ID}); err != nil {
+		mc.error("delete versions of workflow %s error: %v", exist.ID, err)
+		return errors.WithMessage(err, "delete workflow versions error")
+	}
+	return nil
+}
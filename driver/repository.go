@@ -0,0 +1,201 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	fformat "github.com/andy-zhangtao/Functions/service/f_format"
+	"github.com/andy-zhangtao/Functions/types"
+)
+
+// ErrNotFound is returned when the record to update or delete does not exist
+var ErrNotFound = errors.New("not found")
+
+// ErrConflict is returned when a concurrent update changed the record first
+var ErrConflict = errors.New("conflict")
+
+// ErrExists is returned when the unique name of the record to save is taken
+var ErrExists = errors.New("already exists")
+
+// OpTimeout bounds a store operation whose context has no deadline
+const OpTimeout = 5 * time.Second
+
+// opContext returns a context of ctx bounded by OpTimeout if ctx has no deadline,
+// the deadline of ctx, e.g. the timeout of a workflow step, is kept as it is
+func opContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, OpTimeout)
+}
+
+// DiaryRepository stores and searches the diaries
+type DiaryRepository interface {
+	// SaveDiary stores the diary and returns its id, the mask is stored along with the diary if supported
+	SaveDiary(ctx context.Context, diary types.Diary, mask map[string]interface{}) (string, error)
+	QueryDiary(ctx context.Context, query types.DirayQueryModel) (types.DirayQueryResponse, error)
+	// UpdateDiary replaces the diary of the id returned by the vector store, ErrNotFound is returned
+	// if the diary does not exist or belongs to another user
+	UpdateDiary(ctx context.Context, id string, diary types.Diary) error
+}
+
+// WorkflowRepository stores the workflows, a workflow is identified by its ID or by its name and user.
+// Every saved definition is kept as an immutable version, the workflow returned by the finders is the current version.
+type WorkflowRepository interface {
+	GetWorkFlowByID(ctx context.Context, id string) (*types.WorkFlow, error)
+	// SaveWorkFlow saves a new workflow as version 1, an ID is assigned if it's empty.
+	// ErrExists is returned if the user has a workflow of the name.
+	SaveWorkFlow(ctx context.Context, flow *types.WorkFlow) error
+	// FindWorkFlow returns nil if the workflow is not found
+	FindWorkFlow(ctx context.Context, name, user string) (*types.WorkFlow, error)
+	FindWorkFlows(ctx context.Context, user string) ([]*types.WorkFlow, error)
+	// FindTriggered returns the workflows of the user with a trigger of the event
+	FindTriggered(ctx context.Context, event, user string) ([]*types.WorkFlow, error)
+	// UpdateWorkFlow saves the workflow with the same name and user as the next version and makes it current,
+	// ErrNotFound is returned if not found, ErrConflict if a concurrent update made a later version current
+	UpdateWorkFlow(ctx context.Context, flow *types.WorkFlow) error
+	// GetWorkFlowVersion returns ErrNotFound if the version does not exist
+	GetWorkFlowVersion(ctx context.Context, id string, version int) (*types.WorkFlow, error)
+	// ListWorkFlowVersions returns all the versions of the workflow, the latest first
+	ListWorkFlowVersions(ctx context.Context, id string) ([]types.WorkFlow, error)
+	// RollbackWorkFlow makes the version of the workflow current again and returns it,
+	// ErrNotFound is returned if the workflow or the version does not exist
+	RollbackWorkFlow(ctx context.Context, name, user string, version int) (*types.WorkFlow, error)
+	// DeleteWorkFlow deletes the workflow and its versions, ErrNotFound is returned if the workflow is not found
+	DeleteWorkFlow(ctx context.Context, name, user string) error
+}
+
+// RunRepository stores the workflow executions paused by the approval steps
+type RunRepository interface {
+	SaveRun(ctx context.Context, run *types.WorkFlowRun) error
+	// GetRun returns ErrNotFound if the token does not exist
+	GetRun(ctx context.Context, token string) (*types.WorkFlowRun, error)
+	// FinishRun sets the status of the pending run, ErrNotFound is returned if it's not pending,
+	// so a run is resumed only once
+	FinishRun(ctx context.Context, token, status string) error
+}
+
+// ScheduleRepository stores the cron schedules of the workflows and their runs
+type ScheduleRepository interface {
+	// SaveSchedule saves a new schedule, an ID is assigned if it's empty
+	SaveSchedule(ctx context.Context, schedule *types.Schedule) error
+	// GetSchedule returns ErrNotFound if the schedule of the user does not exist
+	GetSchedule(ctx context.Context, id, user string) (*types.Schedule, error)
+	ListSchedules(ctx context.Context, user string) ([]types.Schedule, error)
+	// UpdateSchedule replaces the schedule with the same ID and user, ErrNotFound is returned if not found
+	UpdateSchedule(ctx context.Context, schedule *types.Schedule) error
+	// DeleteSchedule returns ErrNotFound if the schedule of the user does not exist
+	DeleteSchedule(ctx context.Context, id, user string) error
+	// DueSchedules returns the enabled schedules whose NextRun is not after now
+	DueSchedules(ctx context.Context, now int64) ([]types.Schedule, error)
+	// ClaimSchedule moves NextRun of the schedule from prev to next and sets LastRun to now.
+	// ErrNotFound is returned if NextRun is not prev any more, so a run is executed only once.
+	ClaimSchedule(ctx context.Context, id string, prev, next, now int64) error
+	SaveScheduleRun(ctx context.Context, run *types.ScheduleRun) error
+	// ListScheduleRuns returns the latest limit runs of the schedule, the latest first
+	ListScheduleRuns(ctx context.Context, id string, limit int) ([]types.ScheduleRun, error)
+}
+
+// LockRepository provides the locks shared by the server instances, a lock expires if it's not renewed
+type LockRepository interface {
+	// AcquireLock takes or renews the lock for owner until ttl later, false is returned if another owner holds it
+	AcquireLock(ctx context.Context, name, owner string, ttl time.Duration) (bool, error)
+	// ReleaseLock releases the lock if it's held by owner
+	ReleaseLock(ctx context.Context, name, owner string) error
+}
+
+// PluginRepository stores the plugin definitions, the versions are immutable and the latest one is current
+type PluginRepository interface {
+	// GetPluginByPluginKey returns the latest version of the plugin, it's empty if not found
+	GetPluginByPluginKey(ctx context.Context, id int) ([]types.Plugin, error)
+	// GetPluginVersion returns ErrNotFound if the version does not exist
+	GetPluginVersion(ctx context.Context, id, version int) (*types.Plugin, error)
+	// ListPlugins returns the latest version of the plugins, all the modules if module is empty
+	ListPlugins(ctx context.Context, module string) ([]types.Plugin, error)
+	// CreatePlugin assigns a new unique PluginKey and saves the plugin as version 1
+	CreatePlugin(ctx context.Context, plugin *types.Plugin) error
+	// InsertPlugin saves the plugin as version 1 with its own PluginKey, an error is returned if the key is taken
+	InsertPlugin(ctx context.Context, plugin *types.Plugin) error
+	// SavePluginVersion saves the plugin as the next version, ErrNotFound is returned if not found
+	SavePluginVersion(ctx context.Context, plugin *types.Plugin) error
+	// DeletePlugin deletes all the versions, ErrNotFound is returned if not found
+	DeletePlugin(ctx context.Context, id int) error
+}
+
+// FormatRepository stores the format templates
+type FormatRepository interface {
+	// FormatAction add, query, update or delete the format by fm.Action
+	FormatAction(ctx context.Context, fm *fformat.FormatModel) error
+	// GetFormat returns fformat.ErrNotFound if the format is not found
+	GetFormat(ctx context.Context, user, tags string) (*fformat.FormatModel, error)
+	ListFormat(ctx context.Context, user, tags string) ([]*fformat.FormatModel, error)
+}
+
+// Repositories bundles the repositories used by the services
+type Repositories struct {
+	Diary DiaryRepository
+	// DiaryArchive keeps a copy of the diaries, it's mongo by default
+	DiaryArchive DiaryRepository
+	Workflow     WorkflowRepository
+	Run          RunRepository
+	Schedule     ScheduleRepository
+	Lock         LockRepository
+	Plugin       PluginRepository
+	Format       FormatRepository
+}
+
+var (
+	_ DiaryRepository    = (*WeaviateClient)(nil)
+	_ DiaryRepository    = (*MongoCli)(nil)
+	_ WorkflowRepository = (*MongoCli)(nil)
+	_ RunRepository      = (*MongoCli)(nil)
+	_ ScheduleRepository = (*MongoCli)(nil)
+	_ LockRepository     = (*MongoCli)(nil)
+	_ PluginRepository   = (*MongoCli)(nil)
+	_ FormatRepository   = (*MongoCli)(nil)
+)
+
+// NewRepositoriesFromEnv creates the repositories backed by mongo and weaviate with the env config.
+// The process wide in-memory repositories are returned when STORAGE_BACKEND is memory.
+func NewRepositoriesFromEnv() (*Repositories, error) {
+	if os.Getenv(types.EnvStorageBackend) == types.StorageBackendMemory {
+		return SharedMemoryRepositories(), nil
+	}
+
+	mc, err := NewMongoCli(MongoCliConf{
+		Uri:        os.Getenv(types.EnvMONGOHOST),
+		DB:         os.Getenv(types.EnvMONGODB),
+		Collection: os.Getenv(types.EnvMONGOCOLLECTION),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create mongo client error: %w", err)
+	}
+
+	formatCollection := os.Getenv(types.EnvMONGOFORMATCOLLECTION)
+	if formatCollection == "" {
+		formatCollection = types.MongoDBFormats
+	}
+
+	wc, err := NewWeaviateClient(WeaviateClientConf{
+		Host:   os.Getenv(types.EnvWeaviateHost),
+		Schema: os.Getenv(types.EnvWeaviateSchema),
+		Key:    os.Getenv(types.EnvWewaviateKey),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create weaviate client error: %w", err)
+	}
+
+	return &Repositories{
+		Diary:        wc,
+		DiaryArchive: mc,
+		Workflow:     mc,
+		Run:          mc,
+		Schedule:     mc,
+		Lock:         mc,
+		Plugin:       mc,
+		Format:       mc.WithCollection(formatCollection),
+	}, nil
+}
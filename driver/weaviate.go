@@ -1,10 +1,23 @@
 package driver
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/andy-zhangtao/Functions/types"
+	"github.com/sirupsen/logrus"
 	"github.com/weaviate/weaviate-go-client/v4/weaviate"
-	"github.com/weaviate/weaviate-go-client/v4/weaviate/auth"
+	"github.com/weaviate/weaviate-go-client/v4/weaviate/data"
+	"github.com/weaviate/weaviate-go-client/v4/weaviate/fault"
+	"github.com/weaviate/weaviate-go-client/v4/weaviate/filters"
+	"github.com/weaviate/weaviate-go-client/v4/weaviate/graphql"
+	"github.com/weaviate/weaviate/entities/models"
 )
 
 type WeaviateClient struct {
@@ -18,17 +31,401 @@ type WeaviateClientConf struct {
 }
 
 func NewWeaviateClient(conf WeaviateClientConf) (*WeaviateClient, error) {
-	cfg := weaviate.Config{
-		Host:       conf.Host,
-		Scheme:     conf.Schema,
-		AuthConfig: auth.ApiKey{Value: conf.Key},
-	}
-	client, err := weaviate.NewClient(cfg)
+	client, err := SharedWeaviate(conf)
 	if err != nil {
-		return nil, fmt.Errorf("could not create weaviate client: %v", err)
+		return nil, err
 	}
 
 	return &WeaviateClient{
 		client: client,
 	}, nil
 }
+
+// Ready checks whether weaviate is ready to serve
+func (wc *WeaviateClient) Ready(ctx context.Context) error {
+	ready, err := wc.client.Misc().ReadyChecker().Do(ctx)
+	if err != nil {
+		return fmt.Errorf("could not check weaviate: %v", err)
+	}
+
+	if !ready {
+		return fmt.Errorf("weaviate is not ready")
+	}
+	return nil
+}
+
+// EnsureDiarySchema creates or migrates the Diary class before it is used
+func (wc *WeaviateClient) EnsureDiarySchema(ctx context.Context) error {
+	return NewSchemaManager(wc.client).EnsureOnce(ctx, DiaryClass())
+}
+
+// SaveDiary implements DiaryRepository, the mask is not stored in weaviate
+func (wc *WeaviateClient) SaveDiary(ctx context.Context, diary types.Diary, mask map[string]interface{}) (string, error) {
+	if err := wc.EnsureDiarySchema(ctx); err != nil {
+		return "", fmt.Errorf("could not ensure diary schema: %v", err)
+	}
+
+	created, err := wc.AddNewRecord(ctx, types.DiaryClassName, diary.Properties())
+	if err != nil {
+		return "", err
+	}
+
+	return created.Object.ID.String(), nil
+}
+
+// UpdateDiary implements DiaryRepository
+func (wc *WeaviateClient) UpdateDiary(ctx context.Context, id string, diary types.Diary) error {
+	if err := wc.EnsureDiarySchema(ctx); err != nil {
+		return fmt.Errorf("could not ensure diary schema: %v", err)
+	}
+
+	objects, err := wc.client.Data().ObjectsGetter().WithClassName(types.DiaryClassName).WithID(id).Do(ctx)
+	if isWeaviateNotFound(err) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("could not get record %s: %v", id, err)
+	}
+
+	if props, ok := objects[0].Properties.(map[string]interface{}); !ok || props[types.DiaryPropUser] != diary.User {
+		return ErrNotFound
+	}
+
+	err = wc.client.Data().Updater().WithClassName(types.DiaryClassName).WithID(id).WithProperties(diary.Properties()).Do(ctx)
+	if isWeaviateNotFound(err) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("could not update record %s: %v", id, err)
+	}
+	return nil
+}
+
+func isWeaviateNotFound(err error) bool {
+	var fe *fault.WeaviateClientError
+	return errors.As(err, &fe) && fe.StatusCode == http.StatusNotFound
+}
+
+// QueryDiary implements DiaryRepository
+func (wc *WeaviateClient) QueryDiary(ctx context.Context, query types.DirayQueryModel) (types.DirayQueryResponse, error) {
+	return wc.GetRecords(ctx, types.DiaryClassName, query)
+}
+
+func (wc *WeaviateClient) AddNewRecord(ctx context.Context, class string, properties map[string]interface{}) (*data.ObjectWrapper, error) {
+	data := make(map[string]interface{})
+
+	for key, val := range properties {
+		data[key] = val
+	}
+
+	created, err := wc.client.Data().Creator().WithClassName(class).WithProperties(data).Do(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not create record: %v", err)
+	}
+
+	logrus.Infof("Created record with id [%+v]", created)
+	return created, nil
+}
+
+// GetRecords get records
+// @Summary get records
+// @Description get records via filter
+// Query is the filter condition
+// When Keys is set, the records are searched by Mode:
+//   - near_text: vector search filtered by MaxDistance/Certainty
+//   - hybrid: BM25 and vector search fused by Alpha
+//   - bm25: keyword search only
+//
+// otherwise all the records match the filter are returned.
+// A search sorted by date sorts the Limit most relevant records, so it can not be paged by Offset.
+func (wc *WeaviateClient) GetRecords(ctx context.Context, class string, query types.DirayQueryModel) (results types.DirayQueryResponse, err error) {
+	if err := checkDiaryQuery(query); err != nil {
+		return results, err
+	}
+
+	semantic := len(query.Keys) > 0
+
+	mode := query.Mode
+	if mode == "" {
+		mode = types.DiarySearchNearText
+	}
+
+	sortBy := query.Sort
+	if sortBy == "" {
+		sortBy = types.DiarySortDate
+		if semantic {
+			sortBy = types.DiarySortRelevance
+		}
+	}
+
+	order := query.Order
+	if order == "" {
+		order = types.DiaryOrderDesc
+	}
+
+	where, err := wc.where(query)
+	if err != nil {
+		return results, err
+	}
+
+	filterCondition := wc.client.GraphQL().Get().WithClassName(class).WithWhere(where).WithFields(diaryFields(semantic, mode)...)
+
+	if semantic {
+		switch mode {
+		case types.DiarySearchNearText:
+			text := graphql.NearTextArgumentBuilder{}
+			text.WithConcepts(query.Keys)
+			switch {
+			case query.Certainty != nil:
+				text.WithCertainty(*query.Certainty)
+			case query.MaxDistance != nil:
+				text.WithDistance(*query.MaxDistance)
+			default:
+				text.WithDistance(types.DefaultDiaryMaxDistance)
+			}
+			filterCondition.WithNearText(&text)
+		case types.DiarySearchHybrid:
+			hybrid := &graphql.HybridArgumentBuilder{}
+			hybrid.WithQuery(strings.Join(query.Keys, " "))
+			if query.Alpha != nil {
+				hybrid.WithAlpha(*query.Alpha)
+			}
+			filterCondition.WithHybrid(hybrid)
+		case types.DiarySearchBM25:
+			bm25 := &graphql.BM25ArgumentBuilder{}
+			bm25.WithQuery(strings.Join(query.Keys, " ")).WithProperties(types.DiaryPropTitle, types.DiaryPropContent, types.DiaryPropTags)
+			filterCondition.WithBM25(bm25)
+		}
+	}
+
+	// weaviate can not sort the search results, the date sort of a search is applied to the returned records
+	if sortBy == types.DiarySortDate && !semantic {
+		filterCondition.WithSort(graphql.Sort{Path: []string{types.DiaryPropDate}, Order: graphql.SortOrder(order)})
+	}
+
+	if query.Limit > 0 {
+		filterCondition.WithLimit(query.Limit)
+	}
+
+	if query.Offset > 0 {
+		filterCondition.WithOffset(query.Offset)
+	}
+
+	response, err := filterCondition.Do(ctx)
+	if err != nil {
+		return results, fmt.Errorf("could not get records: %v", err)
+	}
+
+	if len(response.Errors) > 0 {
+		return results, fmt.Errorf("could not get records: %s", response.Errors[0].Message)
+	}
+
+	var records []types.DiaryRecord
+	for _, item := range response.Data {
+		_records, err := wc.parser(class, item)
+		if err != nil {
+			logrus.Errorf("could not parse object: %v", err)
+			continue
+		}
+
+		records = append(records, _records...)
+	}
+
+	switch {
+	case sortBy == types.DiarySortDate && semantic:
+		sort.SliceStable(records, func(i, j int) bool {
+			if order == types.DiaryOrderAsc {
+				return records[i].Date < records[j].Date
+			}
+			return records[i].Date > records[j].Date
+		})
+	case sortBy == types.DiarySortRelevance && order == types.DiaryOrderAsc:
+		// weaviate returns the most relevant first
+		for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+			records[i], records[j] = records[j], records[i]
+		}
+	}
+
+	contentData := make([]string, 0, len(records))
+	for _, r := range records {
+		contentData = append(contentData, fmt.Sprintf("%s记录的内容是  \n%s", time.Unix(r.Date, 0).Format("2006-01-02"), r.Content))
+	}
+
+	return types.DirayQueryResponse{
+		Records: contentData,
+		Results: records,
+	}, nil
+}
+
+// checkDiaryQuery rejects the unknown options and the options which have no effect on the query,
+// every DiaryRepository searching by keys checks the query with it
+func checkDiaryQuery(query types.DirayQueryModel) error {
+	switch query.Mode {
+	case "", types.DiarySearchNearText, types.DiarySearchHybrid, types.DiarySearchBM25:
+	default:
+		return fmt.Errorf("not support mode: %s", query.Mode)
+	}
+
+	switch query.Sort {
+	case "", types.DiarySortDate, types.DiarySortRelevance:
+	default:
+		return fmt.Errorf("not support sort: %s", query.Sort)
+	}
+
+	switch query.Order {
+	case "", types.DiaryOrderAsc, types.DiaryOrderDesc:
+	default:
+		return fmt.Errorf("not support order: %s", query.Order)
+	}
+
+	if query.Alpha != nil && (*query.Alpha < 0 || *query.Alpha > 1) {
+		return fmt.Errorf("alpha must be between 0 and 1")
+	}
+
+	if query.Sort == types.DiarySortRelevance && len(query.Keys) == 0 {
+		return fmt.Errorf("sort by relevance requires keys")
+	}
+
+	if query.MaxDistance != nil && query.Certainty != nil {
+		return fmt.Errorf("max_distance and certainty can not be used together")
+	}
+
+	if len(query.Keys) == 0 {
+		if query.MaxDistance != nil || query.Certainty != nil {
+			return fmt.Errorf("max_distance and certainty require keys")
+		}
+		return nil
+	}
+
+	// the thresholds only apply to the vector distance of near_text, hybrid and bm25 return a score
+	if query.Mode != "" && query.Mode != types.DiarySearchNearText && (query.MaxDistance != nil || query.Certainty != nil) {
+		return fmt.Errorf("max_distance and certainty are not supported by mode %s", query.Mode)
+	}
+
+	// weaviate can not sort the search results by date, they are sorted after the page is returned
+	if query.Sort == types.DiarySortDate && query.Offset > 0 {
+		return fmt.Errorf("a search sorted by date can not be paged by offset")
+	}
+	return nil
+}
+
+// where builds the user and date filter of the query
+func (wc *WeaviateClient) where(query types.DirayQueryModel) (*filters.WhereBuilder, error) {
+	var operands []*filters.WhereBuilder
+
+	userWherefilter := filters.Where()
+	userWherefilter.WithPath([]string{types.DiaryPropUser}).WithOperator(filters.Equal).WithValueText(query.User)
+
+	operands = append(operands, userWherefilter)
+
+	if query.Start != "" {
+		startWherefilter := filters.Where()
+		start, err := types.ParseDiaryDate(query.Start)
+		if err != nil {
+			return nil, fmt.Errorf("parse start time error: %w", err)
+		}
+
+		startWherefilter.WithPath([]string{types.DiaryPropDate}).WithOperator(filters.GreaterThanEqual).WithValueInt(start)
+		operands = append(operands, startWherefilter)
+	}
+
+	if query.End != "" {
+		endWherefilter := filters.Where()
+		end, err := types.ParseDiaryDate(query.End)
+		if err != nil {
+			return nil, fmt.Errorf("parse end time error: %w", err)
+		}
+
+		endWherefilter.WithPath([]string{types.DiaryPropDate}).WithOperator(filters.LessThanEqual).WithValueInt(end)
+		operands = append(operands, endWherefilter)
+	}
+
+	return filters.Where().WithOperator(filters.And).WithOperands(operands), nil
+}
+
+// diaryFields returns the graphql fields of a diary, the scores are only
+// available for the search modes.
+func diaryFields(scored bool, mode string) []graphql.Field {
+	additional := []graphql.Field{{Name: "id"}}
+	if scored {
+		switch mode {
+		case types.DiarySearchNearText:
+			additional = append(additional, graphql.Field{Name: "distance"}, graphql.Field{Name: "certainty"})
+		default:
+			additional = append(additional, graphql.Field{Name: "score"})
+		}
+	}
+
+	return []graphql.Field{
+		{Name: types.DiaryPropContent},
+		{Name: types.DiaryPropTitle},
+		{Name: types.DiaryPropUser},
+		{Name: types.DiaryPropTags},
+		{Name: types.DiaryPropDate},
+		{Name: "_additional", Fields: additional},
+	}
+}
+
+func (wc *WeaviateClient) parser(class string, object models.JSONObject) (result []types.DiaryRecord, err error) {
+	m, ok := object.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("could not parse object")
+	}
+
+	v, ok := m[class].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("could not parse object")
+	}
+
+	for _, val := range v {
+		m, ok := val.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("could not parse object")
+		}
+
+		var record types.DiaryRecord
+		for key, val := range m {
+			switch key {
+			case types.DiaryPropContent:
+				record.Content, _ = val.(string)
+			case types.DiaryPropTitle:
+				record.Title, _ = val.(string)
+			case types.DiaryPropUser:
+				record.User, _ = val.(string)
+			case types.DiaryPropTags:
+				tags, _ := val.([]interface{})
+				for _, t := range tags {
+					record.Tags = append(record.Tags, fmt.Sprintf("%v", t))
+				}
+			case types.DiaryPropDate:
+				v, ok := val.(float64)
+				if !ok {
+					return nil, fmt.Errorf("could not parse object %+v", val)
+				}
+				record.Date = int64(v)
+			case "_additional":
+				additional, _ := val.(map[string]interface{})
+				record.ID, _ = additional["id"].(string)
+				if distance, ok := additional["distance"].(float64); ok {
+					record.Distance = &distance
+				}
+				if certainty, ok := additional["certainty"].(float64); ok {
+					record.Certainty = &certainty
+				}
+				// weaviate returns the hybrid and BM25 score as a string
+				if score, ok := additional["score"].(string); ok {
+					if v, err := strconv.ParseFloat(score, 64); err == nil {
+						record.Score = &v
+					}
+				}
+			}
+		}
+
+		if record.Content == "" || record.Date == 0 {
+			continue
+		}
+
+		result = append(result, record)
+	}
+	return
+}
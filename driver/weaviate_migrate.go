@@ -0,0 +1,100 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/andy-zhangtao/Functions/types"
+	"github.com/sirupsen/logrus"
+)
+
+// migrateDiaryBatch is how many diaries MigrateDiaries reads per request
+const migrateDiaryBatch = 100
+
+// MigrateDiaries converts the diaries written by the legacy workflow plugin to the canonical diary model.
+// The legacy diaries have body instead of content, the tags as a comma separated string and the date
+// as a YYYY-MM-DD string or no date, they are skipped by the queries until they are converted.
+// It returns the number of the converted diaries.
+func (wc *WeaviateClient) MigrateDiaries(ctx context.Context) (int, error) {
+	if err := wc.EnsureDiarySchema(ctx); err != nil {
+		return 0, fmt.Errorf("could not ensure diary schema: %v", err)
+	}
+
+	count := 0
+	after := ""
+	for {
+		getter := wc.client.Data().ObjectsGetter().WithClassName(types.DiaryClassName).WithLimit(migrateDiaryBatch)
+		if after != "" {
+			getter = getter.WithAfter(after)
+		}
+
+		objects, err := getter.Do(ctx)
+		if err != nil {
+			return count, fmt.Errorf("could not list diaries after [%s]: %v", after, err)
+		}
+		if len(objects) == 0 {
+			return count, nil
+		}
+
+		for _, object := range objects {
+			after = object.ID.String()
+
+			props, _ := object.Properties.(map[string]interface{})
+			update, ok := legacyDiary(props, object.CreationTimeUnix)
+			if !ok {
+				continue
+			}
+
+			err = wc.client.Data().Updater().WithClassName(types.DiaryClassName).WithID(after).WithProperties(update).WithMerge().Do(ctx)
+			if err != nil {
+				return count, fmt.Errorf("could not migrate diary %s: %v", after, err)
+			}
+
+			logrus.Infof("migrated legacy diary %s", after)
+			count++
+		}
+	}
+}
+
+// legacyDiary returns the properties to merge into the diary if it's a legacy one.
+// created is the creation time of the object in milliseconds, it's the date of the diaries without date.
+func legacyDiary(props map[string]interface{}, created int64) (map[string]interface{}, bool) {
+	update := make(map[string]interface{})
+
+	if content, _ := props[types.DiaryPropContent].(string); content == "" {
+		if body, _ := props["body"].(string); body != "" {
+			update[types.DiaryPropContent] = body
+		}
+	}
+
+	if tags, ok := props[types.DiaryPropTags].(string); ok {
+		update[types.DiaryPropTags] = splitTags(tags)
+	}
+
+	switch date := props[types.DiaryPropDate].(type) {
+	case float64:
+	case string:
+		if t, err := time.Parse("2006-01-02", date); err == nil {
+			update[types.DiaryPropDate] = t.Unix()
+		}
+	default:
+		if created > 0 {
+			day := time.UnixMilli(created).UTC().Truncate(24 * time.Hour)
+			update[types.DiaryPropDate] = day.Unix()
+		}
+	}
+
+	return update, len(update) > 0
+}
+
+func splitTags(tags string) []string {
+	result := []string{}
+	for _, t := range strings.Split(tags, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			result = append(result, t)
+		}
+	}
+	return result
+}
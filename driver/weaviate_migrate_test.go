@@ -0,0 +1,44 @@
+package driver
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/andy-zhangtao/Functions/types"
+)
+
+func TestLegacyDiary(t *testing.T) {
+	created := time.Date(2023, 8, 2, 15, 4, 5, 0, time.UTC).UnixMilli()
+	day := time.Date(2023, 8, 2, 0, 0, 0, 0, time.UTC).Unix()
+
+	tests := []struct {
+		name  string
+		props map[string]interface{}
+		want  map[string]interface{}
+	}{
+		{
+			name:  "legacy plugin",
+			props: map[string]interface{}{"title": "t", "body": "the body", "tags": "a, b,", "user": "u", "date": "2023-08-01"},
+			want:  map[string]interface{}{types.DiaryPropContent: "the body", types.DiaryPropTags: []string{"a", "b"}, types.DiaryPropDate: time.Date(2023, 8, 1, 0, 0, 0, 0, time.UTC).Unix()},
+		},
+		{
+			name:  "no date",
+			props: map[string]interface{}{"body": "the body", "user": "u"},
+			want:  map[string]interface{}{types.DiaryPropContent: "the body", types.DiaryPropDate: day},
+		},
+		{
+			name:  "canonical",
+			props: map[string]interface{}{types.DiaryPropContent: "c", types.DiaryPropTags: []interface{}{"a"}, types.DiaryPropDate: float64(100)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			update, ok := legacyDiary(tt.props, created)
+			if ok != (tt.want != nil) || fmt.Sprint(update) != fmt.Sprint(tt.want) && tt.want != nil {
+				t.Errorf("legacyDiary = %v, %v, want %v", update, ok, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/andy-zhangtao/Functions/types"
+	"github.com/sirupsen/logrus"
+	"github.com/weaviate/weaviate-go-client/v4/weaviate"
+	"github.com/weaviate/weaviate-go-client/v4/weaviate/fault"
+	"github.com/weaviate/weaviate/entities/models"
+)
+
+// ensured records the classes already checked in this process,
+// so the schema is only migrated once per warm instance.
+var ensured sync.Map
+
+// DiaryClass returns the declared schema of the Diary class.
+// This is the single source of truth of the diary properties, the README only mirrors it.
+func DiaryClass() *models.Class {
+	return &models.Class{
+		Class:       types.DiaryClassName,
+		Description: "the work content for user",
+		Vectorizer:  "text2vec-openai",
+		Properties: []*models.Property{
+			{
+				Name:        types.DiaryPropContent,
+				DataType:    []string{"text"},
+				Description: "content that will be vectorized",
+			},
+			{
+				Name:        types.DiaryPropTitle,
+				DataType:    []string{"text"},
+				Description: "the title of the diary",
+			},
+			{
+				Name:        types.DiaryPropUser,
+				DataType:    []string{"text"},
+				Description: "the user name",
+			},
+			{
+				Name:        types.DiaryPropTags,
+				DataType:    []string{"text[]"},
+				Description: "the tags of the diary",
+			},
+			{
+				Name:        types.DiaryPropDate,
+				DataType:    []string{"int"},
+				Description: "the date of the diary, unix timestamp",
+			},
+		},
+		VectorIndexConfig: map[string]interface{}{
+			"ef": 100,
+		},
+	}
+}
+
+// PropertyDrift describes the difference between a declared property and the live schema
+type PropertyDrift struct {
+	Name   string   `json:"name"`
+	Expect []string `json:"expect"`
+	Actual []string `json:"actual,omitempty"`
+	// Missing is true when the property does not exist in the live schema,
+	// missing properties can be added by an additive migration.
+	Missing bool `json:"missing"`
+}
+
+func (pd PropertyDrift) String() string {
+	if pd.Missing {
+		return fmt.Sprintf("property %s %v is missing", pd.Name, pd.Expect)
+	}
+	return fmt.Sprintf("property %s expect %v but got %v", pd.Name, pd.Expect, pd.Actual)
+}
+
+// SchemaManager keeps the live weaviate schema in sync with the declared classes
+type SchemaManager struct {
+	client *weaviate.Client
+}
+
+func NewSchemaManager(client *weaviate.Client) *SchemaManager {
+	return &SchemaManager{client: client}
+}
+
+// Drift compares the declared class with the live schema.
+// exist is false when the class has not been created yet.
+func (sm *SchemaManager) Drift(ctx context.Context, class *models.Class) (drifts []PropertyDrift, exist bool, err error) {
+	live, err := sm.client.Schema().ClassGetter().WithClassName(class.Class).Do(ctx)
+	if err != nil {
+		if e, ok := err.(*fault.WeaviateClientError); ok && e.StatusCode == 404 {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("could not get class %s: %v", class.Class, err)
+	}
+
+	return propertyDrifts(class, live), true, nil
+}
+
+// propertyDrifts returns the declared properties missing in the live class or with another data type
+func propertyDrifts(class, live *models.Class) []PropertyDrift {
+	liveProps := make(map[string]*models.Property)
+	for _, p := range live.Properties {
+		liveProps[strings.ToLower(p.Name)] = p
+	}
+
+	var drifts []PropertyDrift
+	for _, p := range class.Properties {
+		lp, ok := liveProps[strings.ToLower(p.Name)]
+		if !ok {
+			drifts = append(drifts, PropertyDrift{Name: p.Name, Expect: p.DataType, Missing: true})
+			continue
+		}
+
+		if !sameDataType(p.DataType, lp.DataType) {
+			drifts = append(drifts, PropertyDrift{Name: p.Name, Expect: p.DataType, Actual: lp.DataType})
+		}
+	}
+	return drifts
+}
+
+// Ensure creates the class if it is missing and adds the missing properties.
+// Only additive migrations are applied, a property whose data type drifted is
+// reported as an error because weaviate can not change it in place.
+func (sm *SchemaManager) Ensure(ctx context.Context, class *models.Class) error {
+	drifts, exist, err := sm.Drift(ctx, class)
+	if err != nil {
+		return err
+	}
+
+	if !exist {
+		logrus.Infof("create weaviate class %s", class.Class)
+		err = sm.client.Schema().ClassCreator().WithClass(class).Do(ctx)
+		if err != nil {
+			return fmt.Errorf("could not create class %s: %v", class.Class, err)
+		}
+		return nil
+	}
+
+	var incompatible []string
+	for _, d := range drifts {
+		if !d.Missing {
+			incompatible = append(incompatible, d.String())
+			continue
+		}
+
+		logrus.Infof("add property %s to weaviate class %s", d.Name, class.Class)
+		err = sm.client.Schema().PropertyCreator().WithClassName(class.Class).WithProperty(declaredProperty(class, d.Name)).Do(ctx)
+		if err != nil {
+			return fmt.Errorf("could not add property %s to class %s: %v", d.Name, class.Class, err)
+		}
+	}
+
+	if len(incompatible) > 0 {
+		return fmt.Errorf("class %s has incompatible schema: %s", class.Class, strings.Join(incompatible, "; "))
+	}
+
+	return nil
+}
+
+// EnsureOnce runs Ensure only once per class in the current process
+func (sm *SchemaManager) EnsureOnce(ctx context.Context, class *models.Class) error {
+	if _, ok := ensured.Load(class.Class); ok {
+		return nil
+	}
+
+	if err := sm.Ensure(ctx, class); err != nil {
+		return err
+	}
+
+	ensured.Store(class.Class, true)
+	return nil
+}
+
+func declaredProperty(class *models.Class, name string) *models.Property {
+	for _, p := range class.Properties {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// sameDataType compares two data types, the deprecated string type is treated as text
+func sameDataType(expect, actual []string) bool {
+	if len(expect) != len(actual) {
+		return false
+	}
+
+	normalize := func(dt string) string {
+		switch dt {
+		case "string":
+			return "text"
+		case "string[]":
+			return "text[]"
+		}
+		return dt
+	}
+
+	for i := range expect {
+		if normalize(expect[i]) != normalize(actual[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,52 @@
+package driver
+
+import (
+	"testing"
+
+	"github.com/andy-zhangtao/Functions/types"
+	"github.com/weaviate/weaviate/entities/models"
+)
+
+func TestSameDataType(t *testing.T) {
+	tests := []struct {
+		name           string
+		expect, actual []string
+		same           bool
+	}{
+		{"same", []string{"text"}, []string{"text"}, true},
+		{"deprecated string", []string{"text"}, []string{"string"}, true},
+		{"deprecated string array", []string{"text[]"}, []string{"string[]"}, true},
+		{"different", []string{"int"}, []string{"text"}, false},
+		{"array and scalar", []string{"text[]"}, []string{"text"}, false},
+		{"different length", []string{"text"}, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sameDataType(tt.expect, tt.actual); got != tt.same {
+				t.Errorf("sameDataType(%v, %v) = %v, want %v", tt.expect, tt.actual, got, tt.same)
+			}
+		})
+	}
+}
+
+func TestPropertyDrifts(t *testing.T) {
+	live := &models.Class{Class: types.DiaryClassName, Properties: []*models.Property{
+		{Name: "Content", DataType: []string{"string"}},
+		{Name: types.DiaryPropTitle, DataType: []string{"text"}},
+		{Name: types.DiaryPropUser, DataType: []string{"text"}},
+		{Name: types.DiaryPropTags, DataType: []string{"text"}},
+	}}
+
+	drifts := propertyDrifts(DiaryClass(), live)
+	if len(drifts) != 2 {
+		t.Fatalf("drifts = %v, want tags and date", drifts)
+	}
+
+	if d := drifts[0]; d.Name != types.DiaryPropTags || d.Missing || len(d.Actual) != 1 || d.Actual[0] != "text" {
+		t.Errorf("drift = %+v, want tags of text", d)
+	}
+	if d := drifts[1]; d.Name != types.DiaryPropDate || !d.Missing {
+		t.Errorf("drift = %+v, want missing date", d)
+	}
+}
@@ -0,0 +1,130 @@
+package driver
+
+import (
+	"testing"
+
+	"github.com/andy-zhangtao/Functions/types"
+	"github.com/weaviate/weaviate/entities/models"
+)
+
+func TestCheckDiaryQuery(t *testing.T) {
+	distance := float32(0.3)
+	alpha := float32(1.5)
+
+	tests := []struct {
+		name    string
+		query   types.DirayQueryModel
+		wantErr bool
+	}{
+		{"filter only", types.DirayQueryModel{User: "u", Limit: 10, Offset: 10}, false},
+		{"near text", types.DirayQueryModel{User: "u", Keys: []string{"a"}, MaxDistance: &distance}, false},
+		{"both thresholds", types.DirayQueryModel{User: "u", Keys: []string{"a"}, MaxDistance: &distance, Certainty: &distance}, true},
+		{"threshold without keys", types.DirayQueryModel{User: "u", MaxDistance: &distance}, true},
+		{"hybrid with distance", types.DirayQueryModel{User: "u", Keys: []string{"a"}, Mode: types.DiarySearchHybrid, MaxDistance: &distance}, true},
+		{"bm25 with certainty", types.DirayQueryModel{User: "u", Keys: []string{"a"}, Mode: types.DiarySearchBM25, Certainty: &distance}, true},
+		{"explicit near text", types.DirayQueryModel{User: "u", Keys: []string{"a"}, Mode: types.DiarySearchNearText, Certainty: &distance}, false},
+		{"search by date with limit", types.DirayQueryModel{User: "u", Keys: []string{"a"}, Sort: types.DiarySortDate, Limit: 10}, false},
+		{"search by date with offset", types.DirayQueryModel{User: "u", Keys: []string{"a"}, Sort: types.DiarySortDate, Offset: 10}, true},
+		{"search by relevance with offset", types.DirayQueryModel{User: "u", Keys: []string{"a"}, Offset: 10}, false},
+		{"unknown mode", types.DirayQueryModel{User: "u", Keys: []string{"a"}, Mode: "fuzzy"}, true},
+		{"unknown sort", types.DirayQueryModel{User: "u", Sort: "title"}, true},
+		{"unknown order", types.DirayQueryModel{User: "u", Order: "up"}, true},
+		{"relevance without keys", types.DirayQueryModel{User: "u", Sort: types.DiarySortRelevance}, true},
+		{"alpha out of range", types.DirayQueryModel{User: "u", Keys: []string{"a"}, Mode: types.DiarySearchHybrid, Alpha: &alpha}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkDiaryQuery(tt.query); (err != nil) != tt.wantErr {
+				t.Fatalf("checkDiaryQuery error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWhere(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   types.DirayQueryModel
+		want    []string
+		wantErr bool
+	}{
+		{"user", types.DirayQueryModel{User: "u"}, []string{"Equal user"}, false},
+		{"date range", types.DirayQueryModel{User: "u", Start: "2023-08-01", End: "1690934400"},
+			[]string{"Equal user", "GreaterThanEqual date", "LessThanEqual date"}, false},
+		{"bad start", types.DirayQueryModel{User: "u", Start: "yesterday"}, nil, true},
+		{"bad end", types.DirayQueryModel{User: "u", End: "2023/08/01"}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			where, err := (&WeaviateClient{}).where(tt.query)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("where error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			filter := where.Build()
+			if filter.Operator != string(models.WhereFilterOperatorAnd) || len(filter.Operands) != len(tt.want) {
+				t.Fatalf("filter = %s, want %d operands", where.String(), len(tt.want))
+			}
+			for i, op := range filter.Operands {
+				if got := op.Operator + " " + op.Path[0]; got != tt.want[i] {
+					t.Fatalf("operand %d = %s, want %s", i, got, tt.want[i])
+				}
+			}
+			if *filter.Operands[0].ValueText != "u" {
+				t.Fatalf("user = %s, want u", *filter.Operands[0].ValueText)
+			}
+		})
+	}
+}
+
+func TestParser(t *testing.T) {
+	diary := func(date interface{}, additional map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			types.DiaryPropContent: "content",
+			types.DiaryPropTitle:   "title",
+			types.DiaryPropUser:    "u",
+			types.DiaryPropTags:    []interface{}{"a", "b"},
+			types.DiaryPropDate:    date,
+			"_additional":          additional,
+		}
+	}
+
+	tests := []struct {
+		name    string
+		object  models.JSONObject
+		want    int
+		check   func(r types.DiaryRecord) bool
+		wantErr bool
+	}{
+		{"near text", map[string]interface{}{"Diary": []interface{}{diary(float64(100), map[string]interface{}{"id": "1", "distance": 0.1, "certainty": 0.95})}}, 1,
+			func(r types.DiaryRecord) bool {
+				return r.ID == "1" && r.Date == 100 && len(r.Tags) == 2 && *r.Distance == 0.1 && *r.Certainty == 0.95 && r.Score == nil
+			}, false},
+		{"score", map[string]interface{}{"Diary": []interface{}{diary(float64(100), map[string]interface{}{"id": "1", "score": "0.5"})}}, 1,
+			func(r types.DiaryRecord) bool { return *r.Score == 0.5 && r.Distance == nil }, false},
+		{"no date", map[string]interface{}{"Diary": []interface{}{diary(float64(0), nil)}}, 0, nil, false},
+		{"bad date", map[string]interface{}{"Diary": []interface{}{diary("today", nil)}}, 0, nil, true},
+		{"other class", map[string]interface{}{"Other": []interface{}{}}, 0, nil, true},
+		{"not object", []interface{}{}, 0, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := (&WeaviateClient{}).parser(types.DiaryClassName, tt.object)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parser error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(records) != tt.want {
+				t.Fatalf("records = %+v, want %d", records, tt.want)
+			}
+			if tt.check != nil && !tt.check(records[0]) {
+				t.Fatalf("record = %+v", records[0])
+			}
+		})
+	}
+}
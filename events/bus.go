@@ -0,0 +1,93 @@
+// Package events is the internal event bus, the handlers of an event run in background
+// so the publisher, e.g. the diary api, never waits for them.
+package events
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	traceid "github.com/andy-zhangtao/Functions/tools/trace_id"
+	"github.com/andy-zhangtao/Functions/types"
+	"github.com/sirupsen/logrus"
+)
+
+// HandlerTimeout bounds a handler of an event
+const HandlerTimeout = 5 * time.Minute
+
+// Handler handles the published event
+type Handler func(ctx context.Context, event types.Event)
+
+// Bus dispatches the events to the handlers subscribed to their types
+type Bus struct {
+	mu       sync.RWMutex
+	handlers map[string][]Handler
+	running  sync.WaitGroup
+}
+
+func NewBus() *Bus {
+	return &Bus{handlers: make(map[string][]Handler)}
+}
+
+var shared = NewBus()
+
+// Shared returns the process wide bus
+func Shared() *Bus {
+	return shared
+}
+
+// Subscribe adds the handler of the event type
+func (b *Bus) Subscribe(eventType string, handler Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers[eventType] = append(b.handlers[eventType], handler)
+}
+
+// Publish runs the handlers of the event in background, the ID and Time of the event are set if they are empty.
+// The handlers are not cancelled with the context of the publisher, they are bounded by HandlerTimeout.
+func (b *Bus) Publish(event types.Event) {
+	if event.ID == "" {
+		event.ID = traceid.ID()
+	}
+	if event.Time == 0 {
+		event.Time = time.Now().Unix()
+	}
+
+	b.mu.RLock()
+	handlers := b.handlers[event.Type]
+	b.mu.RUnlock()
+
+	logrus.Infof("[EventBus]-[info]-[%s] publish %s of %s to %d handlers", event.ID, event.Type, event.User, len(handlers))
+	for _, handler := range handlers {
+		b.running.Add(1)
+		go func(handler Handler) {
+			defer b.running.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					logrus.Errorf("[EventBus]-[error]-[%s] handler of %s panic: %v", event.ID, event.Type, r)
+				}
+			}()
+
+			ctx, cancel := context.WithTimeout(context.Background(), HandlerTimeout)
+			defer cancel()
+			handler(ctx, event)
+		}(handler)
+	}
+}
+
+// Wait waits until the running handlers finish, including the ones of the events published meanwhile.
+// ctx.Err() is returned if ctx is done first.
+func (b *Bus) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		b.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
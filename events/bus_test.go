@@ -0,0 +1,44 @@
+package events
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/andy-zhangtao/Functions/types"
+)
+
+func TestBus(t *testing.T) {
+	bus := NewBus()
+
+	var mu sync.Mutex
+	var got []types.Event
+	record := func(ctx context.Context, event types.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, event)
+	}
+
+	bus.Subscribe(types.EventDiaryCreated, record)
+	bus.Subscribe(types.EventDiaryCreated, func(ctx context.Context, event types.Event) { panic("broken handler") })
+	bus.Subscribe(types.EventDiaryCreated, func(ctx context.Context, event types.Event) {
+		// the events published by a handler are waited too
+		bus.Publish(types.Event{Type: types.EventWorkflowCompleted, User: event.User})
+	})
+	bus.Subscribe(types.EventWorkflowCompleted, record)
+
+	bus.Publish(types.Event{Type: types.EventDiaryCreated, User: "tester", Payload: map[string]interface{}{"id": "1"}})
+	bus.Publish(types.Event{Type: types.EventDiaryUpdated, User: "tester"})
+	if err := bus.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait error: %v", err)
+	}
+
+	if len(got) != 2 {
+		t.Fatalf("events = %+v, want diary.created and workflow.completed", got)
+	}
+	for _, e := range got {
+		if e.ID == "" || e.Time == 0 || e.User != "tester" {
+			t.Errorf("event = %+v, want id, time and user", e)
+		}
+	}
+}
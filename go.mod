@@ -8,6 +8,7 @@ require (
 	github.com/weaviate/weaviate v1.19.13-0.20230706120536-85b5f0f4fa43
 	github.com/weaviate/weaviate-go-client/v4 v4.9.0
 	go.mongodb.org/mongo-driver v1.12.1
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -43,5 +44,4 @@ require (
 	golang.org/x/text v0.7.0 // indirect
 	google.golang.org/appengine v1.6.7 // indirect
 	google.golang.org/protobuf v1.30.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -1,16 +1,13 @@
 package plugins
 
 import (
-	"bytes"
-	"crypto/tls"
-	"encoding/json"
+	"context"
 	"fmt"
-	"io"
-	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
+	fformat "github.com/andy-zhangtao/Functions/service/f_format"
 	"github.com/andy-zhangtao/Functions/tools/tgpt"
 	"github.com/andy-zhangtao/Functions/tools/tplugins"
 	"github.com/andy-zhangtao/Functions/types"
@@ -23,11 +20,10 @@ type GPT struct {
 	plugin  types.Plugin
 	c       GPTConfig
 
-	nextPlugin types.Plugin
-	wfc        *types.WorkflowContext
-	baseInfo   types.WorkFlowBaseInfo
+	baseInfo types.WorkFlowBaseInfo
 
-	getPluginWithID func(id int) ([]types.Plugin, error)
+	getPluginWithID func(ctx context.Context, id int) ([]types.Plugin, error)
+	format          *fformat.FormatModel
 }
 
 type GPTConfig struct {
@@ -37,23 +33,14 @@ type GPTConfig struct {
 	Model        string  `json:"model"`
 	MaxTokens    int     `json:"max_tokens"`
 	Temperature  float64 `json:"temperature"`
+	// PromptTemplate is the tags of the user's format template,
+	// the template fills {{format}} and {{example}} of the system prompt.
+	PromptTemplate string `json:"prompt_template"`
 }
 
-func NewGPTPlugin(c GPTConfig, fc *types.WorkflowContext) *GPT {
-	traceId := ""
-	_traceId := fc.Get(types.TraceID)
-	if _traceId != nil {
-		traceId = _traceId.(string)
-	}
-
-	g := &GPT{
-		traceId: traceId,
-		c:       c,
-		wfc:     fc,
-	}
-
-	g.log("GPT plugin initialized with [%+v]", c)
-	return g
+// NewGPTPlugin creates a GPT plugin for one step execution, c is copied so the instances never share the config
+func NewGPTPlugin(c GPTConfig) *GPT {
+	return &GPT{c: c}
 }
 
 func (p *GPT) log(format string, args ...interface{}) {
@@ -68,8 +55,8 @@ func (p *GPT) error(format string, args ...interface{}) {
 	logrus.Infof(format, args...)
 }
 
-func (p *GPT) Initialize(plugin types.Plugin) error {
-
+func (p *GPT) Initialize(wfc *types.WorkflowContext, plugin types.Plugin) error {
+	p.traceId = wfc.TraceID()
 	p.log("GPT plugin initialized with [%+v]", plugin)
 	p.plugin = plugin
 
@@ -82,26 +69,34 @@ func (p *GPT) Initialize(plugin types.Plugin) error {
 	p.c.Model = input.Model
 	p.c.MaxTokens = input.MaxTokens
 	p.c.Temperature = input.Temperature
+	p.c.PromptTemplate = input.PromptTemplate
 
-	getPluginWithID := p.wfc.Get(types.GetPluginWithID)
-	if getPluginWithID == nil {
-		return errors.New("get plugin with id error")
+	getPluginWithID, err := types.ContextFunc[func(ctx context.Context, id int) ([]types.Plugin, error)](wfc, types.GetPluginWithID)
+	if err != nil {
+		return errors.WithMessage(err, "get plugin with id error")
 	}
 
-	p.getPluginWithID = getPluginWithID.(func(id int) ([]types.Plugin, error))
+	p.getPluginWithID = getPluginWithID
 
 	p.log("GPT plugin initialized with [%+v]", p.c)
 
 	return nil
 }
 
-func (p *GPT) Execute(ctx *types.WorkflowContext, question string) error {
+func (p *GPT) Execute(ctx context.Context, wfc *types.WorkflowContext, question string) error {
 	p.log("GPT plugin execute with question: %s", question)
 
-	base := ctx.Get(types.CtxOriginQuery).(types.WorkFlowBaseInfo)
+	base, err := wfc.BaseInfo()
+	if err != nil {
+		return errors.WithMessage(err, "get origin query error")
+	}
 	p.baseInfo = base
 
-	response, err := p.do(question)
+	if err := p.loadPromptTemplate(ctx, wfc); err != nil {
+		return errors.WithMessage(err, "load prompt template error")
+	}
+
+	response, err := p.do(ctx, question)
 	if err != nil {
 		p.error("do gpt error: %v", err)
 		return errors.WithMessage(err, "do gpt error")
@@ -109,6 +104,11 @@ func (p *GPT) Execute(ctx *types.WorkflowContext, question string) error {
 
 	p.log("GPT plugin execute with response: %+v", response)
 
+	if wfc.DryRun() {
+		// the dry run still asks gpt, so the next steps get the real output, report the call as the intent
+		wfc.Set(types.ScopePlugin, tplugins.PluginIntentInChain(p.plugin.Name), types.Intent{Action: "chat", Target: p.c.Model, Data: question})
+	}
+
 	choice := response.Choices[0]
 	if strings.Contains(choice.Message.Content, "openai response error:") {
 		// 如果返回的消息中包含openai response error:，则说明预测出错了
@@ -127,7 +127,7 @@ func (p *GPT) Execute(ctx *types.WorkflowContext, question string) error {
 	result := make(map[string]interface{})
 	// If parse success ,then fill up the result with down plugin result
 	if choice.Message.FunctionCall != nil {
-		pm, err := tgpt.ParseFCArgumentsToMap(choice.Message.FunctionCall.Arguments)
+		pm, err := tgpt.ParseFCArguments(choice.Message.FunctionCall.Arguments)
 		if err != nil {
 			return errors.WithMessage(err, "parse function call arguments error")
 		}
@@ -135,17 +135,18 @@ func (p *GPT) Execute(ctx *types.WorkflowContext, question string) error {
 		for k, v := range pm {
 			result[k] = v
 		}
+		result[types.PluginGPTOutputFunction] = choice.Message.FunctionCall.Name
 	}
 
-	// Fill up the result with the content
-	p.wfc.Set(tplugins.PluginNameInChain(p.nextPlugin.Name), result)
-	p.log("GPT next plugin with input: %+v", result)
+	// The workflow passes the output to the next step
+	wfc.Set(types.ScopePlugin, tplugins.PluginOutputInChain(p.plugin.Name), result)
+	p.log("GPT plugin output: %+v", result)
 	return nil
 }
 
-func (p *GPT) Finalize() (*types.WorkflowContext, error) {
+func (p *GPT) Finalize(wfc *types.WorkflowContext) error {
 	p.log("GPT plugin finalize")
-	return p.wfc, nil
+	return nil
 }
 
 func (p *GPT) messages(question string) []types.OpenAIMessage {
@@ -162,7 +163,42 @@ func (p *GPT) messages(question string) []types.OpenAIMessage {
 }
 
 func (p *GPT) systemPrompt() string {
-	return fmt.Sprintf(p.c.SystemPrompt, p.BeijingTime(), p.baseInfo.User)
+	prompt := fmt.Sprintf(p.c.SystemPrompt, p.BeijingTime(), p.baseInfo.User)
+	if p.format == nil {
+		return prompt
+	}
+
+	// 如果system prompt中没有模板占位符，那么就把模板追加到system prompt的末尾
+	if !strings.Contains(prompt, "{{format}}") && !strings.Contains(prompt, "{{example}}") {
+		prompt += "\n{{format}}\n{{example}}"
+	}
+
+	return strings.NewReplacer("{{format}}", p.format.Format, "{{example}}", p.format.Example).Replace(prompt)
+}
+
+// loadPromptTemplate loads the user's format template when the plugin configured prompt_template
+func (p *GPT) loadPromptTemplate(ctx context.Context, wfc *types.WorkflowContext) error {
+	p.format = nil
+	if p.c.PromptTemplate == "" {
+		return nil
+	}
+
+	getFormatWithTags, err := types.ContextFunc[func(ctx context.Context, user, tags string) (*fformat.FormatModel, error)](wfc, types.GetFormatWithTags)
+	if err != nil {
+		return errors.WithMessage(err, "get format with tags error")
+	}
+
+	format, err := getFormatWithTags(ctx, p.baseInfo.User, p.c.PromptTemplate)
+	if err == fformat.ErrNotFound {
+		p.log("prompt template [%s] of %s not found, use the plain system prompt", p.c.PromptTemplate, p.baseInfo.User)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	p.format = format
+	return nil
 }
 
 func (p *GPT) BeijingTime() string {
@@ -179,26 +215,33 @@ func (p *GPT) BeijingTime() string {
 	return now.Format(time.RFC3339)
 }
 
-func (p *GPT) functingCalling() ([]types.OpenAIFunction, error) {
+func (p *GPT) functingCalling(ctx context.Context) ([]types.OpenAIFunction, error) {
 	// 一般来说GPT模块应该是工作流第一个模块，所以这里不需要获取up plugin
 	if len(p.plugin.Reference.Down) == 0 {
 		return nil, nil
 	}
 
-	// 如果存在down plugin，那么就获取down plugin
-	// 目前仅支持一个down plugin
-	downPluginKey := p.plugin.Reference.Down[0]
-	downPlugins, err := p.getPluginWithID(downPluginKey)
-	if err != nil {
-		return nil, errors.WithMessage(err, "getPluginWithID error")
-	}
+	// 如果存在down plugin，那么每个down plugin生成一个function
+	// 有多个function时由gpt选择，workflow根据输出中的_function路由
+	var functions []types.OpenAIFunction
+	for _, downPluginKey := range p.plugin.Reference.Down {
+		downPlugins, err := p.getPluginWithID(ctx, downPluginKey)
+		if err != nil {
+			return nil, errors.WithMessage(err, "getPluginWithID error")
+		}
+
+		if len(downPlugins) == 0 {
+			return nil, errors.Errorf("not find plugin with %d", downPluginKey)
+		}
 
-	if len(downPlugins) == 0 {
-		return nil, errors.Errorf("not find plugin with %d", downPluginKey)
+		fc, err := p.generateOpenAIFunctionViaPlugin(downPlugins[0])
+		if err != nil {
+			return nil, err
+		}
+		functions = append(functions, fc...)
 	}
 
-	p.nextPlugin = downPlugins[0]
-	return p.generateOpenAIFunctionViaPlugin(downPlugins[0])
+	return functions, nil
 }
 
 // generateOpenAIFunctionViaPlugin 通过plugin生成OpenAIFunction
@@ -241,7 +284,7 @@ func (p *GPT) generateOpenAIFunctionViaPlugin(plugin types.Plugin) (result []typ
 	return []types.OpenAIFunction{of}, nil
 }
 
-func (p *GPT) do(question string) (res types.OpenAIResponse, err error) {
+func (p *GPT) do(ctx context.Context, question string) (res types.OpenAIResponse, err error) {
 	reqModel := types.OpenAIWithFunctionRequest{
 		Model:       p.c.Model,
 		MaxTokens:   p.c.MaxTokens,
@@ -250,7 +293,7 @@ func (p *GPT) do(question string) (res types.OpenAIResponse, err error) {
 		// FunctionCall: &gi.functionName,
 	}
 
-	fc, err := p.functingCalling()
+	fc, err := p.functingCalling(ctx)
 	if err != nil {
 		return res, errors.WithMessage(err, "generate function calling error")
 	}
@@ -270,51 +313,17 @@ func (p *GPT) do(question string) (res types.OpenAIResponse, err error) {
 		p.log("more than one function, invoke function call: %+v", reqModel.FunctionCallName)
 	}
 
-	requestBody, err := json.Marshal(reqModel)
-	if err != nil {
-		return res, errors.WithMessagef(err, "marshal request body error [%+v]", reqModel)
-	}
-
-	p.log("invoke gpt request: %s", string(requestBody))
-
-	req, err := http.NewRequest(http.MethodPost, p.c.Url, bytes.NewBuffer(requestBody))
-	if err != nil {
-		return res, errors.WithMessagef(err, "new request error [%s]", p.c.Url)
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", p.c.SKey))
-
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-	}
-	// Send the HTTP request
-	client := &http.Client{
-		Transport: tr,
-	}
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return res, errors.WithMessagef(err, "do request error [%s]", p.c.Url)
-	}
-
-	defer resp.Body.Close()
-
-	data, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return res, errors.WithMessage(err, "read response body error")
-	}
-
-	p.log("invoke gpt response: %s", string(data))
+	p.log("invoke gpt request: %+v", reqModel)
 
-	accResponse := types.OpenAIResponse{}
+	// the request is sent in the dry run too, it sends the question to openai and costs the tokens
 
-	err = json.Unmarshal(data, &accResponse)
+	res, err = tgpt.Chat(ctx, p.c.Url, p.c.SKey, reqModel)
 	if err != nil {
-		return res, errors.WithMessagef(err, "unmarshal response body error [%s]", string(data))
+		return res, err
 	}
 
-	return accResponse, nil
+	p.log("invoke gpt response: %+v", res)
+	return res, nil
 }
 
 // parseGPTPlugin 解析输入
@@ -343,6 +352,8 @@ func (p *GPT) parseGPTPlugin(plugin types.Plugin) (input types.PluginGPTInput, e
 			}
 			temperature, _ := strconv.ParseFloat(_temperature, 64)
 			input.Temperature = temperature
+		case "prompt_template":
+			input.PromptTemplate = v.Value.Description
 		case "model":
 			_model := v.Value.Description
 			if _model == "" {
@@ -1,15 +1,24 @@
 package plugins
 
-import "github.com/andy-zhangtao/Functions/types"
+import (
+	"context"
 
-// Plugin is the interface that all workflow plugins must implement
+	"github.com/andy-zhangtao/Functions/types"
+)
+
+// Plugin is the interface that all workflow plugins must implement.
+// A plugin instance runs one step only, the workflow creates a new instance with the Factory for every step execution,
+// so the state of a run is never shared. The workflow context is passed to every call and must not be kept by the plugin.
 type Plugin interface {
-	// Initialize is called once when the plugin is loaded
-	Initialize(types.Plugin) error
+	// Initialize is called once before Execute with the plugin definition
+	Initialize(wfc *types.WorkflowContext, plugin types.Plugin) error
 
-	// Execute performs the plugin's main action
-	Execute(ctx *types.WorkflowContext, question string) error
+	// Execute performs the plugin's main action, the work must stop when ctx is done
+	Execute(ctx context.Context, wfc *types.WorkflowContext, question string) error
 
-	// Finalize is called once after all workflow steps are completed
-	Finalize() (*types.WorkflowContext, error)
+	// Finalize is called once after Execute succeeded
+	Finalize(wfc *types.WorkflowContext) error
 }
+
+// Factory creates a new plugin instance
+type Factory func() Plugin
@@ -2,13 +2,16 @@ package plugins
 
 import (
 	"context"
-	"reflect"
+	"fmt"
+	"strings"
+	"time"
 
+	"github.com/andy-zhangtao/Functions/driver"
 	"github.com/andy-zhangtao/Functions/tools/tplugins"
 	"github.com/andy-zhangtao/Functions/types"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
-	"github.com/weaviate/weaviate-go-client/v4/weaviate"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type Weaviate struct {
@@ -19,38 +22,35 @@ type Weaviate struct {
 	plugin types.Plugin
 	err    error
 
-	wfc    *types.WorkflowContext
 	action *WeaviateAction
-	client *weaviate.Client
-
-	getPluginWithID func(id int) ([]types.Plugin, error)
+	repo   driver.DiaryRepository
 }
 
 type WeaviateConfig struct {
-	C weaviate.Config
+	C driver.WeaviateClientConf
+	// Repo stores the diaries, a weaviate client of C is created if it is nil
+	Repo driver.DiaryRepository
 }
 
-func NewWeaviatePlugin(c WeaviateConfig, fc *types.WorkflowContext) *Weaviate {
-	traceId := ""
-	_traceId := fc.Get(types.TraceID)
-	if _traceId != nil {
-		traceId = _traceId.(string)
+// NewWeaviatePlugin creates a weaviate plugin for one step execution
+func NewWeaviatePlugin(c WeaviateConfig) *Weaviate {
+	w := &Weaviate{
+		c: c,
 	}
 
-	w := &Weaviate{
-		traceId: traceId,
-		c:       c,
-		wfc:     fc,
+	if c.Repo != nil {
+		w.repo = c.Repo
+		return w
 	}
 
-	client, err := weaviate.NewClient(c.C)
+	client, err := driver.NewWeaviateClient(c.C)
 	if err != nil {
 		w.error("could not create weaviate client: %v", err)
 		w.err = err
 		return w
 	}
 
-	w.client = client
+	w.repo = client
 	return w
 }
 
@@ -66,7 +66,8 @@ func (p *Weaviate) error(format string, args ...interface{}) {
 	logrus.Infof(format, args...)
 }
 
-func (p *Weaviate) Initialize(plugin types.Plugin) error {
+func (p *Weaviate) Initialize(wfc *types.WorkflowContext, plugin types.Plugin) error {
+	p.traceId = wfc.TraceID()
 	if p.err != nil {
 		return p.err
 	}
@@ -74,7 +75,7 @@ func (p *Weaviate) Initialize(plugin types.Plugin) error {
 	p.log("Weaviate plugin initialized with [%+v]", plugin)
 	p.plugin = plugin
 	// get input from workflow context
-	action, err := p.parseWeaviatePlugin(plugin)
+	action, err := p.parseWeaviatePlugin(wfc, plugin)
 	if err != nil {
 		return errors.WithMessage(err, "parse weaviate plugin action error")
 	}
@@ -84,60 +85,58 @@ func (p *Weaviate) Initialize(plugin types.Plugin) error {
 	return nil
 }
 
-func (p *Weaviate) Execute(ctx *types.WorkflowContext, question string) error {
+func (p *Weaviate) Execute(ctx context.Context, wfc *types.WorkflowContext, question string) error {
 	if p.err != nil {
 		return p.err
 	}
 
-	data := make(map[string]interface{})
-
 	switch p.action.action {
 	case types.PluginTypeWeaviateCreateAction:
-		data["title"] = p.action.data.(WeaviateModelDiary).Title
-		data["body"] = p.action.data.(WeaviateModelDiary).Body
-		data["tags"] = p.action.data.(WeaviateModelDiary).Tags
-		data["user"] = p.action.data.(WeaviateModelDiary).User
-		data["date"] = p.action.data.(WeaviateModelDiary).Date
+		if wfc.DryRun() {
+			// report the diary instead of saving it, the id is empty
+			p.log("Dry run, skip creating record")
+			wfc.Set(types.ScopePlugin, tplugins.PluginIntentInChain(p.plugin.Name), types.Intent{Action: "create", Target: p.action.class, Data: p.action.data})
+			wfc.Set(types.ScopePlugin, tplugins.PluginOutputInChain(p.plugin.Name), map[string]interface{}{"id": "", "dry_run": true})
+			return nil
+		}
+
+		id, err := p.repo.SaveDiary(ctx, p.action.data.(types.Diary), nil)
+		if err != nil {
+			return errors.WithMessage(err, "could not create record")
+		}
+
+		p.log("Created record with id [%s]", id)
+		wfc.Set(types.ScopePlugin, tplugins.PluginOutputInChain(p.plugin.Name), map[string]interface{}{"id": id})
+		return nil
 	default:
 		return errors.Errorf("action [%s] not support", p.action.action)
 	}
-
-	created, err := p.client.Data().Creator().WithClassName(types.DiaryClassName).WithProperties(data).Do(context.Background())
-	if err != nil {
-		return errors.WithMessage(err, "could not create record")
-	}
-
-	p.log("Created record with id [%+v]", created)
-	return nil
 }
 
-func (p *Weaviate) Finalize() (*types.WorkflowContext, error) {
+func (p *Weaviate) Finalize(wfc *types.WorkflowContext) error {
 	if p.err != nil {
-		return nil, p.err
+		return p.err
 	}
 
 	p.log("Weaviate plugin finalized")
-	return nil, nil
+	return nil
 }
 
-func (p *Weaviate) parseWeaviatePlugin(plugin types.Plugin) (*WeaviateAction, error) {
-
-	inputParams := p.wfc.Get(tplugins.PluginNameInChain(plugin.Name))
-	if inputParams == nil {
-		return nil, errors.Errorf("plugin %s not found in workflow context", plugin.Name)
-	}
-
-	input, ok := inputParams.(map[string]interface{})
-	if !ok {
-		return nil, errors.Errorf("plugin %s params not conver to map[string]interface{}, it`s a [%s] type", reflect.TypeOf(inputParams))
+func (p *Weaviate) parseWeaviatePlugin(wfc *types.WorkflowContext, plugin types.Plugin) (*WeaviateAction, error) {
+	input, err := wfc.GetMap(types.ScopePlugin, tplugins.PluginNameInChain(plugin.Name))
+	if err != nil {
+		return nil, errors.WithMessagef(err, "plugin %s params", plugin.Name)
 	}
 
-	err := p.check(input)
+	err = p.check(input)
 	if err != nil {
 		return nil, errors.WithMessage(err, "check input error")
 	}
 
-	action := p.convert(input)
+	action, err := p.convert(input)
+	if err != nil {
+		return nil, errors.WithMessage(err, "convert input error")
+	}
 
 	return &action, nil
 }
@@ -180,25 +179,70 @@ func (p *Weaviate) checkCreateInput(input map[string]interface{}) error {
 	return nil
 }
 
-func (p *Weaviate) convert(input map[string]interface{}) WeaviateAction {
+func (p *Weaviate) convert(input map[string]interface{}) (WeaviateAction, error) {
 	switch input["action"] {
 	case types.PluginTypeWeaviateCreateAction:
 		return p.convertCreateAction(input)
 	default:
-		return WeaviateAction{}
+		return WeaviateAction{}, nil
 	}
 }
 
-func (p *Weaviate) convertCreateAction(input map[string]interface{}) WeaviateAction {
+// convertCreateAction converts the gpt output to the canonical diary model.
+// GPT returns tags as a comma separated string and date in YYYY-MM-DD format.
+func (p *Weaviate) convertCreateAction(input map[string]interface{}) (WeaviateAction, error) {
+	md := WeaviateModelDiary{
+		Title: fmt.Sprintf("%v", input["title"]),
+		Body:  fmt.Sprintf("%v", input["body"]),
+		Tags:  joinTags(input["tags"]),
+		User:  fmt.Sprintf("%v", input["user"]),
+		Date:  fmt.Sprintf("%v", input["date"]),
+	}
+
+	date, err := time.Parse("2006-01-02", md.Date)
+	if err != nil {
+		return WeaviateAction{}, errors.WithMessagef(err, "invalid date [%s]", md.Date)
+	}
+
+	var tags []string
+	for _, t := range strings.Split(md.Tags, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			tags = append(tags, t)
+		}
+	}
+
 	return WeaviateAction{
 		action: types.PluginTypeWeaviateCreateAction,
 		class:  types.DiaryClassName,
-		data: WeaviateModelDiary{
-			Title: input["title"].(string),
-			Body:  input["body"].(string),
-			Tags:  input["tags"].(string),
-			User:  input["user"].(string),
-			Date:  input["date"].(string),
+		data: types.Diary{
+			User:    md.User,
+			Title:   md.Title,
+			Content: md.Body,
+			Tags:    tags,
+			Date:    date.Unix(),
 		},
+	}, nil
+}
+
+// joinTags returns the tags as a comma separated string, the input mapping and mongo may give an array
+func joinTags(tags interface{}) string {
+	var items []interface{}
+	switch v := tags.(type) {
+	case nil:
+		return ""
+	case []interface{}:
+		items = v
+	case primitive.A:
+		items = v
+	case []string:
+		return strings.Join(v, ",")
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+
+	s := make([]string, len(items))
+	for i, item := range items {
+		s[i] = fmt.Sprintf("%v", item)
 	}
+	return strings.Join(s, ",")
 }
@@ -0,0 +1,45 @@
+package plugins
+
+import (
+	"testing"
+
+	"github.com/andy-zhangtao/Functions/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestConvertCreateActionTags(t *testing.T) {
+	tests := []struct {
+		name string
+		tags interface{}
+		want []string
+	}{
+		{"gpt string", "father, design", []string{"father", "design"}},
+		{"array", []interface{}{"father", "design"}, []string{"father", "design"}},
+		{"mongo array", primitive.A{"father", " design "}, []string{"father", "design"}},
+		{"strings", []string{"father"}, []string{"father"}},
+		{"empty", "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			action, err := (&Weaviate{}).convertCreateAction(map[string]interface{}{
+				"action": types.PluginTypeWeaviateCreateAction,
+				"title":  "t", "body": "b", "user": "u", "date": "2023-08-01",
+				"tags": tt.tags,
+			})
+			if err != nil {
+				t.Fatalf("convert error: %v", err)
+			}
+
+			tags := action.data.(types.Diary).Tags
+			if len(tags) != len(tt.want) {
+				t.Fatalf("tags = %q, want %q", tags, tt.want)
+			}
+			for i := range tags {
+				if tags[i] != tt.want[i] {
+					t.Fatalf("tags = %q, want %q", tags, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/andy-zhangtao/Functions/driver"
+	"github.com/andy-zhangtao/Functions/tools/tcron"
+	traceid "github.com/andy-zhangtao/Functions/tools/trace_id"
+	"github.com/andy-zhangtao/Functions/types"
+	"github.com/andy-zhangtao/Functions/workflow"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultInterval is how often the scheduler checks the due schedules
+	DefaultInterval = 30 * time.Second
+	// ExecuteTimeout bounds a scheduled execution
+	ExecuteTimeout = 5 * time.Minute
+	// MaxConcurrentRuns bounds the executions running at the same time,
+	// the due schedules beyond it are claimed by the next ticks
+	MaxConcurrentRuns = 4
+)
+
+// Scheduler executes the due schedules.
+// Every server instance runs a scheduler, only the leader holding the scheduler lock executes the schedules,
+// and every run is claimed in the store before it's executed, so a run is never executed twice.
+type Scheduler struct {
+	repos *driver.Repositories
+	// owner identifies the instance in the lock and the run history
+	owner    string
+	interval time.Duration
+	now      func() time.Time
+
+	// running holds a slot for every running execution, wg waits for them
+	running chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewScheduler creates the scheduler of the instance owner, DefaultInterval is used if interval is 0
+func NewScheduler(repos *driver.Repositories, owner string, interval time.Duration) *Scheduler {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	return &Scheduler{repos: repos, owner: owner, interval: interval, now: time.Now, running: make(chan struct{}, MaxConcurrentRuns)}
+}
+
+func (s *Scheduler) log(format string, args ...interface{}) {
+	format = "[Scheduler]-[info]-[%s] " + format
+	args = append([]interface{}{s.owner}, args...)
+	logrus.Infof(format, args...)
+}
+
+func (s *Scheduler) error(format string, args ...interface{}) {
+	format = "[Scheduler]-[error]-[%s] " + format
+	args = append([]interface{}{s.owner}, args...)
+	logrus.Errorf(format, args...)
+}
+
+// Run ticks every interval until ctx is done, then it waits for the cancelled executions and releases the lock
+func (s *Scheduler) Run(ctx context.Context) {
+	s.log("scheduler started, interval %s", s.interval)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.Tick(ctx); err != nil {
+			s.error("tick error: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			s.Wait()
+			release, cancel := context.WithTimeout(context.Background(), driver.OpTimeout)
+			if err := s.repos.Lock.ReleaseLock(release, types.SchedulerLock, s.owner); err != nil {
+				s.error("release lock error: %v", err)
+			}
+			cancel()
+			s.log("scheduler stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Tick starts the executions of the due schedules if the instance is the leader, it doesn't wait for them.
+// The lock lives 3 intervals and every tick renews it, so the leader keeps it while the executions run
+// and changes only if it misses ticks.
+func (s *Scheduler) Tick(ctx context.Context) error {
+	leader, err := s.repos.Lock.AcquireLock(ctx, types.SchedulerLock, s.owner, 3*s.interval)
+	if err != nil {
+		return err
+	}
+	if !leader {
+		return nil
+	}
+
+	now := s.now()
+	schedules, err := s.repos.Schedule.DueSchedules(ctx, now.Unix())
+	if err != nil {
+		return err
+	}
+
+	for _, schedule := range schedules {
+		next, err := NextRun(schedule, now)
+		if err != nil {
+			s.disable(ctx, schedule, err)
+			continue
+		}
+
+		// the schedule stays due if no slot is free, it's claimed by a later tick
+		select {
+		case s.running <- struct{}{}:
+		default:
+			s.log("%d executions are running, schedule %s waits for the next tick", cap(s.running), schedule.ID)
+			return nil
+		}
+
+		err = s.repos.Schedule.ClaimSchedule(ctx, schedule.ID, schedule.NextRun, next, now.Unix())
+		if err != nil {
+			<-s.running
+			if !errors.Is(err, driver.ErrNotFound) {
+				s.error("claim schedule %s error: %v", schedule.ID, err)
+			}
+			// ErrNotFound: the run is claimed by another instance
+			continue
+		}
+
+		s.wg.Add(1)
+		go func(schedule types.Schedule) {
+			defer s.wg.Done()
+			defer func() { <-s.running }()
+			s.execute(ctx, schedule)
+		}(schedule)
+	}
+
+	return nil
+}
+
+// disable disables the schedule whose next run can't be computed and saves the error on it,
+// otherwise the schedule stays due and fails on every tick
+func (s *Scheduler) disable(ctx context.Context, schedule types.Schedule, cause error) {
+	s.error("schedule %s error: %v, disable it", schedule.ID, cause)
+
+	schedule.Enabled = false
+	schedule.Error = cause.Error()
+	if err := s.repos.Schedule.UpdateSchedule(ctx, &schedule); err != nil {
+		s.error("disable schedule %s error: %v", schedule.ID, err)
+	}
+}
+
+// Wait waits for the running executions
+func (s *Scheduler) Wait() {
+	s.wg.Wait()
+}
+
+// execute executes the workflow of the schedule and saves the run
+func (s *Scheduler) execute(ctx context.Context, schedule types.Schedule) {
+	traceId := traceid.ID()
+	s.log("execute schedule %s workflow %s with %s", schedule.ID, schedule.WorkFlowID, traceId)
+
+	run := &types.ScheduleRun{
+		ScheduleID: schedule.ID,
+		WorkFlowID: schedule.WorkFlowID,
+		User:       schedule.User,
+		Owner:      s.owner,
+		StartedAt:  s.now().Unix(),
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ExecuteTimeout)
+	defer cancel()
+
+	result, err := workflow.NewWorkFlowService(s.repos, traceId).ExecuteWorkFlow(ctx, schedule.WorkFlowID, types.WorkFlowRequest{
+		Action:   types.WorkFlowActionExecute,
+		User:     schedule.User,
+		Question: schedule.Question,
+	})
+	run.Status = types.RunStatusFailed
+	if result != nil {
+		run.Status = result.Status
+	}
+	if err != nil {
+		s.error("schedule %s execute error: %v", schedule.ID, err)
+		run.Error = err.Error()
+	}
+	run.FinishedAt = s.now().Unix()
+
+	// the run is saved even if the execution is cancelled
+	save, cancel := context.WithTimeout(context.Background(), driver.OpTimeout)
+	defer cancel()
+	if err := s.repos.Schedule.SaveScheduleRun(save, run); err != nil {
+		s.error("save run of schedule %s error: %v", schedule.ID, err)
+	}
+}
+
+// NextRun returns the unix time of the next run of the schedule after from
+func NextRun(schedule types.Schedule, from time.Time) (int64, error) {
+	cron, err := tcron.Parse(schedule.Cron)
+	if err != nil {
+		return 0, err
+	}
+
+	loc := time.UTC
+	if schedule.Timezone != "" {
+		if loc, err = time.LoadLocation(schedule.Timezone); err != nil {
+			return 0, err
+		}
+	}
+
+	next := cron.Next(from.In(loc))
+	if next.IsZero() {
+		return 0, fmt.Errorf("cron %s never runs", schedule.Cron)
+	}
+	return next.Unix(), nil
+}
@@ -0,0 +1,222 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/andy-zhangtao/Functions/driver"
+	"github.com/andy-zhangtao/Functions/types"
+)
+
+func seedSchedule(t *testing.T, repos *driver.Repositories) types.Schedule {
+	memory := repos.Workflow.(*driver.MemoryStore)
+	memory.SaveWorkFlow(context.Background(), &types.WorkFlow{ID: "remind", Name: "remind", User: "tester", Action: types.WorkFlowExecute, Steps: []types.WorkFlowStep{
+		{Name: "store", PluginKey: 1, Input: map[string]interface{}{
+			"action": "1", "title": "{{request.question}}", "body": "", "tags": "remind", "user": "{{request.user}}", "date": "2023-08-01",
+		}},
+	}})
+	memory.SavePlugin(types.Plugin{PluginKey: 1, Name: "weaviate"})
+
+	schedules, _, err := NewService(repos).Action(context.Background(), types.ScheduleRequest{Action: types.AddAction, User: "tester", Schedule: &types.Schedule{
+		WorkFlowID: "remind", Question: "写日记", Cron: "0 21 * * *", Timezone: "Asia/Shanghai", Enabled: true,
+	}})
+	if err != nil {
+		t.Fatalf("add schedule error: %v", err)
+	}
+	return schedules[0]
+}
+
+func TestSchedulerTick(t *testing.T) {
+	repos := driver.NewMemoryRepositories()
+	schedule := seedSchedule(t, repos)
+
+	due := time.Unix(schedule.NextRun, 0).Add(10 * time.Second)
+	leader := NewScheduler(repos, "a", time.Minute)
+	follower := NewScheduler(repos, "b", time.Minute)
+	for _, s := range []*Scheduler{leader, follower} {
+		s.now = func() time.Time { return due }
+	}
+
+	before := NewScheduler(repos, "a", time.Minute)
+	before.now = func() time.Time { return due.Add(-time.Minute) }
+	if err := before.Tick(context.Background()); err != nil {
+		t.Fatalf("Tick error: %v", err)
+	}
+
+	for _, s := range []*Scheduler{leader, follower, leader} {
+		if err := s.Tick(context.Background()); err != nil {
+			t.Fatalf("Tick error: %v", err)
+		}
+		s.Wait()
+	}
+
+	res, _ := repos.Diary.QueryDiary(context.Background(), types.DirayQueryModel{User: "tester"})
+	if len(res.Results) != 1 || res.Results[0].Title != "写日记" {
+		t.Errorf("diaries = %+v, want 1 executed run", res.Results)
+	}
+
+	runs, err := repos.Schedule.ListScheduleRuns(context.Background(), schedule.ID, 10)
+	if err != nil || len(runs) != 1 || runs[0].Status != types.RunStatusCompleted || runs[0].Owner != "a" {
+		t.Errorf("runs = %+v, %v, want 1 completed run of a", runs, err)
+	}
+
+	updated, _ := repos.Schedule.GetSchedule(context.Background(), schedule.ID, "tester")
+	if updated.NextRun != schedule.NextRun+24*60*60 || updated.LastRun != due.Unix() {
+		t.Errorf("schedule = %+v, want the next run a day later", updated)
+	}
+
+	// the follower takes over when the lock of the leader expires
+	follower.now = func() time.Time { return time.Unix(updated.NextRun, 0) }
+	if ok, _ := repos.Lock.AcquireLock(context.Background(), types.SchedulerLock, "b", time.Minute); ok {
+		t.Errorf("the follower acquires the lock held by the leader")
+	}
+	repos.Lock.ReleaseLock(context.Background(), types.SchedulerLock, "a")
+	if err := follower.Tick(context.Background()); err != nil {
+		t.Fatalf("Tick error: %v", err)
+	}
+	follower.Wait()
+	if runs, _ := repos.Schedule.ListScheduleRuns(context.Background(), schedule.ID, 10); len(runs) != 2 || runs[0].Owner != "b" {
+		t.Errorf("runs = %+v, want the latest run of b", runs)
+	}
+}
+
+func TestSchedulerTickInvalidCron(t *testing.T) {
+	repos := driver.NewMemoryRepositories()
+	schedule := seedSchedule(t, repos)
+
+	// the schedules saved before the validation may have a cron which never runs
+	schedule.Cron = "0 0 30 2 *"
+	if err := repos.Schedule.UpdateSchedule(context.Background(), &schedule); err != nil {
+		t.Fatalf("UpdateSchedule error: %v", err)
+	}
+
+	s := NewScheduler(repos, "a", time.Minute)
+	s.now = func() time.Time { return time.Unix(schedule.NextRun, 0) }
+	if err := s.Tick(context.Background()); err != nil {
+		t.Fatalf("Tick error: %v", err)
+	}
+	s.Wait()
+
+	disabled, _ := repos.Schedule.GetSchedule(context.Background(), schedule.ID, "tester")
+	if disabled.Enabled || disabled.Error == "" {
+		t.Errorf("schedule = %+v, want disabled with the error", disabled)
+	}
+	if due, _ := repos.Schedule.DueSchedules(context.Background(), schedule.NextRun); len(due) != 0 {
+		t.Errorf("due schedules = %+v, want none", due)
+	}
+	if runs, _ := repos.Schedule.ListScheduleRuns(context.Background(), schedule.ID, 10); len(runs) != 0 {
+		t.Errorf("runs = %+v, want none", runs)
+	}
+}
+
+// slowDiary saves the diaries after the delay, so the executions run longer than the interval
+type slowDiary struct {
+	driver.DiaryRepository
+	delay time.Duration
+}
+
+func (d slowDiary) SaveDiary(ctx context.Context, diary types.Diary, mask map[string]interface{}) (string, error) {
+	select {
+	case <-time.After(d.delay):
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	return d.DiaryRepository.SaveDiary(ctx, diary, mask)
+}
+
+func TestSchedulerTickLongExecution(t *testing.T) {
+	repos := driver.NewMemoryRepositories()
+	first := seedSchedule(t, repos)
+	second, _, err := NewService(repos).Action(context.Background(), types.ScheduleRequest{Action: types.AddAction, User: "tester", Schedule: &types.Schedule{
+		WorkFlowID: "remind", Question: "写周报", Cron: "0 21 * * *", Timezone: "Asia/Shanghai", Enabled: true,
+	}})
+	if err != nil {
+		t.Fatalf("add schedule error: %v", err)
+	}
+
+	const interval = 20 * time.Millisecond
+	repos.Diary = slowDiary{DiaryRepository: repos.Diary, delay: 10 * interval}
+
+	leader := NewScheduler(repos, "a", interval)
+	leader.running = make(chan struct{}, 1)
+	due := time.Unix(first.NextRun, 0).Add(10 * time.Second)
+	leader.now = func() time.Time { return due }
+
+	runs := func() int {
+		n := 0
+		for _, id := range []string{first.ID, second[0].ID} {
+			r, _ := repos.Schedule.ListScheduleRuns(context.Background(), id, 10)
+			n += len(r)
+		}
+		return n
+	}
+
+	// the ticks return while the execution runs, the second schedule waits for a free slot
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		if err := leader.Tick(context.Background()); err != nil {
+			t.Fatalf("Tick error: %v", err)
+		}
+		time.Sleep(interval)
+	}
+	if elapsed := time.Since(start); elapsed >= 10*interval {
+		t.Fatalf("ticks took %s, they wait for the execution", elapsed)
+	}
+	if n := runs(); n != 0 {
+		t.Fatalf("runs = %d before the execution finishes, want 0", n)
+	}
+
+	// the lock outlives 3 intervals because every tick renews it
+	if ok, _ := repos.Lock.AcquireLock(context.Background(), types.SchedulerLock, "b", interval); ok {
+		t.Errorf("the lock of the running leader is taken")
+	}
+
+	leader.Wait()
+	if n := runs(); n != 1 {
+		t.Fatalf("runs = %d, want 1 with one slot", n)
+	}
+
+	if err := leader.Tick(context.Background()); err != nil {
+		t.Fatalf("Tick error: %v", err)
+	}
+	leader.Wait()
+	if n := runs(); n != 2 {
+		t.Errorf("runs = %d, want the waiting schedule executed", n)
+	}
+}
+
+func TestServiceAction(t *testing.T) {
+	repos := driver.NewMemoryRepositories()
+	schedule := seedSchedule(t, repos)
+
+	tests := []struct {
+		name     string
+		req      types.ScheduleRequest
+		notFound bool
+		fail     bool
+	}{
+		{name: "list", req: types.ScheduleRequest{Action: types.ListAction, User: "tester"}},
+		{name: "query", req: types.ScheduleRequest{Action: types.QueryAction, User: "tester", ID: schedule.ID}},
+		{name: "query other user", req: types.ScheduleRequest{Action: types.QueryAction, User: "other", ID: schedule.ID}, notFound: true},
+		{name: "history", req: types.ScheduleRequest{Action: types.HistoryAction, User: "tester", ID: schedule.ID}},
+		{name: "invalid cron", req: types.ScheduleRequest{Action: types.AddAction, User: "tester", Schedule: &types.Schedule{WorkFlowID: "remind", Cron: "0 25 * * *"}}, fail: true},
+		{name: "invalid timezone", req: types.ScheduleRequest{Action: types.AddAction, User: "tester", Schedule: &types.Schedule{WorkFlowID: "remind", Cron: "@daily", Timezone: "Mars/Base"}}, fail: true},
+		{name: "workflow of other user", req: types.ScheduleRequest{Action: types.AddAction, User: "other", Schedule: &types.Schedule{WorkFlowID: "remind", Cron: "@daily"}}, fail: true},
+		{name: "update", req: types.ScheduleRequest{Action: types.UpdateAction, User: "tester", ID: schedule.ID, Schedule: &types.Schedule{WorkFlowID: "remind", Cron: "0 18 * * FRI"}}},
+		{name: "update invalid cron", req: types.ScheduleRequest{Action: types.UpdateAction, User: "tester", ID: schedule.ID, Schedule: &types.Schedule{WorkFlowID: "remind", Cron: "0 18 31 2 *"}}, fail: true},
+		{name: "update unknown", req: types.ScheduleRequest{Action: types.UpdateAction, User: "tester", ID: "404", Schedule: &types.Schedule{WorkFlowID: "remind", Cron: "@daily"}}, notFound: true},
+		{name: "delete", req: types.ScheduleRequest{Action: types.DeleteAction, User: "tester", ID: schedule.ID}},
+		{name: "delete deleted", req: types.ScheduleRequest{Action: types.DeleteAction, User: "tester", ID: schedule.ID}, notFound: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := NewService(repos).Action(context.Background(), tt.req)
+			if errors.Is(err, driver.ErrNotFound) != tt.notFound || (err != nil) != (tt.fail || tt.notFound) {
+				t.Errorf("Action error = %v, want not found %v, fail %v", err, tt.notFound, tt.fail)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/andy-zhangtao/Functions/driver"
+	"github.com/andy-zhangtao/Functions/types"
+)
+
+// Service manages the schedules of the users
+type Service struct {
+	repos *driver.Repositories
+}
+
+func NewService(repos *driver.Repositories) *Service {
+	return &Service{repos: repos}
+}
+
+// Action runs req.Action and returns the affected schedules, or the runs of the schedule for HistoryAction.
+// driver.ErrNotFound is returned if the schedule does not exist.
+func (s *Service) Action(ctx context.Context, req types.ScheduleRequest) ([]types.Schedule, []types.ScheduleRun, error) {
+	if req.User == "" {
+		return nil, nil, errors.New("user is empty")
+	}
+
+	switch req.Action {
+	case types.ListAction:
+		schedules, err := s.repos.Schedule.ListSchedules(ctx, req.User)
+		return schedules, nil, err
+	case types.QueryAction:
+		schedule, err := s.repos.Schedule.GetSchedule(ctx, req.ID, req.User)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []types.Schedule{*schedule}, nil, nil
+	case types.DeleteAction:
+		return nil, nil, s.repos.Schedule.DeleteSchedule(ctx, req.ID, req.User)
+	case types.HistoryAction:
+		if _, err := s.repos.Schedule.GetSchedule(ctx, req.ID, req.User); err != nil {
+			return nil, nil, err
+		}
+
+		limit := req.Limit
+		if limit <= 0 {
+			limit = types.DefaultScheduleHistory
+		}
+		runs, err := s.repos.Schedule.ListScheduleRuns(ctx, req.ID, limit)
+		return nil, runs, err
+	}
+
+	if req.Schedule == nil {
+		return nil, nil, errors.New("schedule is empty")
+	}
+	schedule := *req.Schedule
+	schedule.User = req.User
+
+	switch req.Action {
+	case types.AddAction:
+		if err := s.prepare(ctx, &schedule); err != nil {
+			return nil, nil, err
+		}
+		schedule.ID = ""
+		schedule.CreatedAt = time.Now().Unix()
+		if err := s.repos.Schedule.SaveSchedule(ctx, &schedule); err != nil {
+			return nil, nil, err
+		}
+	case types.UpdateAction:
+		if req.ID != "" {
+			schedule.ID = req.ID
+		}
+		old, err := s.repos.Schedule.GetSchedule(ctx, schedule.ID, schedule.User)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := s.prepare(ctx, &schedule); err != nil {
+			return nil, nil, err
+		}
+		schedule.CreatedAt = old.CreatedAt
+		schedule.LastRun = old.LastRun
+		if err := s.repos.Schedule.UpdateSchedule(ctx, &schedule); err != nil {
+			return nil, nil, err
+		}
+	default:
+		return nil, nil, fmt.Errorf("invalid action %s", req.Action)
+	}
+
+	return []types.Schedule{schedule}, nil, nil
+}
+
+// prepare validates the schedule and computes its next run
+func (s *Service) prepare(ctx context.Context, schedule *types.Schedule) error {
+	if schedule.WorkFlowID == "" {
+		return errors.New("schedule has no workflow id")
+	}
+
+	flow, err := s.repos.Workflow.GetWorkFlowByID(ctx, schedule.WorkFlowID)
+	if err != nil {
+		return fmt.Errorf("get workflow %s error: %w", schedule.WorkFlowID, err)
+	}
+	if flow.User != schedule.User {
+		return fmt.Errorf("workflow %s is not a workflow of %s", schedule.WorkFlowID, schedule.User)
+	}
+	if flow.Action != types.WorkFlowExecute {
+		return fmt.Errorf("workflow %s is not executable", schedule.WorkFlowID)
+	}
+
+	next, err := NextRun(*schedule, time.Now())
+	if err != nil {
+		return fmt.Errorf("schedule %s: %w", schedule.Cron, err)
+	}
+	schedule.NextRun = next
+	schedule.Error = ""
+	return nil
+}
@@ -1,10 +1,39 @@
 package fformat
 
+import (
+	"errors"
+	"strings"
+)
+
+// ErrNotFound is returned when no format matches the query
+var ErrNotFound = errors.New("format not found")
+
+// FormatModel is a report/prompt template of a user.
+// A template is identified by the user and its tags, every update saves a new Version.
 type FormatModel struct {
+	ID      string `json:"id,omitempty"`
 	Action  string `json:"action"`
-	Version string `json:"version"`
+	Version string `json:"version"` // empty means the latest version
 	Format  string `json:"format"`
 	Example string `json:"example"`
 	User    string `json:"user"`
-	Tags    string `json:"tags"`
+	Tags    string `json:"tags"` // comma separated tags
+}
+
+type FormatResponse struct {
+	Version string         `json:"version"`
+	Msg     string         `json:"msg"`
+	Code    int            `json:"code"`
+	Formats []*FormatModel `json:"formats,omitempty"`
+}
+
+// SplitTags splits the comma separated tags, empty tags are dropped
+func SplitTags(tags string) []string {
+	var result []string
+	for _, t := range strings.Split(tags, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			result = append(result, t)
+		}
+	}
+	return result
 }
@@ -0,0 +1,177 @@
+package fplugin
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/andy-zhangtao/Functions/driver"
+	"github.com/andy-zhangtao/Functions/types"
+)
+
+// inputTypes are the supported types of the plugin input, empty means string
+var inputTypes = map[string]bool{
+	"":        true,
+	"string":  true,
+	"int":     true,
+	"integer": true,
+	"number":  true,
+	"float":   true,
+	"bool":    true,
+	"boolean": true,
+	"array":   true,
+	"object":  true,
+}
+
+// PluginClient manages the plugin catalog
+type PluginClient struct {
+	repo driver.PluginRepository
+}
+
+func NewPluginClient(repo driver.PluginRepository) *PluginClient {
+	return &PluginClient{repo: repo}
+}
+
+// Action runs req.Action and returns the affected plugins.
+// driver.ErrNotFound is returned if the plugin does not exist.
+func (pc *PluginClient) Action(ctx context.Context, req types.PluginRequest) ([]types.Plugin, error) {
+	switch req.Action {
+	case types.ListAction:
+		return pc.repo.ListPlugins(ctx, req.Module)
+	case types.QueryAction:
+		plugin, err := pc.Get(ctx, req.PluginKey, req.Version)
+		if err != nil {
+			return nil, err
+		}
+		return []types.Plugin{*plugin}, nil
+	case types.DeleteAction:
+		return nil, pc.repo.DeletePlugin(ctx, req.PluginKey)
+	case types.RollbackAction:
+		plugin, err := pc.Rollback(ctx, req.PluginKey, req.Version)
+		if err != nil {
+			return nil, err
+		}
+		return []types.Plugin{*plugin}, nil
+	}
+
+	if req.Plugin == nil {
+		return nil, fmt.Errorf("plugin is empty")
+	}
+	plugin := *req.Plugin
+
+	switch req.Action {
+	case types.AddAction:
+		if err := pc.Validate(ctx, plugin); err != nil {
+			return nil, err
+		}
+		if err := pc.repo.CreatePlugin(ctx, &plugin); err != nil {
+			return nil, err
+		}
+	case types.UpdateAction, types.VersionAction:
+		if req.PluginKey != 0 {
+			plugin.PluginKey = req.PluginKey
+		}
+		if err := pc.Validate(ctx, plugin); err != nil {
+			return nil, err
+		}
+		// the versions are immutable, the update is saved as a new version too
+		if err := pc.repo.SavePluginVersion(ctx, &plugin); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("not support plugin action: %s", req.Action)
+	}
+
+	return []types.Plugin{plugin}, nil
+}
+
+// Get returns the version of the plugin, the latest version if version is 0
+func (pc *PluginClient) Get(ctx context.Context, key, version int) (*types.Plugin, error) {
+	if version > 0 {
+		return pc.repo.GetPluginVersion(ctx, key, version)
+	}
+
+	plugins, err := pc.repo.GetPluginByPluginKey(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(plugins) == 0 {
+		return nil, driver.ErrNotFound
+	}
+	return &plugins[0], nil
+}
+
+// Rollback saves the version of the plugin as the latest version, so the workflows run it again.
+// The old version is validated first, the plugins it refers to may be deleted.
+func (pc *PluginClient) Rollback(ctx context.Context, key, version int) (*types.Plugin, error) {
+	if version <= 0 {
+		return nil, fmt.Errorf("rollback plugin %d needs a version", key)
+	}
+
+	plugin, err := pc.repo.GetPluginVersion(ctx, key, version)
+	if err != nil {
+		return nil, err
+	}
+	if err := pc.Validate(ctx, *plugin); err != nil {
+		return nil, err
+	}
+
+	if err := pc.repo.SavePluginVersion(ctx, plugin); err != nil {
+		return nil, err
+	}
+	return plugin, nil
+}
+
+// Validate checks the input schema and the reference graph of the plugin.
+// The referenced plugins must exist and following Down must not lead back to the plugin.
+func (pc *PluginClient) Validate(ctx context.Context, plugin types.Plugin) error {
+	if plugin.Name == "" {
+		return fmt.Errorf("plugin name is empty")
+	}
+
+	names := make(map[string]bool)
+	for _, in := range plugin.Input {
+		if in.Name == "" {
+			return fmt.Errorf("plugin %s has an input without name", plugin.Name)
+		}
+		if names[in.Name] {
+			return fmt.Errorf("plugin %s has duplicated input %s", plugin.Name, in.Name)
+		}
+		if !inputTypes[in.Value.Type] {
+			return fmt.Errorf("plugin %s input %s has unsupported type %s", plugin.Name, in.Name, in.Value.Type)
+		}
+		names[in.Name] = true
+	}
+
+	ref := plugin.Reference
+	if ref.Up != 0 && ref.Up != types.PluginReferenceStart {
+		if ref.Up == plugin.PluginKey {
+			return fmt.Errorf("plugin %s refers to itself as up", plugin.Name)
+		}
+		if _, err := pc.Get(ctx, ref.Up, 0); err != nil {
+			return fmt.Errorf("up plugin %d of %s: %v", ref.Up, plugin.Name, err)
+		}
+	}
+
+	visited := make(map[int]bool)
+	next := append([]int(nil), ref.Down...)
+	for len(next) > 0 {
+		key := next[0]
+		next = next[1:]
+
+		if plugin.PluginKey != 0 && key == plugin.PluginKey {
+			return fmt.Errorf("plugin %s has a cycle in its down references", plugin.Name)
+		}
+		if visited[key] {
+			continue
+		}
+		visited[key] = true
+
+		down, err := pc.Get(ctx, key, 0)
+		if err != nil {
+			return fmt.Errorf("down plugin %d of %s: %v", key, plugin.Name, err)
+		}
+		next = append(next, down.Reference.Down...)
+	}
+
+	return nil
+}
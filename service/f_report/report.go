@@ -0,0 +1,282 @@
+package freport
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andy-zhangtao/Functions/driver"
+	fformat "github.com/andy-zhangtao/Functions/service/f_format"
+	"github.com/andy-zhangtao/Functions/tools/tgpt"
+	"github.com/andy-zhangtao/Functions/types"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultChunkSize is the max characters of diaries sent to the model in one request
+	DefaultChunkSize = 6000
+	DefaultModel     = "gpt-3.5-turbo-16k"
+	DefaultMaxTokens = 2000
+
+	// pageSize is the page size used to fetch all diaries of the range
+	pageSize = 100
+)
+
+var (
+	// ErrInvalidRequest is returned when the user or the date range of the request is invalid
+	ErrInvalidRequest = errors.New("invalid report request")
+	// ErrNoDiary is returned when the user has no diary in the range
+	ErrNoDiary = errors.New("no diary found")
+)
+
+// DefaultFormat is used when the user has not saved a report format
+const DefaultFormat = `# 工作报告 ({{start}} ~ {{end}})
+
+## 本期完成
+
+## 进行中
+
+## 问题与风险
+
+## 下期计划`
+
+const systemPrompt = `你是一名工作报告助手，请根据用户提供的工作日记，生成一份 Markdown 格式的工作报告。
+报告时间范围是 %s 到 %s。
+报告必须遵循下面的格式:
+%s
+%s
+只输出报告内容，不要输出任何解释。`
+
+const chunkPrompt = `你是一名工作报告助手，请将用户提供的工作日记提炼成要点列表，保留项目名称、完成事项、问题和计划，不要遗漏日期。只输出要点列表。`
+
+type ReportConfig struct {
+	Url         string
+	SKey        string
+	Model       string
+	MaxTokens   int
+	Temperature float64
+	// ChunkSize is the max characters of diaries sent to the model in one request.
+	// The diaries larger than it are summarized chunk by chunk before generating the report.
+	ChunkSize int
+}
+
+// ReportClient generates reports from the diaries
+type ReportClient struct {
+	diary  driver.DiaryRepository
+	format driver.FormatRepository
+	c      ReportConfig
+}
+
+func NewReportClient(diary driver.DiaryRepository, format driver.FormatRepository, c ReportConfig) *ReportClient {
+	if c.ChunkSize <= 0 {
+		c.ChunkSize = DefaultChunkSize
+	}
+
+	if c.Model == "" {
+		c.Model = DefaultModel
+	}
+
+	if c.MaxTokens <= 0 {
+		c.MaxTokens = DefaultMaxTokens
+	}
+
+	return &ReportClient{
+		diary:  diary,
+		format: format,
+		c:      c,
+	}
+}
+
+// Generate returns the markdown report of the user's diaries in the request range
+func (rc *ReportClient) Generate(ctx context.Context, req types.ReportRequest) (string, error) {
+	if req.User == "" {
+		return "", fmt.Errorf("%w: user is empty", ErrInvalidRequest)
+	}
+
+	start, err := time.Parse("2006-01-02", req.Start)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid start [%s]: %v", ErrInvalidRequest, req.Start, err)
+	}
+
+	end, err := time.Parse("2006-01-02", req.End)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid end [%s]: %v", ErrInvalidRequest, req.End, err)
+	}
+
+	if end.Before(start) {
+		return "", fmt.Errorf("%w: end is before start", ErrInvalidRequest)
+	}
+
+	diaries, err := rc.diaries(ctx, req.User, start, end)
+	if err != nil {
+		return "", errors.WithMessage(err, "fetch diaries error")
+	}
+
+	if len(diaries) == 0 {
+		return "", fmt.Errorf("%w between %s and %s", ErrNoDiary, req.Start, req.End)
+	}
+
+	format, err := rc.template(ctx, req.User, req.Tags)
+	if err != nil {
+		return "", errors.WithMessage(err, "query report format error")
+	}
+
+	logrus.Infof("generate report for %s with %d diaries", req.User, len(diaries))
+	content, err := rc.summarize(ctx, diaries)
+	if err != nil {
+		return "", err
+	}
+
+	// generate the report with the template
+	example := ""
+	if format.Example != "" {
+		example = "参考示例:\n" + format.Example
+	}
+
+	prompt := fmt.Sprintf(systemPrompt, req.Start, req.End, renderFormat(format.Format, req.Start, req.End), example)
+	return rc.chat(ctx, prompt, content)
+}
+
+// summarize joins the diaries into one chunk for the report.
+// When they can not be sent at once, every chunk is summarized (map) and the summaries are
+// chunked and summarized again (reduce) until they fit in one chunk.
+func (rc *ReportClient) summarize(ctx context.Context, diaries []string) (string, error) {
+	chunks := Chunk(diaries, rc.c.ChunkSize)
+	for round := 1; len(chunks) > 1; round++ {
+		logrus.Infof("summarize %d chunks in round %d", len(chunks), round)
+
+		var points []string
+		for i, chunk := range chunks {
+			point, err := rc.chat(ctx, chunkPrompt, chunk)
+			if err != nil {
+				return "", errors.WithMessagef(err, "summarize chunk %d of round %d error", i, round)
+			}
+			points = append(points, point)
+		}
+
+		next := Chunk(points, rc.c.ChunkSize)
+		if len(next) >= len(chunks) {
+			return "", errors.Errorf("the summaries of round %d do not fit in fewer than %d chunks", round, len(chunks))
+		}
+		chunks = next
+	}
+
+	return chunks[0], nil
+}
+
+// diaries fetches all the diaries of the user between start and end day, ordered by date
+func (rc *ReportClient) diaries(ctx context.Context, user string, start, end time.Time) ([]string, error) {
+	query := types.DirayQueryModel{
+		Version: types.RequestVersionV1,
+		User:    user,
+		Start:   strconv.FormatInt(start.Unix(), 10),
+		End:     strconv.FormatInt(end.Add(24*time.Hour-time.Second).Unix(), 10),
+		Sort:    types.DiarySortDate,
+		Order:   types.DiaryOrderAsc,
+		Limit:   pageSize,
+	}
+
+	var diaries []string
+	for {
+		res, err := rc.diary.QueryDiary(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, r := range res.Results {
+			diaries = append(diaries, fmt.Sprintf("%s %s\n%s", time.Unix(r.Date, 0).Format("2006-01-02"), r.Title, r.Content))
+		}
+
+		if len(res.Results) < pageSize {
+			break
+		}
+		query.Offset += pageSize
+	}
+
+	return diaries, nil
+}
+
+// template returns the user's report format, or the default format when it is not saved
+func (rc *ReportClient) template(ctx context.Context, user, tags string) (*fformat.FormatModel, error) {
+	fm := &fformat.FormatModel{
+		Action: types.QueryAction,
+		User:   user,
+		Tags:   tags,
+	}
+
+	if rc.format != nil && tags != "" {
+		if err := rc.format.FormatAction(ctx, fm); err != nil && err != fformat.ErrNotFound {
+			return nil, err
+		}
+	}
+
+	if fm.Format == "" {
+		fm.Format = DefaultFormat
+	}
+
+	return fm, nil
+}
+
+func (rc *ReportClient) chat(ctx context.Context, system, content string) (string, error) {
+	res, err := tgpt.Chat(ctx, rc.c.Url, rc.c.SKey, types.OpenAIWithFunctionRequest{
+		Model:       rc.c.Model,
+		MaxTokens:   rc.c.MaxTokens,
+		Temperature: rc.c.Temperature,
+		Messages: []types.OpenAIMessage{
+			{Role: "system", Content: system},
+			{Role: "user", Content: content},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	choice := res.Choices[0]
+	if choice.FinishReason == types.OpenAILength {
+		return "", errors.Errorf("too length, the limit is %d", rc.c.MaxTokens)
+	}
+
+	return strings.TrimSpace(choice.Message.Content), nil
+}
+
+// Chunk joins the diaries into chunks whose length is not greater than size.
+// A single diary larger than size is split by runes.
+func Chunk(diaries []string, size int) []string {
+	var chunks []string
+	var b strings.Builder
+
+	flush := func() {
+		if b.Len() > 0 {
+			chunks = append(chunks, b.String())
+			b.Reset()
+		}
+	}
+
+	for _, d := range diaries {
+		r := []rune(d)
+		for len(r) > size {
+			flush()
+			chunks = append(chunks, string(r[:size]))
+			r = r[size:]
+		}
+
+		if len([]rune(b.String()))+len(r)+1 > size {
+			flush()
+		}
+
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString(string(r))
+	}
+	flush()
+
+	return chunks
+}
+
+func renderFormat(format, start, end string) string {
+	return strings.NewReplacer("{{start}}", start, "{{end}}", end).Replace(format)
+}
@@ -0,0 +1,98 @@
+package freport
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andy-zhangtao/Functions/driver"
+	"github.com/andy-zhangtao/Functions/tools/tmockgpt"
+	"github.com/andy-zhangtao/Functions/types"
+)
+
+func TestChunk(t *testing.T) {
+	tests := []struct {
+		name    string
+		diaries []string
+		size    int
+		want    []string
+	}{
+		{"joined", []string{"a", "b"}, 10, []string{"a\nb"}},
+		{"split by diary", []string{"aaaa", "bbbb"}, 8, []string{"aaaa", "bbbb"}},
+		{"large diary", []string{"aaaaaaaaaa"}, 4, []string{"aaaa", "aaaa", "aa"}},
+		{"runes", []string{"日记日记日"}, 2, []string{"日记", "日记", "日"}},
+		{"empty", nil, 10, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Chunk(tt.diaries, tt.size)
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") || len(got) != len(tt.want) {
+				t.Fatalf("Chunk = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	repo := driver.NewMemoryStore()
+	for i := 1; i <= 4; i++ {
+		date := time.Date(2023, 8, i, 0, 0, 0, 0, time.UTC)
+		repo.SaveDiary(context.Background(), types.Diary{User: "u", Title: "t", Content: strings.Repeat("d", 60), Date: date.Unix()}, nil)
+	}
+
+	summary := func(n int) *tmockgpt.Rule {
+		return tmockgpt.On(tmockgpt.SystemContains("要点列表"), tmockgpt.Content(strings.Repeat("p", n)))
+	}
+
+	tests := []struct {
+		name      string
+		req       types.ReportRequest
+		chunkSize int
+		summary   int
+		wantCalls int
+		wantErr   bool
+		// errIs is the sentinel error wrapped by the error, the handler maps it to the status code
+		errIs error
+	}{
+		{"one chunk", types.ReportRequest{User: "u", Start: "2023-08-01", End: "2023-08-04"}, 1000, 30, 1, false, nil},
+		{"map", types.ReportRequest{User: "u", Start: "2023-08-01", End: "2023-08-02"}, 80, 30, 3, false, nil},
+		{"recursive reduce", types.ReportRequest{User: "u", Start: "2023-08-01", End: "2023-08-04"}, 80, 30, 7, false, nil},
+		{"summaries do not shrink", types.ReportRequest{User: "u", Start: "2023-08-01", End: "2023-08-04"}, 80, 79, 4, true, nil},
+		{"no diary", types.ReportRequest{User: "u", Start: "2023-09-01", End: "2023-09-30"}, 80, 30, 0, true, ErrNoDiary},
+		{"end before start", types.ReportRequest{User: "u", Start: "2023-08-04", End: "2023-08-01"}, 80, 30, 0, true, ErrInvalidRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := tmockgpt.NewServer(summary(tt.summary), tmockgpt.On(tmockgpt.Any(), tmockgpt.Content("report")))
+			defer srv.Close()
+
+			rc := NewReportClient(repo, nil, ReportConfig{Url: srv.URL, ChunkSize: tt.chunkSize})
+			report, err := rc.Generate(context.Background(), tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Generate error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.errIs != nil && !errors.Is(err, tt.errIs) {
+				t.Fatalf("Generate error = %v, want %v", err, tt.errIs)
+			}
+
+			requests := srv.Requests()
+			if len(requests) != tt.wantCalls {
+				t.Fatalf("calls = %d, want %d", len(requests), tt.wantCalls)
+			}
+			if err != nil {
+				return
+			}
+
+			if report != "report" {
+				t.Fatalf("report = %s, want report", report)
+			}
+			if content := requests[len(requests)-1].LastUserMessage(); len([]rune(content)) > tt.chunkSize {
+				t.Fatalf("report content has %d characters, more than the chunk size %d", len([]rune(content)), tt.chunkSize)
+			}
+		})
+	}
+}
@@ -1,36 +1,39 @@
 package fworkflow
 
 import (
+	"context"
 	"github.com/andy-zhangtao/Functions/driver"
 	traceid "github.com/andy-zhangtao/Functions/tools/trace_id"
 	"github.com/andy-zhangtao/Functions/types"
 	"github.com/andy-zhangtao/Functions/workflow"
-	"github.com/pkg/errors"
 )
 
 type WorkflowClient struct {
 	wf *workflow.WorkFlow
 }
 
-func NewWorkflowClient(wc driver.WeaviateClientConf, mc driver.MongoCliConf) (*WorkflowClient, error) {
-	wf, err := workflow.NewWorkFlow(wc, mc, traceid.ID())
-	if err != nil {
-		return nil, errors.WithMessage(err, "new workflow error")
+func NewWorkflowClient(repo driver.WorkflowRepository, plugins driver.PluginRepository) *WorkflowClient {
+	return &WorkflowClient{
+		wf: workflow.NewWorkFlow(repo, plugins, traceid.ID()),
 	}
+}
 
-	return &WorkflowClient{
-		wf: wf,
-	}, nil
+func (wc *WorkflowClient) NewWorkFlow(ctx context.Context, flow *types.WorkFlow) (err error) {
+	return wc.wf.NewWorkFlow(ctx, flow)
+}
+
+func (wc *WorkflowClient) GetWorkFlow(ctx context.Context, user string, name string) (flow *types.WorkFlow, err error) {
+	return wc.wf.FindWorkFlow(ctx, name, user)
 }
 
-func (wc *WorkflowClient) NewWorkFlow(flow types.WorkFlowModel) (err error) {
-	return wc.wf.NewWorkFlow(flow)
+func (wc *WorkflowClient) GetAllWorkFlow(ctx context.Context, user string) (flows []*types.WorkFlow, err error) {
+	return wc.wf.FindAllWorkFlows(ctx, user)
 }
 
-func (wc *WorkflowClient) GetWorkFlow(user string, name string) (flow *types.WorkFlowModel, err error) {
-	return wc.wf.FindWorkFlow(name, user)
+func (wc *WorkflowClient) UpdateWorkFlow(ctx context.Context, flow *types.WorkFlow) (err error) {
+	return wc.wf.UpdateWorkFlow(ctx, flow)
 }
 
-func (wc *WorkflowClient) GetAllWorkFlow(user string) (flows []*types.WorkFlowModel, err error) {
-	return wc.wf.FindAllWorkFlows(user)
+func (wc *WorkflowClient) DeleteWorkFlow(ctx context.Context, user string, name string) (err error) {
+	return wc.wf.DeleteWorkFlow(ctx, name, user)
 }
@@ -0,0 +1,48 @@
+package tcron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	shanghai, _ := time.LoadLocation("Asia/Shanghai")
+	from := time.Date(2023, 8, 1, 10, 30, 15, 0, time.UTC) // Tuesday
+
+	tests := []struct {
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		{spec: "* * * * *", from: from, want: time.Date(2023, 8, 1, 10, 31, 0, 0, time.UTC)},
+		{spec: "0 18 * * FRI", from: from, want: time.Date(2023, 8, 4, 18, 0, 0, 0, time.UTC)},
+		{spec: "0 21 * * *", from: from.In(shanghai), want: time.Date(2023, 8, 1, 21, 0, 0, 0, shanghai)},
+		{spec: "*/15 9-17 * * 1-5", from: from, want: time.Date(2023, 8, 1, 10, 45, 0, 0, time.UTC)},
+		{spec: "0 0 1 jan,jul *", from: from, want: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{spec: "0 0 * * 7", from: from, want: time.Date(2023, 8, 6, 0, 0, 0, 0, time.UTC)},
+		{spec: "0 0 13 * 5", from: from, want: time.Date(2023, 8, 4, 0, 0, 0, 0, time.UTC)},
+		{spec: "@weekly", from: from, want: time.Date(2023, 8, 6, 0, 0, 0, 0, time.UTC)},
+		{spec: "0 0 29 2 *", from: from, want: time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{spec: "0 0 30 2 *", from: from, want: time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			s, err := Parse(tt.spec)
+			if err != nil {
+				t.Fatalf("Parse error: %v", err)
+			}
+			if got := s.Next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("Next(%s) = %s, want %s", tt.from, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseError(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "5-1 * * * *", "*/0 * * * *", "a * * * *"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q) should fail", spec)
+		}
+	}
+}
@@ -0,0 +1,447 @@
+// Package texpr evaluates the mapping expressions of the workflow steps.
+//
+// An expression is wrapped by {{ }}, it's a dotted path into the scope followed by filters:
+//
+//	{{steps.gpt.output.title}}
+//	{{request.user | default "anonymous"}}
+//	{{now | date "2006-01-02"}}
+//
+// A value which is exactly one expression keeps the type of the result,
+// otherwise the results are formatted into the string.
+//
+// The conditions compare with the filters, e.g. {{steps.gpt.output._function | eq "weaviate"}},
+// and are checked by Truthy.
+package texpr
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Filter transforms the value, found is false if the path does not exist in the scope
+type Filter func(value interface{}, found bool, args []string) (interface{}, error)
+
+// Filters are the filters usable in the expressions
+var Filters = map[string]Filter{
+	"date":     dateFilter,
+	"default":  defaultFilter,
+	"upper":    stringFilter(strings.ToUpper),
+	"lower":    stringFilter(strings.ToLower),
+	"trim":     stringFilter(strings.TrimSpace),
+	"join":     joinFilter,
+	"json":     jsonFilter,
+	"len":      lenFilter,
+	"eq":       compareFilter(func(c int) bool { return c == 0 }),
+	"ne":       compareFilter(func(c int) bool { return c != 0 }),
+	"gt":       compareFilter(func(c int) bool { return c > 0 }),
+	"ge":       compareFilter(func(c int) bool { return c >= 0 }),
+	"lt":       compareFilter(func(c int) bool { return c < 0 }),
+	"le":       compareFilter(func(c int) bool { return c <= 0 }),
+	"in":       inFilter,
+	"contains": containsFilter,
+	"not":      notFilter,
+}
+
+// Eval evaluates every string in value, the maps and slices are evaluated recursively.
+// The bson documents and arrays read from mongo are evaluated as the maps and slices.
+func Eval(value interface{}, scope map[string]interface{}) (interface{}, error) {
+	switch v := normalize(value).(type) {
+	case string:
+		return EvalString(v, scope)
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			r, err := Eval(item, scope)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			result[k] = r
+		}
+		return result, nil
+	case []interface{}:
+		result := make([]interface{}, 0, len(v))
+		for i, item := range v {
+			r, err := Eval(item, scope)
+			if err != nil {
+				return nil, fmt.Errorf("[%d]: %w", i, err)
+			}
+			result = append(result, r)
+		}
+		return result, nil
+	default:
+		return value, nil
+	}
+}
+
+// normalize converts the bson documents and arrays decoded from mongo to the plain maps and slices
+func normalize(value interface{}) interface{} {
+	switch v := value.(type) {
+	case primitive.M:
+		return map[string]interface{}(v)
+	case primitive.D:
+		m := make(map[string]interface{}, len(v))
+		for _, e := range v {
+			m[e.Key] = e.Value
+		}
+		return m
+	case primitive.A:
+		return []interface{}(v)
+	}
+	return value
+}
+
+// EvalString evaluates the expressions in s
+func EvalString(s string, scope map[string]interface{}) (interface{}, error) {
+	trimmed := strings.TrimSpace(s)
+	if strings.HasPrefix(trimmed, "{{") && strings.HasSuffix(trimmed, "}}") && strings.Count(trimmed, "{{") == 1 {
+		return evalExpr(trimmed[2:len(trimmed)-2], scope)
+	}
+
+	var sb strings.Builder
+	for {
+		start := strings.Index(s, "{{")
+		if start < 0 {
+			sb.WriteString(s)
+			return sb.String(), nil
+		}
+
+		end := strings.Index(s[start:], "}}")
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed expression in %q", s)
+		}
+		end += start
+
+		v, err := evalExpr(s[start+2:end], scope)
+		if err != nil {
+			return nil, err
+		}
+
+		sb.WriteString(s[:start])
+		sb.WriteString(toString(v))
+		s = s[end+2:]
+	}
+}
+
+func evalExpr(expr string, scope map[string]interface{}) (interface{}, error) {
+	parts, err := split(expr)
+	if err != nil {
+		return nil, err
+	}
+	if len(parts) == 0 || len(parts[0]) != 1 {
+		return nil, fmt.Errorf("invalid expression {{%s}}", expr)
+	}
+
+	value, found := resolve(parts[0][0], scope)
+	for _, p := range parts[1:] {
+		filter, ok := Filters[p[0]]
+		if !ok {
+			return nil, fmt.Errorf("unknown filter %s in {{%s}}", p[0], expr)
+		}
+		// only default handles the missing values
+		if !found && p[0] != "default" {
+			continue
+		}
+
+		value, err = filter(value, found, p[1:])
+		if err != nil {
+			return nil, fmt.Errorf("filter %s in {{%s}}: %w", p[0], expr, err)
+		}
+		found = true
+	}
+
+	if !found {
+		return nil, fmt.Errorf("%s not found in {{%s}}", parts[0][0], expr)
+	}
+	return value, nil
+}
+
+// split splits the expression into the pipe segments and every segment into the words,
+// the words in double quotes are kept as one word
+func split(expr string) ([][]string, error) {
+	var (
+		parts   [][]string
+		words   []string
+		word    strings.Builder
+		inQuote bool
+		quoted  bool
+	)
+
+	flushWord := func() {
+		if word.Len() > 0 || quoted {
+			words = append(words, word.String())
+		}
+		word.Reset()
+		quoted = false
+	}
+
+	for i := 0; i < len(expr); i++ {
+		c := expr[i]
+		switch {
+		case inQuote && c == '\\' && i+1 < len(expr):
+			i++
+			word.WriteByte(expr[i])
+		case c == '"':
+			inQuote = !inQuote
+			quoted = true
+		case inQuote:
+			word.WriteByte(c)
+		case c == ' ' || c == '\t':
+			flushWord()
+		case c == '|':
+			flushWord()
+			if len(words) == 0 {
+				return nil, fmt.Errorf("empty segment in {{%s}}", expr)
+			}
+			parts = append(parts, words)
+			words = nil
+		default:
+			word.WriteByte(c)
+		}
+	}
+
+	if inQuote {
+		return nil, fmt.Errorf("unclosed quote in {{%s}}", expr)
+	}
+
+	flushWord()
+	if len(words) == 0 {
+		return nil, fmt.Errorf("empty segment in {{%s}}", expr)
+	}
+	return append(parts, words), nil
+}
+
+// resolve returns the value of the dotted path, the indexes of the slices are numbers
+func resolve(path string, scope map[string]interface{}) (interface{}, bool) {
+	var current interface{} = scope
+	for _, key := range strings.Split(path, ".") {
+		switch v := normalize(current).(type) {
+		case map[string]interface{}:
+			next, ok := v[key]
+			if !ok {
+				return nil, false
+			}
+			current = next
+		case map[string]string:
+			next, ok := v[key]
+			if !ok {
+				return nil, false
+			}
+			current = next
+		default:
+			rv := reflect.ValueOf(current)
+			if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+				return nil, false
+			}
+
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= rv.Len() {
+				return nil, false
+			}
+			current = rv.Index(i).Interface()
+		}
+	}
+	return current, true
+}
+
+func toString(v interface{}) string {
+	switch s := v.(type) {
+	case string:
+		return s
+	case nil:
+		return ""
+	case time.Time:
+		return s.Format(time.RFC3339)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// dateFilter formats the time with the layout, unix seconds and RFC3339 strings are accepted
+func dateFilter(value interface{}, found bool, args []string) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("date needs one layout")
+	}
+
+	var t time.Time
+	switch v := value.(type) {
+	case time.Time:
+		t = v
+	case int64:
+		t = time.Unix(v, 0)
+	case int:
+		t = time.Unix(int64(v), 0)
+	case float64:
+		t = time.Unix(int64(v), 0)
+	case string:
+		parsed, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, err
+		}
+		t = parsed
+	default:
+		return nil, fmt.Errorf("%v is not a time", value)
+	}
+
+	return t.Format(args[0]), nil
+}
+
+func defaultFilter(value interface{}, found bool, args []string) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("default needs one value")
+	}
+
+	if !found || value == nil || value == "" {
+		return args[0], nil
+	}
+	return value, nil
+}
+
+func stringFilter(fn func(string) string) Filter {
+	return func(value interface{}, found bool, args []string) (interface{}, error) {
+		return fn(toString(value)), nil
+	}
+}
+
+func joinFilter(value interface{}, found bool, args []string) (interface{}, error) {
+	sep := ","
+	if len(args) > 0 {
+		sep = args[0]
+	}
+
+	rv := reflect.ValueOf(value)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return toString(value), nil
+	}
+
+	items := make([]string, 0, rv.Len())
+	for i := 0; i < rv.Len(); i++ {
+		items = append(items, toString(rv.Index(i).Interface()))
+	}
+	return strings.Join(items, sep), nil
+}
+
+func jsonFilter(value interface{}, found bool, args []string) (interface{}, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Truthy reports whether the value is true as a condition.
+// false, nil, 0, "", "false", "0" and the empty slices and maps are false.
+func Truthy(v interface{}) bool {
+	switch b := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return b
+	case string:
+		return b != "" && b != "false" && b != "0"
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return rv.Len() > 0
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int() != 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return rv.Uint() != 0
+	case reflect.Float32, reflect.Float64:
+		return rv.Float() != 0
+	}
+	return true
+}
+
+func lenFilter(value interface{}, found bool, args []string) (interface{}, error) {
+	if s, ok := value.(string); ok {
+		return len(s), nil
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return rv.Len(), nil
+	}
+	return 0, nil
+}
+
+// compare compares the value with the argument as numbers if both are numbers, otherwise as strings
+func compare(value interface{}, arg string) int {
+	if n, ok := toNumber(value); ok {
+		if m, err := strconv.ParseFloat(arg, 64); err == nil {
+			switch {
+			case n < m:
+				return -1
+			case n > m:
+				return 1
+			}
+			return 0
+		}
+	}
+	return strings.Compare(toString(value), arg)
+}
+
+func toNumber(v interface{}) (float64, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	case reflect.String:
+		n, err := strconv.ParseFloat(rv.String(), 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+func compareFilter(ok func(c int) bool) Filter {
+	return func(value interface{}, found bool, args []string) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("compare needs one value")
+		}
+		return ok(compare(value, args[0])), nil
+	}
+}
+
+// inFilter reports whether the value equals one of the arguments
+func inFilter(value interface{}, found bool, args []string) (interface{}, error) {
+	for _, arg := range args {
+		if compare(value, arg) == 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// containsFilter reports whether the string contains or the array has the argument
+func containsFilter(value interface{}, found bool, args []string) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("contains needs one value")
+	}
+
+	rv := reflect.ValueOf(value)
+	if rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
+		for i := 0; i < rv.Len(); i++ {
+			if compare(rv.Index(i).Interface(), args[0]) == 0 {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return strings.Contains(toString(value), args[0]), nil
+}
+
+func notFilter(value interface{}, found bool, args []string) (interface{}, error) {
+	return !Truthy(value), nil
+}
@@ -0,0 +1,115 @@
+package texpr
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestEval(t *testing.T) {
+	now := time.Date(2023, 8, 1, 10, 0, 0, 0, time.UTC)
+	scope := map[string]interface{}{
+		"now":     now,
+		"request": map[string]interface{}{"user": "tester", "question": ""},
+		"steps": map[string]interface{}{
+			"gpt": map[string]interface{}{
+				"output": map[string]interface{}{"title": "Father", "tags": []interface{}{"dev", "design"}},
+			},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		value   interface{}
+		want    interface{}
+		wantErr bool
+	}{
+		{name: "plain string", value: "hello", want: "hello"},
+		{name: "path", value: "{{steps.gpt.output.title}}", want: "Father"},
+		{name: "keep type", value: "{{ steps.gpt.output.tags }}", want: []interface{}{"dev", "design"}},
+		{name: "index", value: "{{steps.gpt.output.tags.1}}", want: "design"},
+		{name: "interpolate", value: "{{request.user}} wrote {{steps.gpt.output.title}}", want: "tester wrote Father"},
+		{name: "date", value: `{{now | date "2006-01-02"}}`, want: "2023-08-01"},
+		{name: "join and upper", value: `{{steps.gpt.output.tags | join "," | upper}}`, want: "DEV,DESIGN"},
+		{name: "default missing", value: `{{request.name | default "anonymous"}}`, want: "anonymous"},
+		{name: "default empty", value: `{{request.question | default "none"}}`, want: "none"},
+		{name: "map", value: map[string]interface{}{"user": "{{request.user}}", "n": 1}, want: map[string]interface{}{"user": "tester", "n": 1}},
+		{name: "eq", value: `{{steps.gpt.output.title | eq "Father"}}`, want: true},
+		{name: "ne", value: `{{request.user | ne "tester"}}`, want: false},
+		{name: "len gt", value: `{{steps.gpt.output.tags | len | gt 1}}`, want: true},
+		{name: "in", value: `{{request.user | in "admin" "tester"}}`, want: true},
+		{name: "contains", value: `{{steps.gpt.output.tags | contains "dev"}}`, want: true},
+		{name: "not", value: `{{request.question | not}}`, want: true},
+		{name: "missing", value: "{{steps.store.output.id}}", wantErr: true},
+		{name: "missing with filter", value: "{{steps.store.output.id | upper}}", wantErr: true},
+		{name: "unknown filter", value: "{{request.user | reverse}}", wantErr: true},
+		{name: "unclosed", value: "{{request.user", wantErr: true},
+		{name: "unclosed quote", value: `{{now | date "2006}}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Eval(tt.value, scope)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Eval error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Eval = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvalMongo(t *testing.T) {
+	// the workflows and the step outputs decoded from mongo without a type
+	scope := map[string]interface{}{
+		"request": primitive.M{"user": "tester"},
+		"steps": primitive.D{
+			{Key: "gpt", Value: primitive.D{
+				{Key: "output", Value: primitive.M{"title": "Father", "tags": primitive.A{"dev", "design"}}},
+			}},
+		},
+	}
+
+	tests := []struct {
+		name  string
+		value interface{}
+		want  interface{}
+	}{
+		{name: "document path", value: "{{steps.gpt.output.title}}", want: "Father"},
+		{name: "array index", value: "{{steps.gpt.output.tags.1}}", want: "design"},
+		{name: "map path", value: "{{request.user}}", want: "tester"},
+		{name: "input document", value: primitive.D{{Key: "user", Value: "{{request.user}}"}, {Key: "tags", Value: primitive.A{"{{steps.gpt.output.tags.0}}"}}},
+			want: map[string]interface{}{"user": "tester", "tags": []interface{}{"dev"}}},
+		{name: "input map", value: primitive.M{"title": "{{steps.gpt.output.title | upper}}"}, want: map[string]interface{}{"title": "FATHER"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Eval(tt.value, scope)
+			if err != nil {
+				t.Fatalf("Eval error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Eval = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTruthy(t *testing.T) {
+	for v, want := range map[interface{}]bool{
+		nil: false, false: false, true: true, "": false, "false": false, "0": false,
+		"yes": true, 0: false, 2: true, 0.0: false, 1.5: true,
+	} {
+		if got := Truthy(v); got != want {
+			t.Errorf("Truthy(%#v) = %v, want %v", v, got, want)
+		}
+	}
+
+	if Truthy([]interface{}{}) || !Truthy([]interface{}{1}) || Truthy(map[string]interface{}{}) {
+		t.Error("Truthy of the slices and maps should be their length")
+	}
+}
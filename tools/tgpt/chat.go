@@ -0,0 +1,85 @@
+package tgpt
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/andy-zhangtao/Functions/types"
+	"github.com/pkg/errors"
+)
+
+// StatusError is returned when the response status is not successful
+type StatusError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("openai response error: %s (status %d)", e.Message, e.StatusCode)
+}
+
+// Chat sends a chat completions request to url and decodes the response.
+// An error in the openai response body is returned as error too, a *StatusError if the status is not successful.
+// The request is cancelled with ctx.
+func Chat(ctx context.Context, url, skey string, reqModel types.OpenAIWithFunctionRequest) (res types.OpenAIResponse, err error) {
+	requestBody, err := json.Marshal(reqModel)
+	if err != nil {
+		return res, errors.WithMessagef(err, "marshal request body error [%+v]", reqModel)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(requestBody))
+	if err != nil {
+		return res, errors.WithMessagef(err, "new request error [%s]", url)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", skey))
+
+	tr := &http.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}
+	// Send the HTTP request
+	client := &http.Client{
+		Transport: tr,
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return res, errors.WithMessagef(err, "do request error [%s]", url)
+	}
+
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return res, errors.WithMessage(err, "read response body error")
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		message := string(data)
+		if json.Unmarshal(data, &res) == nil && res.Erorr != nil {
+			message = res.Erorr.Message
+		}
+		return res, &StatusError{StatusCode: resp.StatusCode, Message: message}
+	}
+
+	err = json.Unmarshal(data, &res)
+	if err != nil {
+		return res, errors.WithMessagef(err, "unmarshal response body error [%s]", string(data))
+	}
+
+	if res.Erorr != nil {
+		return res, errors.Errorf("openai response error: %s", res.Erorr.Message)
+	}
+
+	if len(res.Choices) == 0 {
+		return res, errors.Errorf("openai response without choices [%s]", string(data))
+	}
+
+	return res, nil
+}
@@ -7,6 +7,21 @@ import (
 )
 
 func ParseFCArgumentsToMap(data string) (map[string]string, error) {
+	params, err := ParseFCArguments(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var result = make(map[string]string)
+	for k, v := range params {
+		result[k] = fmt.Sprintf("%v", v)
+	}
+
+	return result, nil
+}
+
+// ParseFCArguments parses the function call arguments and keeps the json types of the values
+func ParseFCArguments(data string) (map[string]interface{}, error) {
 	data = sanitizeJSON(data)
 
 	// data = strings.ReplaceAll(data, "\n", "")
@@ -18,12 +33,7 @@ func ParseFCArgumentsToMap(data string) (map[string]string, error) {
 		return nil, fmt.Errorf("unmarshal data failed: %s with %s", err.Error(), data)
 	}
 
-	var result = make(map[string]string)
-	for k, v := range params {
-		result[k] = fmt.Sprintf("%v", v)
-	}
-
-	return result, nil
+	return params, nil
 }
 
 func sanitizeJSON(input string) string {
@@ -0,0 +1,145 @@
+package tmockgpt
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sync"
+)
+
+// Exchange is a recorded request and response pair
+type Exchange struct {
+	Request     Request `json:"request"`
+	Status      int     `json:"status"`
+	ContentType string  `json:"content_type,omitempty"`
+	// Response is the json response, Raw is the body of the other responses, e.g. a stream
+	Response json.RawMessage `json:"response,omitempty"`
+	Raw      string          `json:"raw,omitempty"`
+}
+
+// Recorder proxies the requests to a real openai url and records the exchanges
+type Recorder struct {
+	*httptest.Server
+
+	upstream string
+	skey     string
+
+	mu        sync.Mutex
+	exchanges []Exchange
+}
+
+// NewRecorder starts a recording proxy to the upstream chat completions url.
+// The skey is sent to upstream, the clients may send any key to the recorder.
+func NewRecorder(upstream, skey string) *Recorder {
+	r := &Recorder{upstream: upstream, skey: skey}
+	r.Server = httptest.NewServer(http.HandlerFunc(r.serve))
+	return r
+}
+
+// Exchanges returns the exchanges recorded so far
+func (r *Recorder) Exchanges() []Exchange {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Exchange(nil), r.exchanges...)
+}
+
+// Save writes the recorded exchanges to the fixture file
+func (r *Recorder) Save(file string) error {
+	data, err := json.MarshalIndent(r.Exchanges(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal exchanges error: %w", err)
+	}
+
+	return os.WriteFile(file, data, 0644)
+}
+
+func (r *Recorder) serve(w http.ResponseWriter, req *http.Request) {
+	raw, _ := io.ReadAll(req.Body)
+
+	var parsed Request
+	if err := json.Unmarshal(raw, &parsed); err != nil {
+		writeJSON(w, Error(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err)))
+		return
+	}
+
+	up, err := http.NewRequest(http.MethodPost, r.upstream, bytes.NewReader(raw))
+	if err != nil {
+		writeJSON(w, Error(http.StatusBadGateway, err.Error()))
+		return
+	}
+	up.Header.Set("Content-Type", "application/json")
+	up.Header.Set("Authorization", "Bearer "+r.skey)
+
+	resp, err := http.DefaultClient.Do(up)
+	if err != nil {
+		writeJSON(w, Error(http.StatusBadGateway, err.Error()))
+		return
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		writeJSON(w, Error(http.StatusBadGateway, err.Error()))
+		return
+	}
+
+	// the streaming responses are kept as the raw string, the fixture stays valid json
+	e := Exchange{Request: parsed, Status: resp.StatusCode, ContentType: resp.Header.Get("Content-Type")}
+	if json.Valid(body) {
+		e.Response = json.RawMessage(body)
+	} else {
+		e.Raw = string(body)
+	}
+
+	r.mu.Lock()
+	r.exchanges = append(r.exchanges, e)
+	r.mu.Unlock()
+
+	w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+	w.WriteHeader(resp.StatusCode)
+	w.Write(body)
+}
+
+// LoadFixture reads the exchanges saved by Recorder.Save
+func LoadFixture(file string) ([]Exchange, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read fixture error: %w", err)
+	}
+
+	var exchanges []Exchange
+	if err := json.Unmarshal(data, &exchanges); err != nil {
+		return nil, fmt.Errorf("unmarshal fixture %s error: %w", file, err)
+	}
+	return exchanges, nil
+}
+
+// Replay returns the rules answering every recorded request with its recorded response,
+// the body and the content type are replayed unchanged.
+// A request matches when its model, messages and functions equal the recorded ones.
+func Replay(exchanges []Exchange) []*Rule {
+	rules := make([]*Rule, 0, len(exchanges))
+	for _, e := range exchanges {
+		e := e
+
+		res := Response{Status: e.Status, Body: e.Response}
+		if e.Raw != "" {
+			res = Response{Status: e.Status, Raw: []byte(e.Raw)}
+		}
+		if e.ContentType != "" {
+			res.Header = map[string]string{"Content-Type": e.ContentType}
+		}
+
+		rules = append(rules, On(func(req Request) bool {
+			return req.Model == e.Request.Model &&
+				reflect.DeepEqual(req.Messages, e.Request.Messages) &&
+				reflect.DeepEqual(req.Functions, e.Request.Functions)
+		}, res))
+	}
+	return rules
+}
@@ -0,0 +1,294 @@
+// Package tmockgpt is a fake OpenAI chat completions server for the tests.
+//
+// The server replays the scripted responses of the first rule whose matcher
+// accepts the request, so GPT driven workflows can be tested without network:
+//
+//	srv := tmockgpt.NewServer(
+//		tmockgpt.On(tmockgpt.FunctionIs("weaviate"), tmockgpt.FunctionCall("weaviate", `{"action":"1"}`)),
+//		tmockgpt.On(tmockgpt.Any(), tmockgpt.RateLimit(), tmockgpt.Content("hello")),
+//	)
+//	defer srv.Close()
+//	os.Setenv(types.PluginGPTURL, srv.URL)
+package tmockgpt
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/andy-zhangtao/Functions/types"
+)
+
+// Request is a request received by the server
+type Request struct {
+	types.OpenAIWithFunctionRequest
+	// Stream is true when the client asked for a streaming response
+	Stream bool   `json:"stream,omitempty"`
+	Raw    []byte `json:"-"`
+}
+
+// LastUserMessage returns the content of the last user message
+func (r Request) LastUserMessage() string {
+	for i := len(r.Messages) - 1; i >= 0; i-- {
+		if r.Messages[i].Role == "user" {
+			return r.Messages[i].Content
+		}
+	}
+	return ""
+}
+
+// SystemMessage returns the content of the first system message
+func (r Request) SystemMessage() string {
+	for _, m := range r.Messages {
+		if m.Role == "system" {
+			return m.Content
+		}
+	}
+	return ""
+}
+
+// Matcher selects the requests a rule responds to
+type Matcher func(req Request) bool
+
+// Any matches every request
+func Any() Matcher {
+	return func(req Request) bool { return true }
+}
+
+// ModelIs matches the requests of model
+func ModelIs(model string) Matcher {
+	return func(req Request) bool { return req.Model == model }
+}
+
+// UserContains matches the requests whose last user message contains s
+func UserContains(s string) Matcher {
+	return func(req Request) bool { return strings.Contains(req.LastUserMessage(), s) }
+}
+
+// SystemContains matches the requests whose system prompt contains s
+func SystemContains(s string) Matcher {
+	return func(req Request) bool { return strings.Contains(req.SystemMessage(), s) }
+}
+
+// FunctionIs matches the requests offering the function name
+func FunctionIs(name string) Matcher {
+	return func(req Request) bool {
+		for _, f := range req.Functions {
+			if f.Name == name {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// All matches the requests accepted by all the matchers
+func All(matchers ...Matcher) Matcher {
+	return func(req Request) bool {
+		for _, m := range matchers {
+			if !m(req) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Response is a scripted response
+type Response struct {
+	Status int
+	Header map[string]string
+	Body   interface{}
+	// Chunks are sent as server sent events when not empty
+	Chunks []interface{}
+	// Raw is written unchanged instead of Body, with the Content-Type in Header
+	Raw []byte
+}
+
+// Content responds a plain assistant message
+func Content(content string) Response {
+	return Response{Status: http.StatusOK, Body: completion(types.OpenAIMessage{Role: "assistant", Content: content}, types.OpenAIStop)}
+}
+
+// FunctionCall responds a function call of name with the json arguments
+func FunctionCall(name, arguments string) Response {
+	return Response{Status: http.StatusOK, Body: completion(types.OpenAIMessage{
+		Role:         "assistant",
+		FunctionCall: &types.OpenAIFunctionCall{Name: name, Arguments: arguments},
+	}, types.OpenAIStop)}
+}
+
+// Length responds a truncated message whose finish reason is length
+func Length(content string) Response {
+	return Response{Status: http.StatusOK, Body: completion(types.OpenAIMessage{Role: "assistant", Content: content}, types.OpenAILength)}
+}
+
+// Error responds an openai error with status
+func Error(status int, message string) Response {
+	return Response{Status: status, Body: types.OpenAIResponse{
+		Erorr: &types.OpenAIErrorResponse{Message: message, Type: "invalid_request_error"},
+	}}
+}
+
+// RateLimit responds 429 with a Retry-After header
+func RateLimit() Response {
+	return Response{
+		Status: http.StatusTooManyRequests,
+		Header: map[string]string{"Retry-After": "1"},
+		Body: types.OpenAIResponse{
+			Erorr: &types.OpenAIErrorResponse{Message: "Rate limit reached", Type: "rate_limit_exceeded"},
+		},
+	}
+}
+
+// Stream responds the content as streaming chunks, one chunk per part
+func Stream(parts ...string) Response {
+	r := Response{Status: http.StatusOK}
+	for _, p := range parts {
+		r.Chunks = append(r.Chunks, map[string]interface{}{
+			"object":  "chat.completion.chunk",
+			"choices": []map[string]interface{}{{"index": 0, "delta": map[string]string{"content": p}}},
+		})
+	}
+	r.Chunks = append(r.Chunks, map[string]interface{}{
+		"object":  "chat.completion.chunk",
+		"choices": []map[string]interface{}{{"index": 0, "delta": map[string]string{}, "finish_reason": types.OpenAIStop}},
+	})
+	return r
+}
+
+func completion(msg types.OpenAIMessage, finish string) types.OpenAIResponse {
+	return types.OpenAIResponse{
+		ID:      "chatcmpl-mock",
+		Object:  "chat.completion",
+		Model:   "mock",
+		Choices: []types.OpenAIChoice{{Message: msg, FinishReason: finish}},
+	}
+}
+
+// Rule responds the matched requests with Responses in order, the last one is repeated
+type Rule struct {
+	Match     Matcher
+	Responses []Response
+	next      int
+}
+
+// On creates a rule
+func On(match Matcher, responses ...Response) *Rule {
+	return &Rule{Match: match, Responses: responses}
+}
+
+// Server is the fake chat completions server
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	rules    []*Rule
+	requests []Request
+}
+
+// NewServer starts a server with the rules, the requests matching no rule get 500
+func NewServer(rules ...*Rule) *Server {
+	s := &Server{rules: rules}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Add appends rules to the server
+func (s *Server) Add(rules ...*Rule) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rules = append(s.rules, rules...)
+}
+
+// Requests returns the requests received so far
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	raw, _ := io.ReadAll(r.Body)
+
+	req := Request{Raw: raw}
+	if err := json.Unmarshal(raw, &req); err != nil {
+		writeJSON(w, Error(http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err)))
+		return
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	var res *Response
+	for _, rule := range s.rules {
+		if rule.Match(req) && len(rule.Responses) > 0 {
+			res = &rule.Responses[rule.next]
+			if rule.next < len(rule.Responses)-1 {
+				rule.next++
+			}
+			break
+		}
+	}
+	s.mu.Unlock()
+
+	if res == nil {
+		writeJSON(w, Error(http.StatusInternalServerError, "no mock response matches the request"))
+		return
+	}
+
+	if len(res.Chunks) > 0 {
+		writeStream(w, *res)
+		return
+	}
+
+	if res.Raw != nil {
+		writeRaw(w, *res)
+		return
+	}
+
+	writeJSON(w, *res)
+}
+
+func writeJSON(w http.ResponseWriter, res Response) {
+	w.Header().Set("Content-Type", "application/json")
+	for k, v := range res.Header {
+		w.Header().Set(k, v)
+	}
+	w.WriteHeader(res.Status)
+
+	if raw, ok := res.Body.(json.RawMessage); ok {
+		w.Write(raw)
+		return
+	}
+	json.NewEncoder(w).Encode(res.Body)
+}
+
+func writeRaw(w http.ResponseWriter, res Response) {
+	for k, v := range res.Header {
+		w.Header().Set(k, v)
+	}
+	w.WriteHeader(res.Status)
+	w.Write(res.Raw)
+}
+
+func writeStream(w http.ResponseWriter, res Response) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(res.Status)
+
+	var buf bytes.Buffer
+	for _, chunk := range res.Chunks {
+		data, _ := json.Marshal(chunk)
+		fmt.Fprintf(&buf, "data: %s\n\n", data)
+		w.Write(buf.Bytes())
+		buf.Reset()
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
@@ -0,0 +1,175 @@
+package tmockgpt
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andy-zhangtao/Functions/tools/tgpt"
+	"github.com/andy-zhangtao/Functions/types"
+)
+
+func chatRequest(user string, functions ...string) types.OpenAIWithFunctionRequest {
+	req := types.OpenAIWithFunctionRequest{
+		Model: "gpt-3.5-turbo-0613",
+		Messages: []types.OpenAIMessage{
+			{Role: "system", Content: "you are a diary assistant"},
+			{Role: "user", Content: user},
+		},
+	}
+	for _, f := range functions {
+		req.Functions = append(req.Functions, types.OpenAIFunction{Name: f})
+	}
+	return req
+}
+
+func TestServer(t *testing.T) {
+	srv := NewServer(
+		On(FunctionIs("weaviate"), FunctionCall("weaviate", `{"action":"1"}`)),
+		On(UserContains("busy"), RateLimit(), Content("ok")),
+		On(UserContains("broken"), Error(http.StatusBadRequest, "bad request")),
+		On(Any(), Content("hello")),
+	)
+	defer srv.Close()
+
+	tests := []struct {
+		name    string
+		req     types.OpenAIWithFunctionRequest
+		content string
+		call    string
+		wantErr bool
+	}{
+		{name: "function call", req: chatRequest("save it", "weaviate"), call: "weaviate"},
+		{name: "rate limited", req: chatRequest("busy"), wantErr: true},
+		{name: "after rate limit", req: chatRequest("busy"), content: "ok"},
+		{name: "last response repeats", req: chatRequest("busy"), content: "ok"},
+		{name: "error", req: chatRequest("broken"), wantErr: true},
+		{name: "fallback", req: chatRequest("hi"), content: "hello"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := tgpt.Chat(context.Background(), srv.URL, "sk", tt.req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Chat error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			msg := res.Choices[0].Message
+			if msg.Content != tt.content {
+				t.Errorf("content = %q, want %q", msg.Content, tt.content)
+			}
+			if tt.call != "" && (msg.FunctionCall == nil || msg.FunctionCall.Name != tt.call) {
+				t.Errorf("function call = %+v, want %s", msg.FunctionCall, tt.call)
+			}
+		})
+	}
+
+	if got := len(srv.Requests()); got != len(tests) {
+		t.Errorf("requests = %d, want %d", got, len(tests))
+	}
+}
+
+func TestServerStream(t *testing.T) {
+	srv := NewServer(On(Any(), Stream("hel", "lo")))
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL, "application/json", strings.NewReader(`{"model":"m","stream":true}`))
+	if err != nil {
+		t.Fatalf("post error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Errorf("content type = %s", resp.Header.Get("Content-Type"))
+	}
+	if strings.Count(string(body), "data: ") != 4 || !strings.HasSuffix(string(body), "data: [DONE]\n\n") {
+		t.Errorf("stream body = %s", body)
+	}
+	if !srv.Requests()[0].Stream {
+		t.Error("stream request is not recorded")
+	}
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	upstream := NewServer(On(Any(), Content("recorded")))
+	defer upstream.Close()
+
+	rec := NewRecorder(upstream.URL, "sk")
+	if _, err := tgpt.Chat(context.Background(), rec.URL, "", chatRequest("record me")); err != nil {
+		t.Fatalf("Chat through recorder error: %v", err)
+	}
+	rec.Close()
+
+	file := filepath.Join(t.TempDir(), "fixture.json")
+	if err := rec.Save(file); err != nil {
+		t.Fatalf("Save error: %v", err)
+	}
+
+	exchanges, err := LoadFixture(file)
+	if err != nil {
+		t.Fatalf("LoadFixture error: %v", err)
+	}
+
+	replay := NewServer(Replay(exchanges)...)
+	defer replay.Close()
+
+	res, err := tgpt.Chat(context.Background(), replay.URL, "", chatRequest("record me"))
+	if err != nil {
+		t.Fatalf("Chat replay error: %v", err)
+	}
+	if res.Choices[0].Message.Content != "recorded" {
+		t.Errorf("replay content = %q", res.Choices[0].Message.Content)
+	}
+
+	if _, err := tgpt.Chat(context.Background(), replay.URL, "", chatRequest("not recorded")); err == nil {
+		t.Error("unrecorded request should fail")
+	}
+}
+
+func TestRecordAndReplayStream(t *testing.T) {
+	upstream := NewServer(On(Any(), Stream("hel", "lo")))
+	defer upstream.Close()
+
+	rec := NewRecorder(upstream.URL, "sk")
+	resp, err := http.Post(rec.URL, "application/json", strings.NewReader(`{"model":"m","stream":true}`))
+	if err != nil {
+		t.Fatalf("post through recorder error: %v", err)
+	}
+	recorded, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	rec.Close()
+
+	file := filepath.Join(t.TempDir(), "fixture.json")
+	if err := rec.Save(file); err != nil {
+		t.Fatalf("Save error: %v", err)
+	}
+
+	exchanges, err := LoadFixture(file)
+	if err != nil {
+		t.Fatalf("LoadFixture error: %v", err)
+	}
+
+	replay := NewServer(Replay(exchanges)...)
+	defer replay.Close()
+
+	resp, err = http.Post(replay.URL, "application/json", strings.NewReader(`{"model":"m","stream":true}`))
+	if err != nil {
+		t.Fatalf("post replay error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Errorf("content type = %s", resp.Header.Get("Content-Type"))
+	}
+	if string(body) != string(recorded) {
+		t.Errorf("replay body = %q, want %q", body, recorded)
+	}
+}
@@ -7,3 +7,16 @@ package tplugins
 func PluginNameInChain(name string) string {
 	return "plugin_" + name + "_input"
 }
+
+// PluginOutputInChain returns the key of the plugin output in the chain
+// 例如: plugin name = "doc"，那么返回的结果就是"plugin_doc_output"
+// plugin执行后将输出写入这个Key，workflow通过step的output绑定到其他Key
+func PluginOutputInChain(name string) string {
+	return "plugin_" + name + "_output"
+}
+
+// PluginIntentInChain returns the key of the intent the plugin reports in the dry run
+// 例如: plugin name = "doc"，那么返回的结果就是"plugin_doc_intent"
+func PluginIntentInChain(name string) string {
+	return "plugin_" + name + "_intent"
+}
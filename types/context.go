@@ -1,9 +1,230 @@
 package types
 
-// WorkflowContext represents the shared context of a workflow
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// The keys of the WorkflowContext
+const (
+	TraceID           = "x-traceId"
+	GetPluginWithID   = "x-fun-getPluginWithID"
+	GetFormatWithTags = "x-fun-getFormatWithTags"
+	CtxPluginGPT      = "x-ctx-gpt-instance"
+	CtxOriginQuery    = "x-ctx-origin-query"
+	// CtxEvent is the Event which triggered the execution
+	CtxEvent = "x-ctx-event"
+	// CtxDryRun is true in the dry run, the side effecting plugins report their Intent instead of acting
+	CtxDryRun = "x-ctx-dry-run"
+)
+
+// ContextScope is the namespace of the values in the WorkflowContext
+type ContextScope string
+
 const (
-	TraceID         = "x-traceId"
-	GetPluginWithID = "x-fun-getPluginWithID"
-	CtxPluginGPT    = "x-ctx-gpt-instance"
-	CtxOriginQuery  = "x-ctx-origin-query"
+	// ScopeRequest holds the values of the workflow request, e.g. TraceID and CtxOriginQuery
+	ScopeRequest ContextScope = "request"
+	// ScopeStep holds the values the steps bind with output, the expressions read them as {{ctx.key}}
+	ScopeStep ContextScope = "step"
+	// ScopePlugin holds the inputs and outputs of the plugins
+	ScopePlugin ContextScope = "plugin"
 )
+
+// ErrContextNotFound is returned by the typed getters if the key is not set
+var ErrContextNotFound = errors.New("not found in workflow context")
+
+// ContextSnapshot is a copy of the data of a WorkflowContext
+type ContextSnapshot map[ContextScope]map[string]interface{}
+
+// WorkflowContext represents the shared context of a workflow, it's safe for concurrent use.
+// The data is kept in the scopes and can be snapshotted and serialized,
+// the functions (e.g. GetPluginWithID) are kept apart and never serialized.
+type WorkflowContext struct {
+	mu     sync.RWMutex
+	scopes ContextSnapshot
+	funcs  map[string]interface{}
+}
+
+func NewWorkFlowContext() *WorkflowContext {
+	return &WorkflowContext{
+		scopes: make(ContextSnapshot),
+		funcs:  make(map[string]interface{}),
+	}
+}
+
+// Set sets the value of the key in the scope
+func (ctx *WorkflowContext) Set(scope ContextScope, key string, value interface{}) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+
+	if ctx.scopes == nil {
+		ctx.scopes = make(ContextSnapshot)
+	}
+	values, ok := ctx.scopes[scope]
+	if !ok {
+		values = make(map[string]interface{})
+		ctx.scopes[scope] = values
+	}
+	values[key] = value
+}
+
+// Get returns the value of the key in the scope, ok is false if it's not set
+func (ctx *WorkflowContext) Get(scope ContextScope, key string) (value interface{}, ok bool) {
+	ctx.mu.RLock()
+	defer ctx.mu.RUnlock()
+
+	value, ok = ctx.scopes[scope][key]
+	return value, ok
+}
+
+// Delete removes the key from the scope
+func (ctx *WorkflowContext) Delete(scope ContextScope, key string) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+
+	delete(ctx.scopes[scope], key)
+}
+
+// Clear removes all the values of the scope
+func (ctx *WorkflowContext) Clear(scope ContextScope) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+
+	delete(ctx.scopes, scope)
+}
+
+// GetString returns the string value of the key in the scope
+func (ctx *WorkflowContext) GetString(scope ContextScope, key string) (string, error) {
+	return ContextValue[string](ctx, scope, key)
+}
+
+// GetMap returns the map value of the key in the scope
+func (ctx *WorkflowContext) GetMap(scope ContextScope, key string) (map[string]interface{}, error) {
+	return ContextValue[map[string]interface{}](ctx, scope, key)
+}
+
+// TraceID returns the trace id of the request, it's empty if not set
+func (ctx *WorkflowContext) TraceID() string {
+	traceId, _ := ctx.GetString(ScopeRequest, TraceID)
+	return traceId
+}
+
+// DryRun reports whether the execution is a dry run
+func (ctx *WorkflowContext) DryRun() bool {
+	v, _ := ctx.Get(ScopeRequest, CtxDryRun)
+	dryRun, _ := v.(bool)
+	return dryRun
+}
+
+// BaseInfo returns the request info set by the workflow
+func (ctx *WorkflowContext) BaseInfo() (WorkFlowBaseInfo, error) {
+	return ContextValue[WorkFlowBaseInfo](ctx, ScopeRequest, CtxOriginQuery)
+}
+
+// SetFunc sets the function of the key
+func (ctx *WorkflowContext) SetFunc(key string, fn interface{}) {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+
+	if ctx.funcs == nil {
+		ctx.funcs = make(map[string]interface{})
+	}
+	ctx.funcs[key] = fn
+}
+
+// ContextValue returns the value of the key in the scope as T.
+// The values restored from json are decoded into T, e.g. a WorkFlowBaseInfo which was decoded as a map.
+func ContextValue[T any](ctx *WorkflowContext, scope ContextScope, key string) (T, error) {
+	var zero T
+
+	value, ok := ctx.Get(scope, key)
+	if !ok {
+		return zero, fmt.Errorf("%s/%s %w", scope, key, ErrContextNotFound)
+	}
+
+	if v, ok := value.(T); ok {
+		return v, nil
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return zero, fmt.Errorf("%s/%s is %T, not %T", scope, key, value, zero)
+	}
+
+	var v T
+	if err := json.Unmarshal(data, &v); err != nil {
+		return zero, fmt.Errorf("%s/%s is %T, not %T", scope, key, value, zero)
+	}
+	return v, nil
+}
+
+// ContextFunc returns the function of the key as T
+func ContextFunc[T any](ctx *WorkflowContext, key string) (T, error) {
+	var zero T
+
+	ctx.mu.RLock()
+	fn, ok := ctx.funcs[key]
+	ctx.mu.RUnlock()
+
+	if !ok {
+		return zero, fmt.Errorf("function %s %w", key, ErrContextNotFound)
+	}
+
+	v, ok := fn.(T)
+	if !ok {
+		return zero, fmt.Errorf("function %s is %T, not %T", key, fn, zero)
+	}
+	return v, nil
+}
+
+// Snapshot returns a copy of the data, the functions are not included.
+// The scopes are copied, the values themselves are shared.
+func (ctx *WorkflowContext) Snapshot() ContextSnapshot {
+	ctx.mu.RLock()
+	defer ctx.mu.RUnlock()
+
+	snapshot := make(ContextSnapshot, len(ctx.scopes))
+	for scope, values := range ctx.scopes {
+		snapshot[scope] = copyValues(values)
+	}
+	return snapshot
+}
+
+// Restore replaces the data with the snapshot, the functions are kept
+func (ctx *WorkflowContext) Restore(snapshot ContextSnapshot) {
+	scopes := make(ContextSnapshot, len(snapshot))
+	for scope, values := range snapshot {
+		scopes[scope] = copyValues(values)
+	}
+
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+
+	ctx.scopes = scopes
+}
+
+// MarshalJSON serializes the data of the context
+func (ctx *WorkflowContext) MarshalJSON() ([]byte, error) {
+	return json.Marshal(ctx.Snapshot())
+}
+
+// UnmarshalJSON restores the data of the context, the functions must be set with SetFunc again
+func (ctx *WorkflowContext) UnmarshalJSON(data []byte) error {
+	var snapshot ContextSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return err
+	}
+
+	ctx.Restore(snapshot)
+	return nil
+}
+
+func copyValues(values map[string]interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,97 @@
+package types
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestWorkflowContextTypedGetters(t *testing.T) {
+	ctx := NewWorkFlowContext()
+	ctx.Set(ScopeRequest, TraceID, "trace")
+	ctx.Set(ScopeRequest, CtxOriginQuery, WorkFlowBaseInfo{User: "tester"})
+	ctx.Set(ScopeStep, "title", 1)
+	ctx.SetFunc(GetPluginWithID, func(id int) ([]Plugin, error) { return nil, nil })
+
+	if ctx.TraceID() != "trace" {
+		t.Errorf("TraceID = %q, want trace", ctx.TraceID())
+	}
+
+	if base, err := ctx.BaseInfo(); err != nil || base.User != "tester" {
+		t.Errorf("BaseInfo = %+v, %v, want tester", base, err)
+	}
+
+	if _, err := ctx.GetString(ScopeStep, "title"); err == nil {
+		t.Errorf("GetString of an int should fail")
+	}
+
+	if _, err := ctx.GetString(ScopePlugin, "title"); !errors.Is(err, ErrContextNotFound) {
+		t.Errorf("GetString of another scope = %v, want ErrContextNotFound", err)
+	}
+
+	if _, err := ContextFunc[func(id int) ([]Plugin, error)](ctx, GetPluginWithID); err != nil {
+		t.Errorf("ContextFunc error: %v", err)
+	}
+
+	if _, err := ContextFunc[func() error](ctx, GetPluginWithID); err == nil {
+		t.Errorf("ContextFunc of another type should fail")
+	}
+}
+
+func TestWorkflowContextJSON(t *testing.T) {
+	ctx := NewWorkFlowContext()
+	ctx.Set(ScopeRequest, CtxOriginQuery, WorkFlowBaseInfo{User: "tester"})
+	ctx.Set(ScopeStep, "title", "Father")
+	ctx.SetFunc(GetPluginWithID, func(id int) ([]Plugin, error) { return nil, nil })
+
+	snapshot := ctx.Snapshot()
+	ctx.Set(ScopeStep, "title", "Mother")
+	if snapshot[ScopeStep]["title"] != "Father" {
+		t.Errorf("snapshot changed with the context: %v", snapshot[ScopeStep]["title"])
+	}
+
+	data, err := json.Marshal(ctx)
+	if err != nil {
+		t.Fatalf("marshal error: %v", err)
+	}
+
+	restored := NewWorkFlowContext()
+	if err := json.Unmarshal(data, restored); err != nil {
+		t.Fatalf("unmarshal error: %v", err)
+	}
+
+	if base, err := restored.BaseInfo(); err != nil || base.User != "tester" {
+		t.Errorf("restored BaseInfo = %+v, %v, want tester", base, err)
+	}
+
+	if title, _ := restored.GetString(ScopeStep, "title"); title != "Mother" {
+		t.Errorf("restored title = %q, want Mother", title)
+	}
+
+	if _, err := ContextFunc[func(id int) ([]Plugin, error)](restored, GetPluginWithID); err == nil {
+		t.Errorf("the functions should not be serialized")
+	}
+}
+
+func TestWorkflowContextConcurrent(t *testing.T) {
+	ctx := NewWorkFlowContext()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				ctx.Set(ScopeStep, "key", j)
+				ctx.Get(ScopeStep, "key")
+				ctx.Snapshot()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if _, ok := ctx.Get(ScopeStep, "key"); !ok {
+		t.Errorf("key not set")
+	}
+}
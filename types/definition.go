@@ -0,0 +1,33 @@
+package types
+
+// Definition is the file format of the workflows and the plugins they use, so the definitions can live in git.
+// The plugins keep their PluginKey, the workflows are identified by their name and User, or the Definition User if empty.
+type Definition struct {
+	User      string     `json:"user,omitempty"`
+	Plugins   []Plugin   `json:"plugins,omitempty"`
+	Workflows []WorkFlow `json:"workflows,omitempty"`
+}
+
+// The formats of the definition files
+const (
+	DefinitionYAML = "yaml"
+	DefinitionJSON = "json"
+)
+
+// DefinitionChange is the change a definition makes to a plugin or a workflow, Action is one of the ChangeAction constants
+type DefinitionChange struct {
+	// Kind is plugin or workflow
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Key    int    `json:"plugin_key,omitempty"`
+	User   string `json:"user,omitempty"`
+	Action string `json:"action"`
+	// Diff lists the changed fields as "path: old -> new"
+	Diff []string `json:"diff,omitempty"`
+}
+
+const (
+	ChangeActionCreate    = "create"
+	ChangeActionUpdate    = "update"
+	ChangeActionUnchanged = "unchanged"
+)
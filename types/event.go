@@ -0,0 +1,34 @@
+package types
+
+// Event is published to the event bus when something happens, e.g. a diary is created.
+// The workflows of the same user with a trigger of the event Type are executed with the event.
+type Event struct {
+	ID   string `json:"id" bson:"id"`
+	Type string `json:"type" bson:"type"`
+	User string `json:"user" bson:"user"`
+	// Time is the unix time the event is published
+	Time    int64                  `json:"time" bson:"time"`
+	Payload map[string]interface{} `json:"payload" bson:"payload"`
+	// Depth is how many workflow executions caused the event, the events deeper than MaxEventDepth trigger nothing
+	Depth int `json:"depth,omitempty" bson:"depth,omitempty"`
+}
+
+// The types of the events
+const (
+	EventDiaryCreated      = "diary.created"
+	EventDiaryUpdated      = "diary.updated"
+	EventWorkflowCompleted = "workflow.completed"
+)
+
+// EventTypes are the events the workflows can subscribe to
+var EventTypes = []string{EventDiaryCreated, EventDiaryUpdated, EventWorkflowCompleted}
+
+// MaxEventDepth guards the workflows triggering each other by workflow.completed
+const MaxEventDepth = 3
+
+// WorkFlowTrigger executes the workflow when the event Event of the workflow user is published.
+// Question is the expression of the question, e.g. {{event.payload.body}}.
+type WorkFlowTrigger struct {
+	Event    string `json:"event" bson:"event"`
+	Question string `json:"question,omitempty" bson:"question,omitempty"`
+}
@@ -4,4 +4,12 @@ const (
 	EnvMONGOHOST       = "MONGO_HOST"
 	EnvMONGODB         = "MONGO_DB"
 	EnvMONGOCOLLECTION = "MONGO_COLLECTION"
+	// EnvMONGOFORMATCOLLECTION is the collection of the report format templates
+	EnvMONGOFORMATCOLLECTION = "MONGO_FORMAT_COLLECTION"
+	EnvMONGOMAXPOOLSIZE      = "MONGO_MAX_POOL_SIZE"
+	EnvMONGOMINPOOLSIZE      = "MONGO_MIN_POOL_SIZE"
+)
+
+const (
+	MongoDBFormats = "formats"
 )
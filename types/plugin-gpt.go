@@ -1,5 +1,7 @@
 package types
 
+import "os"
+
 type PluginGPTInput struct {
 	Prompt struct {
 		System string `json:"system"`
@@ -7,8 +9,25 @@ type PluginGPTInput struct {
 	MaxTokens   int     `json:"max_tokens"`
 	Temperature float64 `json:"temperature"`
 	Model       string  `json:"model"`
+	// PromptTemplate is the tags of the user's format template used by the system prompt
+	PromptTemplate string `json:"prompt_template"`
 }
 
 const (
 	PluginGPTSKey = "GPT_SKEY"
+	// PluginGPTURL overrides the chat completions url, e.g. to a local fake server
+	PluginGPTURL = "GPT_URL"
+
+	DefaultGPTURL = "https://api.openai.com/v1/chat/completions"
+
+	// PluginGPTOutputFunction is the key of the function gpt chose in the output, the workflow routes by it
+	PluginGPTOutputFunction = "_function"
 )
+
+// GPTURL returns the chat completions url configured by GPT_URL
+func GPTURL() string {
+	if url := os.Getenv(PluginGPTURL); url != "" {
+		return url
+	}
+	return DefaultGPTURL
+}
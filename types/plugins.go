@@ -1,28 +1,10 @@
 package types
 
-// WorkflowContext represents the shared context of a workflow
-
-type WorkflowContext struct {
-	Data map[string]interface{}
-}
-
-func NewWorkFlowContext() *WorkflowContext {
-	return &WorkflowContext{
-		Data: make(map[string]interface{}),
-	}
-}
-
-func (ctx *WorkflowContext) Set(key string, value interface{}) {
-	ctx.Data[key] = value
-}
-
-func (ctx *WorkflowContext) Get(key string) interface{} {
-	return ctx.Data[key]
-}
-
 // Plugin defines the structure for a Plugin in the system
+// Every version of a plugin is stored separately, the one with the largest Version is used by the workflows.
 type Plugin struct {
 	PluginKey  int             `bson:"plugin_key" json:"plugin_key"`
+	Version    int             `bson:"version" json:"version"`
 	Name       string          `bson:"name" json:"name"`
 	Descript   string          `bson:"descript" json:"descript"`
 	Module     string          `bson:"module" json:"module"`
@@ -57,3 +39,25 @@ type PluginReference struct {
 	Up   int   `bson:"up" json:"up"`
 	Down []int `bson:"down" json:"down"`
 }
+
+// PluginRequest is the request of the plugin api, Action is one of
+// AddAction, QueryAction, UpdateAction, VersionAction, DeleteAction and ListAction
+type PluginRequest struct {
+	Action    string `json:"action"`
+	PluginKey int    `json:"plugin_key"`
+	// Version is the plugin version to query, 0 means the latest version
+	Version int `json:"version"`
+	// Module filters the plugins to list
+	Module string  `json:"module"`
+	Plugin *Plugin `json:"plugin,omitempty"`
+}
+
+type PluginResponse struct {
+	Version string   `json:"version"`
+	Msg     string   `json:"msg"`
+	Code    int      `json:"code"`
+	Plugins []Plugin `json:"plugins,omitempty"`
+}
+
+// PluginReferenceStart is the Up of the first plugin of a workflow
+const PluginReferenceStart = -1
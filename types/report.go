@@ -0,0 +1,24 @@
+package types
+
+const (
+	EnvReportModel     = "REPORT_MODEL"
+	EnvReportChunkSize = "REPORT_CHUNK_SIZE"
+)
+
+// ReportRequest asks for a markdown report of the user's diaries between Start and End
+type ReportRequest struct {
+	Version string `json:"version"`
+	User    string `json:"user"`
+	// Start and End are in YYYY-MM-DD format, both are inclusive
+	Start string `json:"start"`
+	End   string `json:"end"`
+	// Tags selects the report template saved by the format api, e.g. "weekly"
+	Tags string `json:"tags,omitempty"`
+}
+
+type ReportResponse struct {
+	Version string `json:"version"`
+	Msg     string `json:"msg"`
+	Code    int    `json:"code"`
+	Report  string `json:"report,omitempty"`
+}
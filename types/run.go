@@ -0,0 +1,21 @@
+package types
+
+// WorkFlowRun is an execution of a workflow paused by an approval step.
+// It's resumed from the step Next when the Token is approved before ExpiresAt.
+type WorkFlowRun struct {
+	Token      string `json:"token" bson:"token"`
+	WorkFlowID string `json:"workflow_id" bson:"workflow_id"`
+	User       string `json:"user" bson:"user"`
+	Name       string `json:"name" bson:"name"`
+	Question   string `json:"question" bson:"question"`
+	// Step is the name of the approval step and Message is its evaluated message
+	Step    string `json:"step" bson:"step"`
+	Message string `json:"message" bson:"message"`
+	// Next is the index of the top level step to resume from
+	Next   int    `json:"next" bson:"next"`
+	Status string `json:"status" bson:"status"`
+	// State is the json of the execution state, the workflow, context and the step outputs
+	State     string `json:"-" bson:"state"`
+	CreatedAt int64  `json:"created_at" bson:"created_at"`
+	ExpiresAt int64  `json:"expires_at" bson:"expires_at"`
+}
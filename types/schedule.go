@@ -0,0 +1,64 @@
+package types
+
+// Schedule executes the workflow WorkFlowID of User with Question on the cron expression Cron.
+// Cron is evaluated in Timezone, UTC if it's empty.
+type Schedule struct {
+	ID         string `json:"id" bson:"id"`
+	User       string `json:"user" bson:"user"`
+	WorkFlowID string `json:"workflow_id" bson:"workflow_id"`
+	Question   string `json:"question" bson:"question"`
+	Cron       string `json:"cron" bson:"cron"`
+	Timezone   string `json:"timezone,omitempty" bson:"timezone,omitempty"`
+	Enabled    bool   `json:"enabled" bson:"enabled"`
+	// NextRun is the unix time of the next execution, LastRun is the unix time of the last one
+	NextRun   int64 `json:"next_run" bson:"next_run"`
+	LastRun   int64 `json:"last_run,omitempty" bson:"last_run,omitempty"`
+	CreatedAt int64 `json:"created_at" bson:"created_at"`
+	// Error is why the scheduler disabled the schedule, it's cleared when the schedule is updated
+	Error string `json:"error,omitempty" bson:"error,omitempty"`
+}
+
+// ScheduleRun is an execution of the schedule, Status is one of the RunStatus constants
+type ScheduleRun struct {
+	ScheduleID string `json:"schedule_id" bson:"schedule_id"`
+	WorkFlowID string `json:"workflow_id" bson:"workflow_id"`
+	User       string `json:"user" bson:"user"`
+	// Owner is the scheduler instance which executed the run
+	Owner      string `json:"owner" bson:"owner"`
+	Status     string `json:"status" bson:"status"`
+	Error      string `json:"error,omitempty" bson:"error,omitempty"`
+	StartedAt  int64  `json:"started_at" bson:"started_at"`
+	FinishedAt int64  `json:"finished_at" bson:"finished_at"`
+}
+
+// ScheduleRequest is the request of the schedule api, Action is one of
+// AddAction, QueryAction, UpdateAction, DeleteAction, ListAction and HistoryAction
+type ScheduleRequest struct {
+	Action string `json:"action"`
+	User   string `json:"user"`
+	ID     string `json:"id"`
+	// Limit is the max runs returned by HistoryAction, DefaultScheduleHistory is used if it's 0
+	Limit    int       `json:"limit"`
+	Schedule *Schedule `json:"schedule,omitempty"`
+}
+
+type ScheduleResponse struct {
+	Version   string        `json:"version"`
+	Msg       string        `json:"msg"`
+	Code      int           `json:"code"`
+	Schedules []Schedule    `json:"schedules,omitempty"`
+	Runs      []ScheduleRun `json:"runs,omitempty"`
+}
+
+const (
+	// DefaultScheduleHistory is the runs returned by the history action by default
+	DefaultScheduleHistory = 20
+	// SchedulerLock is the name of the lock held by the leader scheduler
+	SchedulerLock = "scheduler"
+)
+
+const (
+	MongoDBSchedules    = "schedules"
+	MongoDBScheduleRuns = "schedule_runs"
+	MongoDBLocks        = "locks"
+)
@@ -0,0 +1,8 @@
+package types
+
+const (
+	// EnvStorageBackend selects the storage backend, mongo and weaviate are used by default
+	EnvStorageBackend = "STORAGE_BACKEND"
+
+	StorageBackendMemory = "memory"
+)
@@ -4,4 +4,12 @@ const (
 	AddAction    = "1"
 	QueryAction  = "2"
 	DeleteAction = "3"
+	UpdateAction = "4"
+	ListAction   = "5"
+	// VersionAction saves a new version of the record
+	VersionAction = "6"
+	// HistoryAction lists the runs of the record
+	HistoryAction = "7"
+	// RollbackAction saves an old version of the record as the latest one
+	RollbackAction = "8"
 )
@@ -1,6 +1,7 @@
 package types
 
 import (
+	"strconv"
 	"time"
 )
 
@@ -13,14 +14,54 @@ const (
 	EnvWeaviateHost   = "WEAVIATE_HOST"
 	EnvWeaviateSchema = "WEAVIATE_SCHEMA"
 	EnvWewaviateKey   = "WEAVIATE_KEY"
+	// EnvWeaviateMaxIdleConns is the max idle http connections kept to weaviate
+	EnvWeaviateMaxIdleConns = "WEAVIATE_MAX_IDLE_CONNS"
 )
 
 const (
 	DiaryClassName = "Diary"
 )
 
+// Diary property names, shared by the REST api and the workflow plugins.
+const (
+	DiaryPropUser    = "user"
+	DiaryPropTitle   = "title"
+	DiaryPropContent = "content"
+	DiaryPropTags    = "tags"
+	DiaryPropDate    = "date"
+)
+
+// Diary is the canonical diary record stored in weaviate and mongo.
+// Date is the unix timestamp (seconds) of the diary day.
+type Diary struct {
+	User    string   `json:"user" bson:"user"`
+	Title   string   `json:"title,omitempty" bson:"title,omitempty"`
+	Content string   `json:"content" bson:"content"`
+	Tags    []string `json:"tags,omitempty" bson:"tags,omitempty"`
+	Date    int64    `json:"date" bson:"date"`
+}
+
+// Properties converts the diary to weaviate object properties
+func (d Diary) Properties() map[string]interface{} {
+	tags := d.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+
+	return map[string]interface{}{
+		DiaryPropUser:    d.User,
+		DiaryPropTitle:   d.Title,
+		DiaryPropContent: d.Content,
+		DiaryPropTags:    tags,
+		DiaryPropDate:    d.Date,
+	}
+}
+
 type DirayCreateModel struct {
+	// ID is the id of the diary to update, it's only used by PUT
+	ID       string      `json:"id,omitempty"`
 	User     string      `json:"user"`
+	Title    string      `json:"title,omitempty"`
 	Body     string      `json:"body"`
 	Date     string      `json:"date,omitempty"`
 	Tags     []string    `json:"tags"`
@@ -29,12 +70,42 @@ type DirayCreateModel struct {
 	DateSave time.Time   `json:"-"` // not used in json
 }
 
+// Diary converts the create request to the canonical diary model
+func (dcm DirayCreateModel) Diary() Diary {
+	return Diary{
+		User:    dcm.User,
+		Title:   dcm.Title,
+		Content: dcm.Body,
+		Tags:    dcm.Tags,
+		Date:    dcm.DateSave.Unix(),
+	}
+}
+
 type DirayCreateResponse struct {
 	Version string `json:"version"`
 	Msg     string `json:"msg"`
 	Code    int    `json:"code"`
 }
 
+const (
+	DiarySortRelevance = "relevance"
+	DiarySortDate      = "date"
+
+	DiaryOrderAsc  = "asc"
+	DiaryOrderDesc = "desc"
+)
+
+// Diary search modes, near_text is the default when Keys is set
+const (
+	DiarySearchNearText = "near_text"
+	DiarySearchHybrid   = "hybrid"
+	DiarySearchBM25     = "bm25"
+)
+
+// DefaultDiaryMaxDistance is the max vector distance used when neither
+// MaxDistance nor Certainty is set in the query.
+const DefaultDiaryMaxDistance float32 = 0.25
+
 type DirayQueryModel struct {
 	Version string   `json:"version"`
 	User    string   `json:"user"`
@@ -42,13 +113,55 @@ type DirayQueryModel struct {
 	End     string   `json:"end,omitempty"`
 	Tags    []string `json:"tags,omitempty"`
 	Keys    []string `json:"keys,omitempty"`
+	// Mode is the search mode of Keys, near_text, hybrid or bm25.
+	Mode string `json:"mode,omitempty"`
+	// Alpha weights the hybrid search, 1 is pure vector search and 0 is pure BM25.
+	Alpha *float32 `json:"alpha,omitempty"`
+	// MaxDistance drops the semantic results whose distance is greater than it,
+	// it requires Keys and the near_text mode.
+	MaxDistance *float32 `json:"max_distance,omitempty"`
+	// Certainty drops the semantic results whose certainty is less than it,
+	// it can not be used together with MaxDistance.
+	Certainty *float32 `json:"certainty,omitempty"`
+	Limit     int      `json:"limit,omitempty"`
+	Offset    int      `json:"offset,omitempty"`
+	// Sort is relevance or date, relevance is the default when Keys is set.
+	// A search sorted by date sorts the Limit most relevant results, Offset is rejected.
+	Sort string `json:"sort,omitempty"`
+	// Order is desc or asc, desc is the default: the latest or the most relevant first.
+	Order string `json:"order,omitempty"`
+}
+
+// ParseDiaryDate parses the Start/End of a diary query,
+// both unix timestamp (seconds) and YYYY-MM-DD are accepted.
+func ParseDiaryDate(s string) (int64, error) {
+	if unix, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return unix, nil
+	}
+
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return 0, err
+	}
+	return t.Unix(), nil
+}
+
+// DiaryRecord is a diary returned by a query, with its relevance score
+type DiaryRecord struct {
+	ID string `json:"id"`
+	Diary
+	Distance  *float64 `json:"distance,omitempty"`
+	Certainty *float64 `json:"certainty,omitempty"`
+	// Score is the hybrid or BM25 score
+	Score *float64 `json:"score,omitempty"`
 }
 
 type DirayQueryResponse struct {
-	Version string   `json:"version"`
-	Status  string   `json:"status"`
-	Code    int      `json:"code"`
-	Records []string `json:"records"`
+	Version string        `json:"version"`
+	Status  string        `json:"status"`
+	Code    int           `json:"code"`
+	Records []string      `json:"records"`
+	Results []DiaryRecord `json:"results,omitempty"`
 }
 
 const (
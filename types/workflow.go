@@ -1,77 +1,267 @@
 package types
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
-
-// WorkFlow represents a workflow entity
+// WorkFlow is the workflow definition, it's the only workflow model stored in the workflows collection.
+// The workflows saved before the steps were introduced are converted by driver.MongoCli.MigrateWorkFlows.
 type WorkFlow struct {
-	ID      string `json:"id" bson:"id"`
-	Name    string `json:"name" bson:"name"`
-	Action  string `json:"action" bson:"action"`
-	StepIDs []int  `json:"step_ids" bson:"step_ids"`
+	// ID is the unique identifier used to execute the workflow
+	ID   string `json:"id" bson:"id"`
+	User string `json:"user" bson:"user"`
+	Name string `json:"name" bson:"name"`
+	// Action must be WorkFlowExecute to execute the workflow
+	Action string         `json:"action" bson:"action"`
+	Steps  []WorkFlowStep `json:"steps" bson:"steps"`
+	// Timeout is the seconds an execution may take, 0 means it's only bounded by the request
+	Timeout int `json:"timeout,omitempty" bson:"timeout,omitempty"`
+	// OnFailure runs when a step fails the execution, the error is {{error}} in the expressions
+	OnFailure []WorkFlowStep `json:"on_failure,omitempty" bson:"on_failure,omitempty"`
+	// Triggers execute the workflow when their events are published, the event is {{event}} in the expressions
+	Triggers []WorkFlowTrigger `json:"triggers,omitempty" bson:"triggers,omitempty"`
+	// Version is the immutable version of the definition, every update saves the next version.
+	// The workflow stored in the workflows collection is the current version, the rollback moves it back to an old one.
+	Version int `json:"version,omitempty" bson:"version,omitempty"`
+}
+
+// WorkFlowStep is a step of the workflow, the Kind decides which fields are used.
+// A plugin step runs the latest version of the plugin PluginKey unless PluginVersion is set,
+// the other kinds run their nested steps.
+type WorkFlowStep struct {
+	// Name identifies the step in the workflow, the plugin name is used if empty
+	Name string `json:"name,omitempty" bson:"name,omitempty"`
+	Desc string `json:"desc,omitempty" bson:"desc,omitempty"`
+	// Kind is one of the StepKind constants, empty means StepKindPlugin
+	Kind      string `json:"kind,omitempty" bson:"kind,omitempty"`
+	PluginKey int    `json:"plugin_key,omitempty" bson:"plugin_key,omitempty"`
+	// PluginVersion pins the version of the plugin, 0 means the latest version
+	PluginVersion int `json:"plugin_version,omitempty" bson:"plugin_version,omitempty"`
+	// Input is merged into the plugin input before the step runs, it overrides the values of the upstream plugin
+	Input map[string]interface{} `json:"input,omitempty" bson:"input,omitempty"`
+	// Output binds the fields of the step output to the workflow context keys, "*" binds the whole output
+	Output map[string]string `json:"output,omitempty" bson:"output,omitempty"`
+
+	// If is the expression of the condition step, Then runs if it's true, otherwise Else runs
+	If   string         `json:"if,omitempty" bson:"if,omitempty"`
+	Then []WorkFlowStep `json:"then,omitempty" bson:"then,omitempty"`
+	Else []WorkFlowStep `json:"else,omitempty" bson:"else,omitempty"`
+
+	// Switch is the expression of the switch step, the case of its value runs, otherwise Default runs
+	Switch  string                    `json:"switch,omitempty" bson:"switch,omitempty"`
+	Cases   map[string][]WorkFlowStep `json:"cases,omitempty" bson:"cases,omitempty"`
+	Default []WorkFlowStep            `json:"default,omitempty" bson:"default,omitempty"`
+
+	// Foreach is the expression of the array the foreach step iterates, Do runs once per item.
+	// The item is {{As}} ("item" by default) and its index is {{index}} in the expressions of Do.
+	Foreach string         `json:"foreach,omitempty" bson:"foreach,omitempty"`
+	As      string         `json:"as,omitempty" bson:"as,omitempty"`
+	Do      []WorkFlowStep `json:"do,omitempty" bson:"do,omitempty"`
+	// MaxIterations fails the foreach step if the array is longer, DefaultMaxIterations is used if it's 0
+	MaxIterations int `json:"max_iterations,omitempty" bson:"max_iterations,omitempty"`
+
+	// Message is the expression shown to the approver of the approval step,
+	// the execution pauses until the token is approved or rejected, Expires is the seconds the token is valid
+	Message string `json:"message,omitempty" bson:"message,omitempty"`
+	Expires int    `json:"expires,omitempty" bson:"expires,omitempty"`
+
+	// Timeout is the seconds the step may take, the nested steps included, 0 means no limit of its own.
+	// Every attempt of Retry has its own timeout.
+	Timeout int `json:"timeout,omitempty" bson:"timeout,omitempty"`
+
+	// Retry runs the failed step again, Fallback runs if the step still fails.
+	// The execution goes on with the next step if ContinueOnError is set and the fallback fails too.
+	Retry           *RetryPolicy   `json:"retry,omitempty" bson:"retry,omitempty"`
+	Fallback        []WorkFlowStep `json:"fallback,omitempty" bson:"fallback,omitempty"`
+	ContinueOnError bool           `json:"continue_on_error,omitempty" bson:"continue_on_error,omitempty"`
+}
+
+// RetryPolicy decides how a failed step is retried
+type RetryPolicy struct {
+	// Attempts is the max runs of the step, the first run included
+	Attempts int `json:"attempts" bson:"attempts"`
+	// Backoff is the milliseconds to wait before the first retry, it's doubled before every next retry up to MaxBackoff
+	Backoff    int `json:"backoff,omitempty" bson:"backoff,omitempty"`
+	MaxBackoff int `json:"max_backoff,omitempty" bson:"max_backoff,omitempty"`
+	// On is the ErrorClass constants to retry, all the errors are retried if it's empty
+	On []string `json:"on,omitempty" bson:"on,omitempty"`
+}
+
+// The classes of the step errors
+const (
+	ErrorClassTimeout   = "timeout"
+	ErrorClassRateLimit = "rate_limit"
+	ErrorClassServer    = "server"
+	ErrorClassNetwork   = "network"
+	ErrorClassPlugin    = "plugin"
+)
+
+// StepAttempt records a run of a plugin step or of a step with a retry policy
+type StepAttempt struct {
+	Step    string `json:"step"`
+	Attempt int    `json:"attempt"`
+	Class   string `json:"class,omitempty"`
+	Error   string `json:"error,omitempty"`
+	// Duration is in milliseconds
+	Duration int64 `json:"duration"`
 }
 
-// Step represents a step entity within a workflow
-type Step struct {
-	ID      string `json:"id"`
-	Name    string `json:"name"`
-	Command string `json:"command"`
+const (
+	StepKindPlugin    = "plugin"
+	StepKindCondition = "condition"
+	StepKindSwitch    = "switch"
+	StepKindForeach   = "foreach"
+	// StepKindApproval pauses the execution, it must be a top level step
+	StepKindApproval = "approval"
+)
+
+const (
+	// DefaultMaxIterations is the max items a foreach step iterates
+	DefaultMaxIterations = 100
+	// MaxStepRuns is the max plugin steps a workflow execution runs, it guards the nested loops
+	MaxStepRuns = 1000
+	// DefaultApprovalExpires is the seconds an approval token is valid
+	DefaultApprovalExpires = 24 * 60 * 60
+	// OnFailureTimeout is the seconds the OnFailure steps may take, they run after the execution is cancelled too
+	OnFailureTimeout = 30
+)
+
+// StepOutputAll binds the whole step output
+const StepOutputAll = "*"
+
+// WorkFlowExecute is the Action of the executable workflows
+const WorkFlowExecute = "execute"
+
+// Children returns the nested steps of the step
+func (s WorkFlowStep) Children() []WorkFlowStep {
+	children := append(append([]WorkFlowStep(nil), s.Then...), s.Else...)
+	for _, c := range s.Cases {
+		children = append(children, c...)
+	}
+	children = append(children, s.Default...)
+	children = append(children, s.Do...)
+	return append(children, s.Fallback...)
+}
+
+// PluginKeys returns the plugin keys of the steps and their nested steps
+func (wf WorkFlow) PluginKeys() []int {
+	var keys []int
+	for _, s := range wf.PluginSteps() {
+		keys = append(keys, s.PluginKey)
+	}
+	return keys
+}
+
+// PluginSteps returns the plugin steps and the nested plugin steps
+func (wf WorkFlow) PluginSteps() []WorkFlowStep {
+	var plugins []WorkFlowStep
+	var walk func(steps []WorkFlowStep)
+	walk = func(steps []WorkFlowStep) {
+		for _, s := range steps {
+			if s.Kind == "" || s.Kind == StepKindPlugin {
+				plugins = append(plugins, s)
+			}
+			walk(s.Children())
+		}
+	}
+
+	walk(wf.Steps)
+	walk(wf.OnFailure)
+	return plugins
 }
 
 // Result represents the result of a workflow execution
 type Result struct {
-	WorkFlowID  string                 `json:"workflow_id"`
+	WorkFlowID string `json:"workflow_id"`
+	// Version is the version of the workflow executed
+	Version     int                    `json:"version,omitempty"`
 	Status      string                 `json:"status"`
 	StepResults map[string]interface{} `json:"step_results"`
+	// Token approves or rejects the execution paused by an approval step, Message is shown to the approver
+	Token     string `json:"token,omitempty"`
+	Message   string `json:"message,omitempty"`
+	ExpiresAt int64  `json:"expires_at,omitempty"`
+	// Error is the error of the failed execution
+	Error    string        `json:"error,omitempty"`
+	Attempts []StepAttempt `json:"attempts,omitempty"`
+	// DryRun is true if the execution had no side effects, Intents are the side effects it would have
+	DryRun  bool     `json:"dry_run,omitempty"`
+	Intents []Intent `json:"intents,omitempty"`
+	// Trace is the workflow context after every plugin step of the step mode
+	Trace []StepSnapshot `json:"trace,omitempty"`
 }
 
-// WorkFlowModel represents a workflow model.
-type WorkFlowModel struct {
-	// WorkFlowId is the unique identifier of the workflow.
-	WorkFlowId int `json:"workflow_id" bson:"workflow_id"`
-	// User is the user who created the workflow.
-	User string `json:"user" bson:"user"`
-	// Name is the name of the workflow.
-	Name string `json:"name" bson:"name"`
-	// StepIds is a list of step IDs in the workflow.
-	StepIds []int `json:"step_ids" bson:"step_ids"`
-	// Flows is a list of flow models in the workflow.
-	Flows []FlowModel `json:"flows" bson:"flows"`
+// Intent is the side effect of a step, the dry run reports it instead of acting
+type Intent struct {
+	Step   string `json:"step"`
+	Plugin string `json:"plugin,omitempty"`
+	// Action is the action of the plugin, e.g. create, or the kind of the step, e.g. approval
+	Action string      `json:"action"`
+	Target string      `json:"target,omitempty"`
+	Data   interface{} `json:"data,omitempty"`
 }
 
-type FlowModel struct {
-	StepId   int                    `json:"step_id" bson:"step_id"`
-	Name     string                 `json:"name" bson:"name"`
-	Desc     string                 `json:"desc" bson:"desc"`
-	Kind     string                 `json:"kind" bson:"kind"`
-	Input    map[string]interface{} `json:"input" bson:"input"`
-	Output   map[string]interface{} `json:"output" bson:"output"`
-	ObjectID primitive.ObjectID     `json:"objectId" bson:"_id,omitempty"`
+// StepSnapshot is the output of a plugin step and the workflow context after it
+type StepSnapshot struct {
+	Step    string          `json:"step"`
+	Output  interface{}     `json:"output,omitempty"`
+	Context ContextSnapshot `json:"context"`
 }
 
+// The status of the workflow executions
+const (
+	RunStatusCompleted = "Completed"
+	RunStatusPending   = "Pending"
+	RunStatusApproved  = "Approved"
+	RunStatusRejected  = "Rejected"
+	RunStatusExpired   = "Expired"
+	RunStatusFailed    = "Failed"
+)
+
 type WorkFlowRequest struct {
 	Action   int    `json:"action"`
 	User     string `json:"user"`
 	Name     string `json:"name"`
 	Question string `json:"question"`
+	// Flow is the workflow to create or update
+	Flow *WorkFlow `json:"flow,omitempty"`
+	// Token is the approval token of the paused execution to approve or reject
+	Token string `json:"token,omitempty"`
+	// Version is the workflow version to get, execute or roll back to, 0 means the current version
+	Version int `json:"version,omitempty"`
+	// DryRun executes the workflow without the side effects, the plugins report their intents instead of acting.
+	// The GPT plugin still calls openai so the next steps get the real output, the call is reported as a chat intent.
+	DryRun bool `json:"dry_run,omitempty"`
+	// Step records the workflow context after every plugin step in the result
+	Step bool `json:"step,omitempty"`
+	// Event is the event which triggered the execution, it's never read from the api request
+	Event *Event `json:"-"`
 }
 
 const (
 	WorkFlowActionNew = iota
 	WorkFlowActionGet
 	WorkFlowActionExecute
+	WorkFlowActionList
+	WorkFlowActionUpdate
+	WorkFlowActionDelete
+	WorkFlowActionApprove
+	WorkFlowActionReject
+	// WorkFlowActionVersions lists the versions of the workflow, the latest first
+	WorkFlowActionVersions
+	// WorkFlowActionRollback makes the version of the workflow current again
+	WorkFlowActionRollback
 )
 
 type WorkFlowResponse struct {
-	Version string           `json:"version"`
-	Msg     string           `json:"msg"`
-	Code    int              `json:"code"`
-	Flows   []*WorkFlowModel `json:"flows,omitempty"`
+	Version string      `json:"version"`
+	Msg     string      `json:"msg"`
+	Code    int         `json:"code"`
+	Flows   []*WorkFlow `json:"flows,omitempty"`
 }
 
 const (
-	MongoDBWorkFlow = "workflows"
-	MongoDBSteps    = "steps"
-	MongoDBPlugins  = "plugins"
+	MongoDBWorkFlow     = "workflows"
+	MongoDBWorkFlowRuns = "workflow_runs"
+	// MongoDBWorkFlowVersions keeps every version of the workflows
+	MongoDBWorkFlowVersions = "workflow_versions"
+	MongoDBPlugins          = "plugins"
 )
 
 type WorkFlowBaseInfo struct {
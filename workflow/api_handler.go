@@ -2,10 +2,14 @@ package workflow
 
 // APIHandler 结构体，用于处理API请求。
 // NewAPIHandler 函数，用于初始化 APIHandler。
-// HandleWorkFlowRequest 函数，用于处理 /v1/workflow API端点。这个函数会读取工作流ID（假设它是作为查询参数传递的），执行工作流，并返回序列化的结果。
+// HandleWorkFlowRequest 函数，用于处理 /v1/workflow API端点。根据 WorkFlowRequest.Action 创建、查询、列出、更新、删除、执行工作流，或者审批暂停的执行。
+// 执行工作流时会读取工作流ID（假设它是作为查询参数传递的），执行工作流，并返回序列化的结果。
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
 
 	"github.com/andy-zhangtao/Functions/types"
@@ -35,6 +39,32 @@ func (handler *APIHandler) error(format string, args ...interface{}) {
 	logrus.Errorf(format, args...)
 }
 
+// decodeWorkFlowRequest decodes the request body.
+// The requests before the actions have no action and execute the workflow,
+// so a missing action is execute even though the zero action is create.
+func decodeWorkFlowRequest(body io.Reader) (types.WorkFlowRequest, error) {
+	var req types.WorkFlowRequest
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return req, err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return req, err
+	}
+
+	if err := json.Unmarshal(data, &req); err != nil {
+		return req, err
+	}
+
+	if _, ok := fields["action"]; !ok {
+		req.Action = types.WorkFlowActionExecute
+	}
+	return req, nil
+}
+
 // HandleWorkFlowRequest handles the /v1/workflow API endpoint
 func (handler *APIHandler) HandleWorkFlowRequest(w http.ResponseWriter, r *http.Request) {
 
@@ -42,23 +72,82 @@ func (handler *APIHandler) HandleWorkFlowRequest(w http.ResponseWriter, r *http.
 	workflowID := r.URL.Query().Get("id")
 	handler.log("HandleWorkFlowRequest with %s", workflowID)
 
-	var req types.WorkFlowRequest
-
 	// Deserialize the request from body
-	err := json.NewDecoder(r.Body).Decode(&req)
+	req, err := decodeWorkFlowRequest(r.Body)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	switch req.Action {
+	case types.WorkFlowActionExecute:
+	case types.WorkFlowActionApprove, types.WorkFlowActionReject:
+		handler.handleResume(r.Context(), w, req)
+		return
+	default:
+		handler.handleFlowAction(r.Context(), w, req)
+		return
+	}
+
 	handler.log("Executing workflow: %s with %+v", workflowID, req)
 	// Execute the workflow
-	result, err := handler.Service.ExecuteWorkFlow(workflowID, req)
+	result, err := handler.Service.ExecuteWorkFlow(r.Context(), workflowID, req)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		handler.error("execute workflow %s error: %v", workflowID, err)
+
+		code := http.StatusBadRequest
+		if errors.Is(err, context.DeadlineExceeded) {
+			code = http.StatusGatewayTimeout
+		}
+		errorResponse(w, code, result, err)
+		return
+	}
+
+	resultResponse(w, http.StatusOK, result)
+}
+
+// handleResume approves or rejects the execution paused with req.Token
+func (handler *APIHandler) handleResume(ctx context.Context, w http.ResponseWriter, req types.WorkFlowRequest) {
+	handler.log("workflow action %d with token %s", req.Action, req.Token)
+
+	if req.Token == "" {
+		http.Error(w, "token is empty", http.StatusBadRequest)
+		return
+	}
+
+	result, err := handler.Service.ResumeWorkFlow(ctx, req.User, req.Token, req.Action == types.WorkFlowActionApprove)
+	if err != nil {
+		handler.error("workflow action %d error: %v", req.Action, err)
+
+		code := http.StatusBadRequest
+		switch {
+		case errors.Is(err, ErrRunNotFound):
+			code = http.StatusNotFound
+		case errors.Is(err, ErrRunFinished):
+			code = http.StatusConflict
+		case errors.Is(err, ErrRunExpired):
+			code = http.StatusGone
+		case errors.Is(err, context.DeadlineExceeded):
+			code = http.StatusGatewayTimeout
+		}
+		errorResponse(w, code, result, err)
+		return
+	}
+
+	resultResponse(w, http.StatusOK, result)
+}
+
+// errorResponse writes the failed result of the execution, or only the error if the execution didn't start
+func errorResponse(w http.ResponseWriter, code int, result *types.Result, err error) {
+	if result == nil {
+		http.Error(w, err.Error(), code)
 		return
 	}
+	resultResponse(w, code, result)
+}
 
+// resultResponse writes the result of the execution
+func resultResponse(w http.ResponseWriter, code int, result *types.Result) {
 	// Serialize and return the result
 	jsonResult, err := json.Marshal(result)
 	if err != nil {
@@ -67,5 +156,92 @@ func (handler *APIHandler) HandleWorkFlowRequest(w http.ResponseWriter, r *http.
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
 	w.Write(jsonResult)
 }
+
+// handleFlowAction creates, gets, lists, updates, deletes or rolls back the workflows of req.User
+func (handler *APIHandler) handleFlowAction(ctx context.Context, w http.ResponseWriter, req types.WorkFlowRequest) {
+	handler.log("workflow action %d of %s with name %s", req.Action, req.User, req.Name)
+
+	if req.User == "" {
+		workflowResponse(w, http.StatusBadRequest, types.WorkFlowResponse{Code: http.StatusBadRequest, Msg: "user is empty"})
+		return
+	}
+
+	flows, err := handler.flowAction(ctx, req)
+	if err != nil {
+		handler.error("workflow action %d error: %v", req.Action, err)
+
+		code := http.StatusBadRequest
+		switch {
+		case errors.Is(err, ErrWorkFlowNotFound), errors.Is(err, ErrVersionNotFound):
+			code = http.StatusNotFound
+		case errors.Is(err, ErrWorkFlowExists), errors.Is(err, ErrWorkFlowConflict):
+			code = http.StatusConflict
+		}
+		workflowResponse(w, code, types.WorkFlowResponse{Code: code, Msg: err.Error()})
+		return
+	}
+
+	workflowResponse(w, http.StatusOK, types.WorkFlowResponse{Code: http.StatusOK, Flows: flows})
+}
+
+func (handler *APIHandler) flowAction(ctx context.Context, req types.WorkFlowRequest) ([]*types.WorkFlow, error) {
+	wf := NewWorkFlow(handler.Service.Repos.Workflow, handler.Service.Repos.Plugin, handler.traceId)
+
+	switch req.Action {
+	case types.WorkFlowActionGet:
+		flow, err := wf.FindWorkFlowVersion(ctx, req.Name, req.User, req.Version)
+		if err != nil {
+			return nil, err
+		}
+		return []*types.WorkFlow{flow}, nil
+	case types.WorkFlowActionList:
+		return wf.FindAllWorkFlows(ctx, req.User)
+	case types.WorkFlowActionVersions:
+		return wf.Versions(ctx, req.Name, req.User)
+	case types.WorkFlowActionRollback:
+		flow, err := wf.Rollback(ctx, req.Name, req.User, req.Version)
+		if err != nil {
+			return nil, err
+		}
+		return []*types.WorkFlow{flow}, nil
+	case types.WorkFlowActionDelete:
+		return nil, wf.DeleteWorkFlow(ctx, req.Name, req.User)
+	case types.WorkFlowActionNew, types.WorkFlowActionUpdate:
+		if req.Flow == nil {
+			return nil, errors.New("flow is empty")
+		}
+
+		flow := *req.Flow
+		flow.User = req.User
+		if flow.Name == "" {
+			flow.Name = req.Name
+		}
+
+		var err error
+		if req.Action == types.WorkFlowActionNew {
+			err = wf.NewWorkFlow(ctx, &flow)
+		} else {
+			err = wf.UpdateWorkFlow(ctx, &flow)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return []*types.WorkFlow{&flow}, nil
+	default:
+		return nil, errors.New("invalid action")
+	}
+}
+
+func workflowResponse(w http.ResponseWriter, code int, data types.WorkFlowResponse) {
+	if data.Version == "" {
+		data.Version = types.RequestVersionDefault
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	json.NewEncoder(w).Encode(data)
+}
@@ -0,0 +1,150 @@
+package workflow
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/andy-zhangtao/Functions/driver"
+	"github.com/andy-zhangtao/Functions/tools/tmockgpt"
+	"github.com/andy-zhangtao/Functions/types"
+)
+
+func TestHandleWorkFlowRequestCRUD(t *testing.T) {
+	repos := driver.NewMemoryRepositories()
+	seedDiaryWorkflow(repos.Workflow.(*driver.MemoryStore))
+	handler := NewAPIHandler(NewWorkFlowService(repos, "test"), "test")
+
+	do := func(req types.WorkFlowRequest) (int, types.WorkFlowResponse) {
+		body, _ := json.Marshal(req)
+		w := httptest.NewRecorder()
+		handler.HandleWorkFlowRequest(w, httptest.NewRequest(http.MethodPost, "/v1/workflow", bytes.NewReader(body)))
+
+		var res types.WorkFlowResponse
+		if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+			t.Fatalf("decode response error: %v", err)
+		}
+		return w.Code, res
+	}
+
+	flow := &types.WorkFlow{Steps: []types.WorkFlowStep{{PluginKey: 2}, {PluginKey: 1}}}
+	tests := []struct {
+		name  string
+		req   types.WorkFlowRequest
+		code  int
+		flows int
+	}{
+		{name: "create", req: types.WorkFlowRequest{Action: types.WorkFlowActionNew, User: "tester", Name: "diary", Flow: flow}, code: http.StatusOK, flows: 1},
+		{name: "create duplicated", req: types.WorkFlowRequest{Action: types.WorkFlowActionNew, User: "tester", Name: "diary", Flow: flow}, code: http.StatusConflict},
+		{name: "create unknown plugin", req: types.WorkFlowRequest{Action: types.WorkFlowActionNew, User: "tester", Name: "bad", Flow: &types.WorkFlow{Steps: []types.WorkFlowStep{{PluginKey: 404}}}}, code: http.StatusBadRequest},
+		{name: "create nested approval", req: types.WorkFlowRequest{Action: types.WorkFlowActionNew, User: "tester", Name: "nested", Flow: &types.WorkFlow{Steps: []types.WorkFlowStep{{Kind: types.StepKindCondition, If: "{{request.user}}", Then: []types.WorkFlowStep{{Kind: types.StepKindApproval}}}}}}, code: http.StatusBadRequest},
+		{name: "create unknown retry class", req: types.WorkFlowRequest{Action: types.WorkFlowActionNew, User: "tester", Name: "retry", Flow: &types.WorkFlow{Steps: []types.WorkFlowStep{{PluginKey: 2, Retry: &types.RetryPolicy{Attempts: 2, On: []string{"oops"}}}}}}, code: http.StatusBadRequest},
+		{name: "create unknown trigger", req: types.WorkFlowRequest{Action: types.WorkFlowActionNew, User: "tester", Name: "trigger", Flow: &types.WorkFlow{Steps: []types.WorkFlowStep{{PluginKey: 2}}, Triggers: []types.WorkFlowTrigger{{Event: "diary.deleted"}}}}, code: http.StatusBadRequest},
+		{name: "get", req: types.WorkFlowRequest{Action: types.WorkFlowActionGet, User: "tester", Name: "diary"}, code: http.StatusOK, flows: 1},
+		{name: "update", req: types.WorkFlowRequest{Action: types.WorkFlowActionUpdate, User: "tester", Name: "diary", Flow: &types.WorkFlow{Steps: []types.WorkFlowStep{{PluginKey: 2}}}}, code: http.StatusOK, flows: 1},
+		{name: "get version", req: types.WorkFlowRequest{Action: types.WorkFlowActionGet, User: "tester", Name: "diary", Version: 1}, code: http.StatusOK, flows: 1},
+		{name: "get unknown version", req: types.WorkFlowRequest{Action: types.WorkFlowActionGet, User: "tester", Name: "diary", Version: 9}, code: http.StatusNotFound},
+		{name: "versions", req: types.WorkFlowRequest{Action: types.WorkFlowActionVersions, User: "tester", Name: "diary"}, code: http.StatusOK, flows: 2},
+		{name: "rollback", req: types.WorkFlowRequest{Action: types.WorkFlowActionRollback, User: "tester", Name: "diary", Version: 1}, code: http.StatusOK, flows: 1},
+		{name: "rollback unknown version", req: types.WorkFlowRequest{Action: types.WorkFlowActionRollback, User: "tester", Name: "diary", Version: 9}, code: http.StatusNotFound},
+		{name: "versions after rollback", req: types.WorkFlowRequest{Action: types.WorkFlowActionVersions, User: "tester", Name: "diary"}, code: http.StatusOK, flows: 2},
+		{name: "update unknown", req: types.WorkFlowRequest{Action: types.WorkFlowActionUpdate, User: "tester", Name: "other", Flow: flow}, code: http.StatusNotFound},
+		{name: "list", req: types.WorkFlowRequest{Action: types.WorkFlowActionList, User: "tester"}, code: http.StatusOK, flows: 1},
+		{name: "delete", req: types.WorkFlowRequest{Action: types.WorkFlowActionDelete, User: "tester", Name: "diary"}, code: http.StatusOK},
+		{name: "get deleted", req: types.WorkFlowRequest{Action: types.WorkFlowActionGet, User: "tester", Name: "diary"}, code: http.StatusNotFound},
+		{name: "without user", req: types.WorkFlowRequest{Action: types.WorkFlowActionList}, code: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, res := do(tt.req)
+			if code != tt.code || len(res.Flows) != tt.flows {
+				t.Errorf("code = %d, flows = %d (%s), want %d and %d", code, len(res.Flows), res.Msg, tt.code, tt.flows)
+			}
+		})
+	}
+}
+
+func TestNewWorkFlowConcurrent(t *testing.T) {
+	repos := driver.NewMemoryRepositories()
+	seedDiaryWorkflow(repos.Workflow.(*driver.MemoryStore))
+	wf := NewWorkFlow(repos.Workflow, repos.Plugin, "test")
+
+	// every create passes the name check before any of them is saved
+	const creates = 8
+	errs := make(chan error, creates)
+	var wg sync.WaitGroup
+	for i := 0; i < creates; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- wf.NewWorkFlow(context.Background(), &types.WorkFlow{User: "racer", Name: "diary", Steps: []types.WorkFlowStep{{PluginKey: 2}}})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	created := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			created++
+		case !errors.Is(err, ErrWorkFlowExists):
+			t.Errorf("NewWorkFlow error = %v, want ErrWorkFlowExists", err)
+		}
+	}
+
+	flows, _ := repos.Workflow.FindWorkFlows(context.Background(), "racer")
+	if created != 1 || len(flows) != 1 {
+		t.Errorf("created = %d, workflows = %d, want 1 and 1", created, len(flows))
+	}
+}
+
+func TestHandleWorkFlowRequestExecute(t *testing.T) {
+	gpt := tmockgpt.NewServer(tmockgpt.On(tmockgpt.FunctionIs("weaviate"),
+		tmockgpt.FunctionCall("weaviate", `{"action":"1","title":"Father","body":"完成了Father的初步设计","tags":"father","user":"someone","date":"2023-08-01"}`)))
+	defer gpt.Close()
+	t.Setenv(types.PluginGPTURL, gpt.URL)
+
+	tests := []struct {
+		name   string
+		body   string
+		code   int
+		status string
+	}{
+		{name: "legacy without action", body: `{"user":"tester","question":"记录今天的工作"}`, code: http.StatusOK, status: "Completed"},
+		{name: "execute", body: `{"action":2,"user":"tester","question":"记录今天的工作"}`, code: http.StatusOK, status: "Completed"},
+		{name: "invalid body", body: `{"user":`, code: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repos := driver.NewMemoryRepositories()
+			seedDiaryWorkflow(repos.Workflow.(*driver.MemoryStore))
+			handler := NewAPIHandler(NewWorkFlowService(repos, "test"), "test")
+
+			w := httptest.NewRecorder()
+			handler.HandleWorkFlowRequest(w, httptest.NewRequest(http.MethodPost, "/v1/workflow?id=1", strings.NewReader(tt.body)))
+			if w.Code != tt.code {
+				t.Fatalf("code = %d (%s), want %d", w.Code, w.Body.String(), tt.code)
+			}
+			if tt.status == "" {
+				return
+			}
+
+			var result types.Result
+			if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+				t.Fatalf("decode result error: %v", err)
+			}
+			if result.Status != tt.status {
+				t.Errorf("status = %s, want %s", result.Status, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+package workflow
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/andy-zhangtao/Functions/driver"
+	"github.com/andy-zhangtao/Functions/tools/texpr"
+	"github.com/andy-zhangtao/Functions/types"
+)
+
+var (
+	// ErrRunNotFound is returned when the approval token does not exist
+	ErrRunNotFound = errors.New("approval token not found")
+	// ErrRunFinished is returned when the paused execution is already approved, rejected or expired
+	ErrRunFinished = errors.New("approval token is already used")
+	// ErrRunExpired is returned when the approval token is expired
+	ErrRunExpired = errors.New("approval token is expired")
+)
+
+// runState is the execution state saved with the paused run.
+// The workflow is saved too, so the run resumes with the definition it was paused with.
+type runState struct {
+	Flow     *types.WorkFlow        `json:"flow"`
+	Context  types.ContextSnapshot  `json:"context"`
+	Steps    map[string]interface{} `json:"steps"`
+	Results  map[string]interface{} `json:"results"`
+	Previous interface{}            `json:"previous"`
+	Attempts []types.StepAttempt    `json:"attempts,omitempty"`
+	// Runs is the number of the steps run before the pause, the resumed run goes on counting to types.MaxStepRuns
+	Runs int `json:"runs,omitempty"`
+	// Step keeps the step mode of the execution after it's resumed
+	Step bool `json:"step,omitempty"`
+}
+
+// pause saves the execution before the approval step index and returns the pending result with the token,
+// the error fails the execution like the errors of the other steps
+func (service *WorkFlowService) pause(ctx context.Context, workflow *types.WorkFlow, exec *execution, index int) (*types.Result, error) {
+	step := workflow.Steps[index]
+
+	message, err := texpr.EvalString(step.Message, exec.scope)
+	if err != nil {
+		return nil, fmt.Errorf("evaluate message of step %s error: %w", step.Name, err)
+	}
+
+	state, err := json.Marshal(runState{
+		Flow:     workflow,
+		Context:  service.ctx.Snapshot(),
+		Steps:    exec.scope["steps"].(map[string]interface{}),
+		Results:  exec.results,
+		Previous: exec.previous,
+		Attempts: exec.attempts,
+		Runs:     exec.runs,
+		Step:     exec.query.Step,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("marshal the state of step %s error: %w", step.Name, err)
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+
+	expires := step.Expires
+	if expires == 0 {
+		expires = types.DefaultApprovalExpires
+	}
+
+	now := time.Now()
+	run := &types.WorkFlowRun{
+		Token:      token,
+		WorkFlowID: workflow.ID,
+		User:       exec.query.User,
+		Name:       exec.query.Name,
+		Question:   exec.query.Question,
+		Step:       step.Name,
+		Message:    fmt.Sprintf("%v", message),
+		Next:       index + 1,
+		Status:     types.RunStatusPending,
+		State:      string(state),
+		CreatedAt:  now.Unix(),
+		ExpiresAt:  now.Add(time.Duration(expires) * time.Second).Unix(),
+	}
+
+	if err := service.Repos.Run.SaveRun(ctx, run); err != nil {
+		service.error("save run of step %s error: %v", step.Name, err)
+		return nil, err
+	}
+
+	service.log("workflow %s paused at step %s", workflow.ID, step.Name)
+	result := exec.result(workflow, types.RunStatusPending)
+	result.Token, result.Message, result.ExpiresAt = token, run.Message, run.ExpiresAt
+	return result, nil
+}
+
+// approveDryRun reports the approval step as an intent, the dry run doesn't pause
+func (service *WorkFlowService) approveDryRun(exec *execution, step types.WorkFlowStep) error {
+	message, err := texpr.EvalString(step.Message, exec.scope)
+	if err != nil {
+		return fmt.Errorf("evaluate message of step %s error: %w", step.Name, err)
+	}
+
+	service.log("dry run approves step %s", step.Name)
+	exec.intents = append(exec.intents, types.Intent{Step: step.Name, Action: types.StepKindApproval, Data: message})
+	return nil
+}
+
+// ResumeWorkFlow approves or rejects the execution of the user paused with the token.
+// The approved execution continues from the step after the approval step, the rejected one ends.
+// The token of another user's execution is not found.
+func (service *WorkFlowService) ResumeWorkFlow(ctx context.Context, user, token string, approve bool) (*types.Result, error) {
+	run, err := service.Repos.Run.GetRun(ctx, token)
+	if errors.Is(err, driver.ErrNotFound) {
+		return nil, ErrRunNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if run.User != user {
+		service.error("user %s resumes the run %s of %s", user, token, run.User)
+		return nil, ErrRunNotFound
+	}
+
+	if run.Status != types.RunStatusPending {
+		return nil, ErrRunFinished
+	}
+
+	status := types.RunStatusRejected
+	if approve {
+		status = types.RunStatusApproved
+	}
+	if time.Now().Unix() > run.ExpiresAt {
+		status = types.RunStatusExpired
+	}
+
+	err = service.Repos.Run.FinishRun(ctx, token, status)
+	if errors.Is(err, driver.ErrNotFound) {
+		// another request resumed the run first
+		return nil, ErrRunFinished
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if status == types.RunStatusExpired {
+		return nil, ErrRunExpired
+	}
+
+	var state runState
+	if err := json.Unmarshal([]byte(run.State), &state); err != nil {
+		return nil, fmt.Errorf("unmarshal the state of run %s error: %w", token, err)
+	}
+
+	if !approve {
+		service.log("workflow %s rejected at step %s", run.WorkFlowID, run.Step)
+		return &types.Result{
+			WorkFlowID:  run.WorkFlowID,
+			Version:     state.Flow.Version,
+			Status:      types.RunStatusRejected,
+			StepResults: state.Results,
+		}, nil
+	}
+
+	service.log("workflow %s approved at step %s", run.WorkFlowID, run.Step)
+	service.ctx.Restore(state.Context)
+	service.ctx.Set(types.ScopeRequest, types.TraceID, service.traceId)
+
+	query := types.WorkFlowRequest{User: run.User, Name: run.Name, Question: run.Question, Step: state.Step}
+	if event, err := types.ContextValue[types.Event](service.ctx, types.ScopeRequest, types.CtxEvent); err == nil {
+		query.Event = &event
+	}
+	exec := &execution{
+		scope:    service.scope(query),
+		query:    query,
+		results:  state.Results,
+		previous: state.Previous,
+		attempts: state.Attempts,
+		runs:     state.Runs,
+	}
+	if state.Steps != nil {
+		exec.scope["steps"] = state.Steps
+	}
+	if exec.results == nil {
+		exec.results = make(map[string]interface{})
+	}
+
+	return service.run(ctx, state.Flow, exec, run.Next)
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate approval token error: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
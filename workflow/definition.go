@@ -0,0 +1,379 @@
+package workflow
+
+// The definition files keep the workflows and the plugins they use in git.
+// ParseDefinition and MarshalDefinition convert the yaml or json files, Loader validates and upserts the definitions
+// and exports the workflows of a user with their plugins.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/andy-zhangtao/Functions/driver"
+	fplugin "github.com/andy-zhangtao/Functions/service/f_plugin"
+	"github.com/andy-zhangtao/Functions/types"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// ParseDefinition parses the definition file of the format, the unknown fields are rejected.
+// The yaml keys are the json names of the fields, e.g. plugin_key.
+func ParseDefinition(data []byte, format string) (*types.Definition, error) {
+	switch format {
+	case types.DefinitionJSON:
+	case types.DefinitionYAML:
+		var raw interface{}
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return nil, fmt.Errorf("parse yaml definition error: %w", err)
+		}
+
+		var err error
+		if data, err = json.Marshal(jsonValue(raw)); err != nil {
+			return nil, fmt.Errorf("convert yaml definition error: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown definition format %s", format)
+	}
+
+	var def types.Definition
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&def); err != nil {
+		return nil, fmt.Errorf("parse definition error: %w", err)
+	}
+	return &def, nil
+}
+
+// MarshalDefinition writes the definition in the format, the yaml keys are in the order of the json fields
+func MarshalDefinition(def *types.Definition, format string) ([]byte, error) {
+	data, err := json.MarshalIndent(def, "", "    ")
+	if err != nil {
+		return nil, fmt.Errorf("marshal definition error: %w", err)
+	}
+
+	switch format {
+	case types.DefinitionJSON:
+		return data, nil
+	case types.DefinitionYAML:
+		// json is yaml, the node keeps the order of the keys
+		var node yaml.Node
+		if err := yaml.Unmarshal(data, &node); err != nil {
+			return nil, fmt.Errorf("convert definition to yaml error: %w", err)
+		}
+		blockStyle(&node)
+
+		var buf bytes.Buffer
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		if err := enc.Encode(&node); err != nil {
+			return nil, fmt.Errorf("marshal yaml definition error: %w", err)
+		}
+		enc.Close()
+		return buf.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("unknown definition format %s", format)
+	}
+}
+
+// blockStyle clears the json flow style and quotes, the encoder still quotes the strings which look like other types
+func blockStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, n := range node.Content {
+		blockStyle(n)
+	}
+}
+
+// jsonValue converts the yaml maps with non string keys, e.g. the numeric cases of a switch step
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			v[k] = jsonValue(item)
+		}
+		return v
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			m[fmt.Sprintf("%v", k)] = jsonValue(item)
+		}
+		return m
+	case []interface{}:
+		for i, item := range v {
+			v[i] = jsonValue(item)
+		}
+		return v
+	}
+	return v
+}
+
+// Loader validates the definitions and upserts them into the repositories
+type Loader struct {
+	repos   *driver.Repositories
+	traceId string
+}
+
+func NewLoader(repos *driver.Repositories, traceId string) *Loader {
+	return &Loader{repos: repos, traceId: traceId}
+}
+
+func (l *Loader) log(format string, args ...interface{}) {
+	format = "[DefinitionLoader]-[info]-[%s] " + format
+	args = append([]interface{}{l.traceId}, args...)
+	logrus.Infof(format, args...)
+}
+
+// definedPlugins returns the plugins of the definition before the saved ones,
+// so the plugins and workflows of a definition can refer to the plugins defined along with them
+type definedPlugins struct {
+	driver.PluginRepository
+	plugins map[int]types.Plugin
+}
+
+func (d definedPlugins) GetPluginByPluginKey(ctx context.Context, id int) ([]types.Plugin, error) {
+	if p, ok := d.plugins[id]; ok {
+		return []types.Plugin{p}, nil
+	}
+	return d.PluginRepository.GetPluginByPluginKey(ctx, id)
+}
+
+// Load validates the definition and returns the changes it makes to the plugins and workflows,
+// the changes are saved unless dryRun. Nothing is saved if any plugin or workflow is invalid.
+// A changed plugin is saved as a new version, a changed workflow replaces the one with the same name.
+// The changes are saved one by one, if saving one fails the error is returned with the changes saved before it,
+// they are not rolled back, so the definition is partly saved until it's imported again.
+func (l *Loader) Load(ctx context.Context, def *types.Definition, dryRun bool) ([]types.DefinitionChange, error) {
+	plugins, err := l.validatePlugins(ctx, def)
+	if err != nil {
+		return nil, err
+	}
+
+	flows, err := l.validateWorkFlows(ctx, def, plugins)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []types.DefinitionChange
+	for i := range def.Plugins {
+		p := &def.Plugins[i]
+		change := types.DefinitionChange{Kind: "plugin", Name: p.Name, Key: p.PluginKey, Action: types.ChangeActionCreate}
+
+		saved, err := l.repos.Plugin.GetPluginByPluginKey(ctx, p.PluginKey)
+		if err != nil {
+			return nil, err
+		}
+		if len(saved) > 0 {
+			p.Version = saved[0].Version
+			change.Action, change.Diff = compare(saved[0], *p)
+		}
+		changes = append(changes, change)
+	}
+
+	for i := range flows {
+		flow := &flows[i]
+		change := types.DefinitionChange{Kind: "workflow", Name: flow.Name, User: flow.User, Action: types.ChangeActionCreate}
+
+		saved, err := l.repos.Workflow.FindWorkFlow(ctx, flow.Name, flow.User)
+		if err != nil {
+			return nil, err
+		}
+		if saved != nil {
+			// the id of the saved workflow is kept, a changed workflow is saved as the next version
+			flow.ID, flow.Version = saved.ID, saved.Version
+			change.Action, change.Diff = compare(*saved, *flow)
+		}
+		changes = append(changes, change)
+	}
+
+	if dryRun {
+		return changes, nil
+	}
+
+	for i, change := range changes {
+		if err := l.apply(ctx, change, def.Plugins, flows, i); err != nil {
+			return changes[:i], fmt.Errorf("%s %s %s error: %w", change.Action, change.Kind, change.Name, err)
+		}
+	}
+	return changes, nil
+}
+
+// apply saves the change i, the plugin changes are followed by the workflow changes
+func (l *Loader) apply(ctx context.Context, change types.DefinitionChange, plugins []types.Plugin, flows []types.WorkFlow, i int) error {
+	if change.Action == types.ChangeActionUnchanged {
+		return nil
+	}
+	l.log("%s %s %s", change.Action, change.Kind, change.Name)
+
+	if i < len(plugins) {
+		plugin := plugins[i]
+		if change.Action == types.ChangeActionCreate {
+			return l.repos.Plugin.InsertPlugin(ctx, &plugin)
+		}
+		return l.repos.Plugin.SavePluginVersion(ctx, &plugin)
+	}
+
+	flow := flows[i-len(plugins)]
+	if change.Action == types.ChangeActionCreate {
+		return l.repos.Workflow.SaveWorkFlow(ctx, &flow)
+	}
+	return l.repos.Workflow.UpdateWorkFlow(ctx, &flow)
+}
+
+func (l *Loader) validatePlugins(ctx context.Context, def *types.Definition) (driver.PluginRepository, error) {
+	defined := definedPlugins{PluginRepository: l.repos.Plugin, plugins: make(map[int]types.Plugin)}
+	for _, p := range def.Plugins {
+		if p.PluginKey <= 0 {
+			return nil, fmt.Errorf("plugin %s has no plugin key", p.Name)
+		}
+		if _, ok := defined.plugins[p.PluginKey]; ok {
+			return nil, fmt.Errorf("plugin key %d is defined twice", p.PluginKey)
+		}
+		defined.plugins[p.PluginKey] = p
+	}
+
+	client := fplugin.NewPluginClient(defined)
+	for _, p := range def.Plugins {
+		if err := client.Validate(ctx, p); err != nil {
+			return nil, fmt.Errorf("plugin %d: %w", p.PluginKey, err)
+		}
+	}
+	return defined, nil
+}
+
+func (l *Loader) validateWorkFlows(ctx context.Context, def *types.Definition, plugins driver.PluginRepository) ([]types.WorkFlow, error) {
+	wf := NewWorkFlow(l.repos.Workflow, plugins, l.traceId)
+
+	flows := make([]types.WorkFlow, 0, len(def.Workflows))
+	names := make(map[string]bool)
+	for _, flow := range def.Workflows {
+		if flow.User == "" {
+			flow.User = def.User
+		}
+		if flow.Action == "" {
+			flow.Action = types.WorkFlowExecute
+		}
+
+		if names[flow.User+"/"+flow.Name] {
+			return nil, fmt.Errorf("workflow %s of %s is defined twice", flow.Name, flow.User)
+		}
+		names[flow.User+"/"+flow.Name] = true
+
+		if err := wf.validate(ctx, &flow); err != nil {
+			return nil, err
+		}
+		flows = append(flows, flow)
+	}
+	return flows, nil
+}
+
+// Export returns the definition of the workflows of the user and the latest version of the plugins they refer to,
+// including the up and down references of the plugins
+func (l *Loader) Export(ctx context.Context, user string) (*types.Definition, error) {
+	flows, err := l.repos.Workflow.FindWorkFlows(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	def := &types.Definition{User: user}
+	var next []int
+	for _, f := range flows {
+		// the id, the version and the user are set by the loader
+		flow := *f
+		flow.ID, flow.Version, flow.User = "", 0, ""
+		def.Workflows = append(def.Workflows, flow)
+		next = append(next, f.PluginKeys()...)
+	}
+
+	visited := make(map[int]bool)
+	for len(next) > 0 {
+		key := next[0]
+		next = next[1:]
+		if key <= 0 || visited[key] {
+			continue
+		}
+		visited[key] = true
+
+		plugins, err := l.repos.Plugin.GetPluginByPluginKey(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if len(plugins) == 0 {
+			return nil, fmt.Errorf("plugin %d: %w", key, driver.ErrNotFound)
+		}
+
+		def.Plugins = append(def.Plugins, plugins[0])
+		next = append(next, plugins[0].Reference.Up)
+		next = append(next, plugins[0].Reference.Down...)
+	}
+
+	sort.Slice(def.Plugins, func(i, j int) bool { return def.Plugins[i].PluginKey < def.Plugins[j].PluginKey })
+	return def, nil
+}
+
+// compare returns whether saved is changed to defined and the changed fields
+func compare(saved, defined interface{}) (string, []string) {
+	var diff []string
+	diffValues("", jsonOf(saved), jsonOf(defined), &diff)
+	if len(diff) == 0 {
+		return types.ChangeActionUnchanged, nil
+	}
+	return types.ChangeActionUpdate, diff
+}
+
+func jsonOf(v interface{}) interface{} {
+	data, _ := json.Marshal(v)
+	var out interface{}
+	json.Unmarshal(data, &out)
+	return out
+}
+
+// diffValues appends "path: old -> new" of the different values, the maps and the arrays of the same length are compared by item
+func diffValues(path string, old, new interface{}, diff *[]string) {
+	join := func(key string) string {
+		if path == "" {
+			return key
+		}
+		return path + "." + key
+	}
+
+	om, ok1 := old.(map[string]interface{})
+	nm, ok2 := new.(map[string]interface{})
+	if ok1 && ok2 {
+		keys := make(map[string]bool)
+		for k := range om {
+			keys[k] = true
+		}
+		for k := range nm {
+			keys[k] = true
+		}
+
+		sorted := make([]string, 0, len(keys))
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+
+		for _, k := range sorted {
+			diffValues(join(k), om[k], nm[k], diff)
+		}
+		return
+	}
+
+	oa, ok1 := old.([]interface{})
+	na, ok2 := new.([]interface{})
+	if ok1 && ok2 && len(oa) == len(na) {
+		for i := range oa {
+			diffValues(join(strconv.Itoa(i)), oa[i], na[i], diff)
+		}
+		return
+	}
+
+	if !reflect.DeepEqual(old, new) {
+		o, _ := json.Marshal(old)
+		n, _ := json.Marshal(new)
+		*diff = append(*diff, fmt.Sprintf("%s: %s -> %s", path, o, n))
+	}
+}
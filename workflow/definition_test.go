@@ -0,0 +1,126 @@
+package workflow
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/andy-zhangtao/Functions/driver"
+	"github.com/andy-zhangtao/Functions/types"
+)
+
+const diaryDefinition = `
+user: tester
+plugins:
+  - plugin_key: 1
+    name: weaviate
+    module: ""
+    input:
+      - name: title
+        value: {type: string, description: the title}
+    reference: {up: 2}
+  - plugin_key: 2
+    name: weaviate-function-calling
+    module: gpt
+    input:
+      - name: prompt
+        value: {description: "now is %s, the user is %s"}
+    reference: {up: -1, down: [1]}
+workflows:
+  - name: diary
+    steps:
+      - name: gpt
+        plugin_key: 2
+      - name: store
+        plugin_key: 1
+        input:
+          title: "{{steps.gpt.output.title}}"
+`
+
+func TestLoadDefinition(t *testing.T) {
+	ctx := context.Background()
+	repos := driver.NewMemoryRepositories()
+	loader := NewLoader(repos, "test")
+
+	parse := func(data, format string) *types.Definition {
+		def, err := ParseDefinition([]byte(data), format)
+		if err != nil {
+			t.Fatalf("parse definition error: %v", err)
+		}
+		return def
+	}
+
+	actions := func(changes []types.DefinitionChange) string {
+		var s []string
+		for _, c := range changes {
+			s = append(s, c.Kind+":"+c.Action)
+		}
+		return strings.Join(s, ",")
+	}
+
+	if _, err := ParseDefinition([]byte("user: tester\nflows: []\n"), types.DefinitionYAML); err == nil {
+		t.Errorf("parse unknown field succeeded")
+	}
+
+	changed := strings.Replace(diaryDefinition, "{{steps.gpt.output.title}}", "{{steps.gpt.output.title | upper}}", 1)
+	tests := []struct {
+		name    string
+		data    string
+		dryRun  bool
+		actions string
+		diff    string
+		saved   int
+	}{
+		{name: "dry run", data: diaryDefinition, dryRun: true, actions: "plugin:create,plugin:create,workflow:create"},
+		{name: "load", data: diaryDefinition, actions: "plugin:create,plugin:create,workflow:create", saved: 1},
+		{name: "reload", data: diaryDefinition, actions: "plugin:unchanged,plugin:unchanged,workflow:unchanged", saved: 1},
+		{name: "update", data: changed, actions: "plugin:unchanged,plugin:unchanged,workflow:update", diff: `steps.1.input.title: "{{steps.gpt.output.title}}" -> "{{steps.gpt.output.title | upper}}"`, saved: 1},
+		{name: "unknown plugin", data: strings.Replace(diaryDefinition, "plugin_key: 1\n        input", "plugin_key: 404\n        input", 1), saved: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes, err := loader.Load(ctx, parse(tt.data, types.DefinitionYAML), tt.dryRun)
+			if tt.actions == "" {
+				if err == nil {
+					t.Errorf("load invalid definition succeeded")
+				}
+			} else if err != nil {
+				t.Fatalf("load error: %v", err)
+			}
+
+			if got := actions(changes); got != tt.actions {
+				t.Errorf("changes = %s, want %s", got, tt.actions)
+			}
+			if tt.diff != "" && (len(changes) != 3 || len(changes[2].Diff) != 1 || changes[2].Diff[0] != tt.diff) {
+				t.Errorf("diff = %v, want %s", changes[len(changes)-1].Diff, tt.diff)
+			}
+
+			flows, _ := repos.Workflow.FindWorkFlows(ctx, "tester")
+			if len(flows) != tt.saved {
+				t.Errorf("saved %d workflows, want %d", len(flows), tt.saved)
+			}
+		})
+	}
+
+	for _, format := range []string{types.DefinitionYAML, types.DefinitionJSON} {
+		t.Run("export "+format, func(t *testing.T) {
+			def, err := loader.Export(ctx, "tester")
+			if err != nil {
+				t.Fatalf("export error: %v", err)
+			}
+			data, err := MarshalDefinition(def, format)
+			if err != nil {
+				t.Fatalf("marshal error: %v", err)
+			}
+
+			changes, err := loader.Load(ctx, parse(string(data), format), true)
+			if err != nil {
+				t.Fatalf("load exported definition error: %v", err)
+			}
+			if got := actions(changes); got != "plugin:unchanged,plugin:unchanged,workflow:unchanged" {
+				t.Errorf("changes = %s, want unchanged\n%s", got, data)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/andy-zhangtao/Functions/driver"
+	"github.com/andy-zhangtao/Functions/events"
+	"github.com/andy-zhangtao/Functions/tools/texpr"
+	traceid "github.com/andy-zhangtao/Functions/tools/trace_id"
+	"github.com/andy-zhangtao/Functions/types"
+	"github.com/sirupsen/logrus"
+)
+
+// Listen subscribes the workflow triggers to all the event types of the bus,
+// an event executes the workflows of its user with a trigger of its type
+func Listen(bus *events.Bus, repos *driver.Repositories) {
+	for _, eventType := range types.EventTypes {
+		bus.Subscribe(eventType, func(ctx context.Context, event types.Event) {
+			trigger(ctx, bus, repos, event)
+		})
+	}
+}
+
+func trigger(ctx context.Context, bus *events.Bus, repos *driver.Repositories, event types.Event) {
+	if event.Depth >= types.MaxEventDepth {
+		logrus.Infof("[WorkFlowTrigger]-[info]-[%s] %s is %d levels deep, trigger nothing", event.ID, event.Type, event.Depth)
+		return
+	}
+
+	flows, err := repos.Workflow.FindTriggered(ctx, event.Type, event.User)
+	if err != nil {
+		logrus.Errorf("[WorkFlowTrigger]-[error]-[%s] find workflows triggered by %s error: %v", event.ID, event.Type, err)
+		return
+	}
+
+	for _, flow := range flows {
+		for _, t := range flow.Triggers {
+			if t.Event != event.Type {
+				continue
+			}
+
+			traceId := traceid.ID()
+			logrus.Infof("[WorkFlowTrigger]-[info]-[%s] %s triggers workflow %s with %s", event.ID, event.Type, flow.ID, traceId)
+
+			service := NewWorkFlowService(repos, traceId)
+			service.Events = bus
+
+			question, err := triggerQuestion(service, t, event)
+			if err != nil {
+				logrus.Errorf("[WorkFlowTrigger]-[error]-[%s] workflow %s: %v", event.ID, flow.ID, err)
+				continue
+			}
+
+			e := event
+			if _, err := service.ExecuteWorkFlow(ctx, flow.ID, types.WorkFlowRequest{
+				Action:   types.WorkFlowActionExecute,
+				User:     event.User,
+				Name:     flow.Name,
+				Question: question,
+				Event:    &e,
+			}); err != nil {
+				logrus.Errorf("[WorkFlowTrigger]-[error]-[%s] execute workflow %s error: %v", event.ID, flow.ID, err)
+			}
+		}
+	}
+}
+
+// triggerQuestion evaluates the question expression of the trigger with the event
+func triggerQuestion(service *WorkFlowService, t types.WorkFlowTrigger, event types.Event) (string, error) {
+	if t.Question == "" {
+		return "", nil
+	}
+
+	v, err := texpr.EvalString(t.Question, service.scope(types.WorkFlowRequest{User: event.User, Event: &event}))
+	if err != nil {
+		return "", fmt.Errorf("evaluate question of trigger %s error: %w", t.Event, err)
+	}
+	return fmt.Sprintf("%v", v), nil
+}
+
+func knownEvent(eventType string) bool {
+	for _, e := range types.EventTypes {
+		if e == eventType {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+package workflow
+
+import (
+	"context"
+	"testing"
+
+	"github.com/andy-zhangtao/Functions/driver"
+	"github.com/andy-zhangtao/Functions/events"
+	"github.com/andy-zhangtao/Functions/types"
+)
+
+func TestListen(t *testing.T) {
+	repos := driver.NewMemoryRepositories()
+	memory := repos.Workflow.(*driver.MemoryStore)
+	memory.SavePlugin(types.Plugin{PluginKey: 1, Name: "weaviate"})
+
+	store := func(title string) []types.WorkFlowStep {
+		return []types.WorkFlowStep{{Name: "store", PluginKey: 1, Input: map[string]interface{}{
+			"action": "1", "title": title, "body": "{{request.question}}", "tags": "auto", "user": "{{request.user}}", "date": "2023-08-01",
+		}}}
+	}
+	memory.SaveWorkFlow(context.Background(), &types.WorkFlow{ID: "tag", Name: "tag", User: "tester", Action: types.WorkFlowExecute,
+		Steps:    store("{{event.payload.title | upper}}"),
+		Triggers: []types.WorkFlowTrigger{{Event: types.EventDiaryCreated, Question: "{{event.payload.body}}"}},
+	})
+	// chain triggers itself, it stops at MaxEventDepth
+	memory.SaveWorkFlow(context.Background(), &types.WorkFlow{ID: "chain", Name: "chain", User: "tester", Action: types.WorkFlowExecute,
+		Steps:    store("{{event.payload.name}}"),
+		Triggers: []types.WorkFlowTrigger{{Event: types.EventWorkflowCompleted}},
+	})
+
+	bus := events.NewBus()
+	Listen(bus, repos)
+
+	bus.Publish(types.Event{Type: types.EventDiaryCreated, User: "tester", Payload: map[string]interface{}{"title": "father", "body": "finish the design"}})
+	bus.Publish(types.Event{Type: types.EventDiaryCreated, User: "other", Payload: map[string]interface{}{"title": "other"}})
+	if err := bus.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait error: %v", err)
+	}
+
+	res, err := repos.Diary.QueryDiary(context.Background(), types.DirayQueryModel{User: "tester"})
+	if err != nil {
+		t.Fatalf("QueryDiary error: %v", err)
+	}
+
+	titles := make(map[string]int)
+	for _, d := range res.Results {
+		titles[d.Title]++
+		if d.Title == "FATHER" && d.Content != "finish the design" {
+			t.Errorf("diary = %+v, want the event body as question", d)
+		}
+	}
+	if len(res.Results) != 3 || titles["FATHER"] != 1 || titles["tag"] != 1 || titles["chain"] != 1 {
+		t.Errorf("diaries = %v, want FATHER, then tag and chain by workflow.completed", titles)
+	}
+
+	if other, _ := repos.Diary.QueryDiary(context.Background(), types.DirayQueryModel{User: "other"}); len(other.Results) != 0 {
+		t.Errorf("the event of other triggers %+v", other.Results)
+	}
+}
@@ -0,0 +1,115 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/andy-zhangtao/Functions/tools/tgpt"
+	"github.com/andy-zhangtao/Functions/types"
+)
+
+var errorClasses = map[string]bool{
+	types.ErrorClassTimeout:   true,
+	types.ErrorClassRateLimit: true,
+	types.ErrorClassServer:    true,
+	types.ErrorClassNetwork:   true,
+	types.ErrorClassPlugin:    true,
+}
+
+// validateRetry checks the retry policy of a step, nil means the step is not retried
+func validateRetry(policy *types.RetryPolicy) error {
+	if policy == nil {
+		return nil
+	}
+
+	if policy.Attempts < 1 {
+		return errors.New("retry needs at least 1 attempt")
+	}
+	if policy.Backoff < 0 || policy.MaxBackoff < 0 {
+		return errors.New("retry has negative backoff")
+	}
+	for _, class := range policy.On {
+		if !errorClasses[class] {
+			return fmt.Errorf("retry has unknown error class %s", class)
+		}
+	}
+	return nil
+}
+
+// classify returns the ErrorClass of the step error
+func classify(err error) string {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return types.ErrorClassTimeout
+	}
+
+	var status *tgpt.StatusError
+	if errors.As(err, &status) {
+		switch {
+		case status.StatusCode == http.StatusTooManyRequests:
+			return types.ErrorClassRateLimit
+		case status.StatusCode >= http.StatusInternalServerError:
+			return types.ErrorClassServer
+		}
+		return types.ErrorClassPlugin
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			return types.ErrorClassTimeout
+		}
+		return types.ErrorClassNetwork
+	}
+
+	return types.ErrorClassPlugin
+}
+
+// retryable reports whether the error class is retried by the policy
+func retryable(policy *types.RetryPolicy, class string) bool {
+	if len(policy.On) == 0 {
+		return true
+	}
+	for _, c := range policy.On {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the wait before the retry after the attempt, the first attempt is 1
+func backoff(policy *types.RetryPolicy, attempt int) time.Duration {
+	wait := time.Duration(policy.Backoff) * time.Millisecond
+	max := time.Duration(policy.MaxBackoff) * time.Millisecond
+	for i := 1; i < attempt; i++ {
+		wait *= 2
+		if max > 0 && wait >= max {
+			break
+		}
+	}
+	if max > 0 && wait > max {
+		wait = max
+	}
+	return wait
+}
+
+// sleep waits d unless ctx is done first
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -5,42 +5,50 @@ package workflow
 // ExecuteWorkFlow 函数，用于执行工作流。这个函数会根据工作流ID读取工作流，检查动作是否为 "execute"，读取并执行步骤，并最终返回结果。
 
 import (
+	"context"
+	"fmt"
 	"os"
+	"reflect"
+	"time"
 
+	"github.com/andy-zhangtao/Functions/driver"
+	"github.com/andy-zhangtao/Functions/events"
 	"github.com/andy-zhangtao/Functions/plugins"
+	"github.com/andy-zhangtao/Functions/tools/texpr"
+	"github.com/andy-zhangtao/Functions/tools/tplugins"
 	"github.com/andy-zhangtao/Functions/types"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
-	"github.com/weaviate/weaviate-go-client/v4/weaviate"
-	"github.com/weaviate/weaviate-go-client/v4/weaviate/auth"
 )
 
 // WorkFlowService is the main service for handling workflows
 type WorkFlowService struct {
-	Store     *MongoStore
-	traceId   string
-	pluginMap map[string]plugins.Plugin
+	Repos *driver.Repositories
+	// Events receives workflow.completed of the completed executions, it's the shared bus by default
+	Events  *events.Bus
+	traceId string
+	// factories create the plugin instances by the plugin name, every step execution gets a new instance
+	factories map[string]plugins.Factory
 	ctx       *types.WorkflowContext
 }
 
 // NewWorkFlowService initializes a new WorkFlowService
-func NewWorkFlowService(store *MongoStore, traceId string) *WorkFlowService {
+func NewWorkFlowService(repos *driver.Repositories, traceId string) *WorkFlowService {
 
-	wfs := &WorkFlowService{Store: store, traceId: traceId}
+	wfs := &WorkFlowService{Repos: repos, Events: events.Shared(), traceId: traceId}
 	wfs.initContext()
 
-	wfs.pluginMap = map[string]plugins.Plugin{
-		"weaviate-function-calling": plugins.NewGPTPlugin(plugins.GPTConfig{
-			Url:  "https://api.openai.com/v1/chat/completions",
-			SKey: os.Getenv(types.PluginGPTSKey),
-		}, wfs.ctx),
-		"weaviate": plugins.NewWeaviatePlugin(plugins.WeaviateConfig{
-			C: weaviate.Config{
-				Host:       os.Getenv(types.EnvWeaviateHost),
-				Scheme:     os.Getenv(types.EnvWeaviateSchema),
-				AuthConfig: auth.ApiKey{Value: os.Getenv(types.EnvWewaviateKey)},
-			},
-		}, wfs.ctx),
+	gpt := plugins.GPTConfig{
+		Url:  types.GPTURL(),
+		SKey: os.Getenv(types.PluginGPTSKey),
+	}
+	wfs.factories = map[string]plugins.Factory{
+		"weaviate-function-calling": func() plugins.Plugin {
+			return plugins.NewGPTPlugin(gpt)
+		},
+		"weaviate": func() plugins.Plugin {
+			return plugins.NewWeaviatePlugin(plugins.WeaviateConfig{Repo: repos.Diary})
+		},
 	}
 	return wfs
 }
@@ -49,8 +57,9 @@ func (service *WorkFlowService) initContext() {
 	service.log("initContext")
 	service.ctx = types.NewWorkFlowContext()
 
-	service.ctx.Set(types.TraceID, service.traceId)
-	service.ctx.Set(types.GetPluginWithID, service.Store.GetPluginByPluginKey)
+	service.ctx.Set(types.ScopeRequest, types.TraceID, service.traceId)
+	service.ctx.SetFunc(types.GetPluginWithID, service.Repos.Plugin.GetPluginByPluginKey)
+	service.ctx.SetFunc(types.GetFormatWithTags, service.Repos.Format.GetFormat)
 	service.log("initContext done")
 }
 
@@ -66,74 +75,559 @@ func (service *WorkFlowService) error(format string, args ...interface{}) {
 	logrus.Errorf(format, args...)
 }
 
-// ExecuteWorkFlow executes a workflow based on its ID
-func (service *WorkFlowService) ExecuteWorkFlow(workflowID string, query types.WorkFlowRequest) (*types.Result, error) {
+// ExecuteWorkFlow executes a workflow based on its ID, the current version unless query.Version is set.
+// The execution stops when ctx is done or the timeout of the workflow expires.
+func (service *WorkFlowService) ExecuteWorkFlow(ctx context.Context, workflowID string, query types.WorkFlowRequest) (*types.Result, error) {
 	// Read workflow by ID
-	service.log("Executing workflow: %s", workflowID)
-	workflow, err := service.Store.GetWorkFlowByID(workflowID)
+	service.log("Executing workflow: %s version %d", workflowID, query.Version)
+	var workflow *types.WorkFlow
+	var err error
+	if query.Version > 0 {
+		workflow, err = service.Repos.Workflow.GetWorkFlowVersion(ctx, workflowID, query.Version)
+	} else {
+		workflow, err = service.Repos.Workflow.GetWorkFlowByID(ctx, workflowID)
+	}
 	if err != nil {
 		service.error("Error getting workflow: %v", err)
 		return nil, errors.WithMessage(err, "error getting workflow")
 	}
 
 	// Check if the action is "execute"
-	if workflow.Action != "execute" {
+	if workflow.Action != types.WorkFlowExecute {
 		return nil, errors.New("Invalid action")
 	}
 
 	service.log("Executing workflow: %+v", workflow)
 
-	service.ctx.Set(types.CtxOriginQuery, types.WorkFlowBaseInfo{
+	service.ctx.Set(types.ScopeRequest, types.CtxOriginQuery, types.WorkFlowBaseInfo{
 		User: query.User,
 	})
-	// Execute steps and collect results
-	stepResults := make(map[string]interface{})
-	// Read steps by IDs
-	for _, step := range workflow.StepIDs {
-		plugins, err := service.Store.GetPluginByPluginKey(step)
-		if err != nil {
-			service.error("Error getting plugins: %v", err)
-			return nil, errors.WithMessage(err, "error getting steps")
-		}
+	if query.Event != nil {
+		service.ctx.Set(types.ScopeRequest, types.CtxEvent, *query.Event)
+	}
+	service.ctx.Set(types.ScopeRequest, types.CtxDryRun, query.DryRun)
 
-		for _, plugin := range plugins {
-			// Here you can add the actual execution logic for each step
-			// For demonstration, we just log the step
-			service.log("Executing plugin: %s(%s)", plugin.Name, plugin.Descript)
+	exec := &execution{
+		scope:   service.scope(query),
+		query:   query,
+		results: make(map[string]interface{}),
+	}
 
-			if p, exist := service.pluginMap[plugin.Name]; exist {
-				err := p.Initialize(plugin)
-				if err != nil {
-					service.error("plugin: %v initialize error: %v", plugin.Name, err)
-					return nil, errors.WithMessage(err, "error getting plugin")
-				}
+	return service.run(ctx, workflow, exec, 0)
+}
 
-				err = p.Execute(service.ctx, query.Question)
-				if err != nil {
-					service.error("plugin: %v execute error: %v", plugin.Name, err)
-					return nil, errors.WithMessage(err, "error getting plugin")
-				}
+// run runs the top level steps from start, the execution is paused at an approval step.
+// The failed execution returns the failed result with the error.
+func (service *WorkFlowService) run(ctx context.Context, workflow *types.WorkFlow, exec *execution, start int) (*types.Result, error) {
+	if workflow.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(workflow.Timeout)*time.Second)
+		defer cancel()
+	}
 
-				_, err = p.Finalize()
+	for i := start; i < len(workflow.Steps); i++ {
+		step := workflow.Steps[i]
+		if err := ctx.Err(); err != nil {
+			return service.fail(workflow, exec, step, errors.WithMessagef(err, "workflow stopped before step %s", step.Name))
+		}
+
+		if step.Kind == types.StepKindApproval {
+			if !exec.query.DryRun {
+				result, err := service.pause(ctx, workflow, exec, i)
 				if err != nil {
-					service.error("plugin: %v finalize error: %v", plugin.Name, err)
-					return nil, errors.WithMessage(err, "error getting plugin")
+					return service.fail(workflow, exec, step, err)
 				}
-
-			} else {
-				service.error("plugin: %v not exist", plugin.Name)
-				return nil, errors.WithMessage(err, "error getting plugin")
+				return result, nil
 			}
-			stepResults[plugin.Name] = "Success" // Replace with actual result
+			// the dry run goes on as if it's approved
+			if err := service.approveDryRun(exec, step); err != nil {
+				return service.fail(workflow, exec, step, err)
+			}
+			continue
+		}
+
+		if err := service.runStep(ctx, exec, step); err != nil {
+			return service.fail(workflow, exec, step, err)
 		}
 	}
 
 	// Create and return the result
-	result := &types.Result{
+	result := exec.result(workflow, types.RunStatusCompleted)
+
+	service.completed(workflow, exec)
+	return result, nil
+}
+
+// completed publishes workflow.completed, it's one level deeper than the event which triggered the execution.
+// The dry run triggers no workflow.
+func (service *WorkFlowService) completed(workflow *types.WorkFlow, exec *execution) {
+	if service.Events == nil || exec.query.DryRun {
+		return
+	}
+
+	depth := 0
+	if exec.query.Event != nil {
+		depth = exec.query.Event.Depth + 1
+	}
+
+	service.Events.Publish(types.Event{
+		Type:  types.EventWorkflowCompleted,
+		User:  exec.query.User,
+		Depth: depth,
+		Payload: map[string]interface{}{
+			"workflow_id":  workflow.ID,
+			"name":         workflow.Name,
+			"status":       types.RunStatusCompleted,
+			"step_results": exec.results,
+		},
+	})
+}
+
+// fail runs the OnFailure steps of the workflow with {{error}} and returns the failed result with err.
+// The OnFailure steps run after the execution is cancelled too, they are bounded by OnFailureTimeout.
+func (service *WorkFlowService) fail(workflow *types.WorkFlow, exec *execution, step types.WorkFlowStep, err error) (*types.Result, error) {
+	service.error("workflow %s failed at step %s: %v", workflow.ID, stepName(step), err)
+
+	if len(workflow.OnFailure) > 0 {
+		exec.scope["error"] = map[string]interface{}{
+			"step":    stepName(step),
+			"message": err.Error(),
+			"class":   classify(err),
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), types.OnFailureTimeout*time.Second)
+		defer cancel()
+		if ferr := service.runSteps(ctx, exec, workflow.OnFailure); ferr != nil {
+			service.error("on_failure of workflow %s error: %v", workflow.ID, ferr)
+		}
+	}
+
+	result := exec.result(workflow, types.RunStatusFailed)
+	result.Error = err.Error()
+	return result, err
+}
+
+// execution is the state of a workflow execution
+type execution struct {
+	scope map[string]interface{}
+	query types.WorkFlowRequest
+	// previous is the output of the last plugin step
+	previous interface{}
+	results  map[string]interface{}
+	// suffix is appended to the step result names in the foreach iterations
+	suffix string
+	runs   int
+	// attempts records the runs of the plugin steps and the steps with a retry policy
+	attempts []types.StepAttempt
+	// intents are the side effects skipped by the dry run, trace is the context after every plugin step of the step mode
+	intents []types.Intent
+	trace   []types.StepSnapshot
+}
+
+// result returns the result of the execution with the status
+func (exec *execution) result(workflow *types.WorkFlow, status string) *types.Result {
+	return &types.Result{
 		WorkFlowID:  workflow.ID,
-		Status:      "Completed",
-		StepResults: stepResults,
+		Version:     workflow.Version,
+		Status:      status,
+		StepResults: exec.results,
+		Attempts:    exec.attempts,
+		DryRun:      exec.query.DryRun,
+		Intents:     exec.intents,
+		Trace:       exec.trace,
 	}
+}
 
-	return result, nil
+// runSteps runs the steps in order, the condition, switch and foreach steps run their nested steps.
+// No step starts after ctx is done.
+func (service *WorkFlowService) runSteps(ctx context.Context, exec *execution, steps []types.WorkFlowStep) error {
+	for _, step := range steps {
+		if err := ctx.Err(); err != nil {
+			return errors.WithMessagef(err, "workflow stopped before step %s", step.Name)
+		}
+
+		if err := service.runStep(ctx, exec, step); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runStep runs the step with its retry policy, the fallback steps run if the step still fails.
+// The step which continues on error records the error as its result instead of failing the execution.
+func (service *WorkFlowService) runStep(ctx context.Context, exec *execution, step types.WorkFlowStep) error {
+	err := service.retryStep(ctx, exec, step)
+	if err == nil {
+		return nil
+	}
+
+	name := service.resultName(ctx, step, nil)
+	if len(step.Fallback) > 0 && ctx.Err() == nil {
+		service.log("step %s failed: %v, run its fallback", name, err)
+		ferr := service.runSteps(ctx, exec, step.Fallback)
+		if ferr == nil {
+			return nil
+		}
+		err = errors.WithMessagef(ferr, "fallback of step %s error", name)
+	}
+
+	if step.ContinueOnError && ctx.Err() == nil {
+		service.error("step %s failed, continue on error: %v", name, err)
+		failure := map[string]interface{}{
+			"error": err.Error(),
+			"class": classify(err),
+		}
+		exec.scope["steps"].(map[string]interface{})[name] = failure
+		exec.results[name+exec.suffix] = failure
+		return nil
+	}
+
+	return err
+}
+
+// retryStep runs the step until it succeeds or the retry policy gives up, every attempt has the step timeout
+func (service *WorkFlowService) retryStep(ctx context.Context, exec *execution, step types.WorkFlowStep) error {
+	policy := step.Retry
+	if policy == nil {
+		policy = &types.RetryPolicy{Attempts: 1}
+	}
+	record := step.Retry != nil || step.Kind == "" || step.Kind == types.StepKindPlugin
+
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		err := service.attemptStep(ctx, exec, step)
+
+		class := ""
+		if err != nil {
+			class = classify(err)
+		}
+		if record {
+			a := types.StepAttempt{
+				Step:     stepName(step) + exec.suffix,
+				Attempt:  attempt,
+				Class:    class,
+				Duration: time.Since(start).Milliseconds(),
+			}
+			if err != nil {
+				a.Error = err.Error()
+			}
+			exec.attempts = append(exec.attempts, a)
+		}
+
+		if err == nil {
+			return nil
+		}
+		if attempt >= policy.Attempts || !retryable(policy, class) || ctx.Err() != nil {
+			return err
+		}
+
+		wait := backoff(policy, attempt)
+		service.log("step %s attempt %d failed with %s error: %v, retry in %s", stepName(step), attempt, class, err, wait)
+		if err := sleep(ctx, wait); err != nil {
+			return errors.WithMessagef(err, "retry of step %s stopped", stepName(step))
+		}
+	}
+}
+
+// attemptStep runs the step once with its timeout
+func (service *WorkFlowService) attemptStep(ctx context.Context, exec *execution, step types.WorkFlowStep) error {
+	if step.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(step.Timeout)*time.Second)
+		defer cancel()
+	}
+
+	switch step.Kind {
+	case "", types.StepKindPlugin:
+		return service.runPlugin(ctx, exec, step)
+	case types.StepKindCondition:
+		return service.runCondition(ctx, exec, step)
+	case types.StepKindSwitch:
+		return service.runSwitch(ctx, exec, step)
+	case types.StepKindForeach:
+		return service.runForeach(ctx, exec, step)
+	case types.StepKindApproval:
+		return errors.Errorf("approval step %s must be a top level step", step.Name)
+	default:
+		return errors.Errorf("step %s has unknown kind %s", step.Name, step.Kind)
+	}
+}
+
+func (service *WorkFlowService) runCondition(ctx context.Context, exec *execution, step types.WorkFlowStep) error {
+	v, err := texpr.EvalString(step.If, exec.scope)
+	if err != nil {
+		return errors.WithMessagef(err, "evaluate condition of step %s error", step.Name)
+	}
+
+	service.log("condition step %s [%s] is %v", step.Name, step.If, texpr.Truthy(v))
+	if texpr.Truthy(v) {
+		return service.runSteps(ctx, exec, step.Then)
+	}
+	return service.runSteps(ctx, exec, step.Else)
+}
+
+func (service *WorkFlowService) runSwitch(ctx context.Context, exec *execution, step types.WorkFlowStep) error {
+	v, err := texpr.EvalString(step.Switch, exec.scope)
+	if err != nil {
+		return errors.WithMessagef(err, "evaluate switch of step %s error", step.Name)
+	}
+
+	value := fmt.Sprintf("%v", v)
+	service.log("switch step %s [%s] is %s", step.Name, step.Switch, value)
+	if steps, ok := step.Cases[value]; ok {
+		return service.runSteps(ctx, exec, steps)
+	}
+	return service.runSteps(ctx, exec, step.Default)
+}
+
+func (service *WorkFlowService) runForeach(ctx context.Context, exec *execution, step types.WorkFlowStep) error {
+	v, err := texpr.EvalString(step.Foreach, exec.scope)
+	if err != nil {
+		return errors.WithMessagef(err, "evaluate foreach of step %s error", step.Name)
+	}
+
+	items := reflect.ValueOf(v)
+	if items.Kind() != reflect.Slice && items.Kind() != reflect.Array {
+		return errors.Errorf("foreach of step %s is not an array but %T", step.Name, v)
+	}
+
+	max := step.MaxIterations
+	if max <= 0 {
+		max = types.DefaultMaxIterations
+	}
+	if items.Len() > max {
+		return errors.Errorf("foreach of step %s has %d items, the max iterations is %d", step.Name, items.Len(), max)
+	}
+
+	as := step.As
+	if as == "" {
+		as = "item"
+	}
+
+	suffix := exec.suffix
+	defer func() {
+		exec.suffix = suffix
+		delete(exec.scope, as)
+		delete(exec.scope, "index")
+	}()
+
+	for i := 0; i < items.Len(); i++ {
+		exec.scope[as] = items.Index(i).Interface()
+		exec.scope["index"] = i
+		exec.suffix = fmt.Sprintf("%s[%d]", suffix, i)
+
+		if err := service.runSteps(ctx, exec, step.Do); err != nil {
+			return errors.WithMessagef(err, "foreach step %s item %d error", step.Name, i)
+		}
+	}
+	return nil
+}
+
+func (service *WorkFlowService) runPlugin(ctx context.Context, exec *execution, step types.WorkFlowStep) error {
+	if exec.runs++; exec.runs > types.MaxStepRuns {
+		return errors.Errorf("workflow runs more than %d steps", types.MaxStepRuns)
+	}
+
+	plugins, err := service.stepPlugins(ctx, step)
+	if err != nil {
+		service.error("Error getting plugins: %v", err)
+		return errors.WithMessagef(err, "get plugin %d of step %s error", step.PluginKey, step.Name)
+	}
+
+	if len(plugins) == 0 {
+		return errors.Errorf("plugin %d of step %s not found", step.PluginKey, step.Name)
+	}
+
+	for _, plugin := range plugins {
+		name := service.resultName(ctx, step, &plugin)
+
+		service.log("Executing step %s with plugin: %s(%s)", name, plugin.Name, plugin.Descript)
+		if err := service.mapInput(name, step, plugin, exec.previous, exec.scope); err != nil {
+			return err
+		}
+
+		factory, exist := service.factories[plugin.Name]
+		if !exist {
+			service.error("plugin: %v not exist", plugin.Name)
+			return errors.Errorf("plugin %s not exist", plugin.Name)
+		}
+
+		// the output and the intent of the last run must not be taken as the ones of this run
+		service.ctx.Delete(types.ScopePlugin, tplugins.PluginOutputInChain(plugin.Name))
+		service.ctx.Delete(types.ScopePlugin, tplugins.PluginIntentInChain(plugin.Name))
+
+		p := factory()
+		err := p.Initialize(service.ctx, plugin)
+		if err != nil {
+			service.error("plugin: %v initialize error: %v", plugin.Name, err)
+			return errors.WithMessagef(err, "initialize plugin %s of step %s error", plugin.Name, name)
+		}
+
+		err = p.Execute(ctx, service.ctx, exec.query.Question)
+		if err != nil {
+			service.error("plugin: %v execute error: %v", plugin.Name, err)
+			return errors.WithMessagef(err, "execute plugin %s of step %s error", plugin.Name, name)
+		}
+
+		err = p.Finalize(service.ctx)
+		if err != nil {
+			service.error("plugin: %v finalize error: %v", plugin.Name, err)
+			return errors.WithMessagef(err, "finalize plugin %s of step %s error", plugin.Name, name)
+		}
+
+		output, _ := service.ctx.Get(types.ScopePlugin, tplugins.PluginOutputInChain(plugin.Name))
+		if err := service.bindOutput(exec, name, step, output); err != nil {
+			return err
+		}
+
+		exec.scope["steps"].(map[string]interface{})[name] = map[string]interface{}{"output": output}
+		exec.previous = output
+
+		if intent, ok := service.ctx.Get(types.ScopePlugin, tplugins.PluginIntentInChain(plugin.Name)); ok && exec.query.DryRun {
+			if intent, ok := intent.(types.Intent); ok {
+				intent.Step, intent.Plugin = name+exec.suffix, plugin.Name
+				exec.intents = append(exec.intents, intent)
+			}
+		}
+
+		if exec.query.Step {
+			exec.trace = append(exec.trace, types.StepSnapshot{Step: name + exec.suffix, Output: output, Context: service.ctx.Snapshot()})
+		}
+
+		if output == nil {
+			output = "Success"
+		}
+		exec.results[name+exec.suffix] = output
+	}
+	return nil
+}
+
+// resultName is the key of the step in the steps scope and the step results, whether the step succeeded or not.
+// It's the plugin name for the plugin step without name, the plugin is looked up if it's nil.
+func (service *WorkFlowService) resultName(ctx context.Context, step types.WorkFlowStep, plugin *types.Plugin) string {
+	if step.Name != "" || (step.Kind != "" && step.Kind != types.StepKindPlugin) {
+		return stepName(step)
+	}
+
+	if plugin == nil {
+		plugins, err := service.stepPlugins(ctx, step)
+		if err != nil || len(plugins) == 0 {
+			// the step failed because its plugin can't be found
+			return stepName(step)
+		}
+		plugin = &plugins[0]
+	}
+	return plugin.Name
+}
+
+// stepPlugins returns the plugin version pinned by the step or the latest version, it's empty if not found
+func (service *WorkFlowService) stepPlugins(ctx context.Context, step types.WorkFlowStep) ([]types.Plugin, error) {
+	if step.PluginVersion == 0 {
+		return service.Repos.Plugin.GetPluginByPluginKey(ctx, step.PluginKey)
+	}
+
+	plugin, err := service.Repos.Plugin.GetPluginVersion(ctx, step.PluginKey, step.PluginVersion)
+	if errors.Is(err, driver.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []types.Plugin{*plugin}, nil
+}
+
+// scope returns the data the step input expressions are evaluated with
+func (service *WorkFlowService) scope(query types.WorkFlowRequest) map[string]interface{} {
+	scope := map[string]interface{}{
+		"request": map[string]interface{}{
+			"user":     query.User,
+			"name":     query.Name,
+			"question": query.Question,
+		},
+		"steps": make(map[string]interface{}),
+		"ctx":   copyOf(service.ctx.Snapshot()[types.ScopeStep]),
+		"now":   time.Now(),
+	}
+
+	if e := query.Event; e != nil {
+		scope["event"] = map[string]interface{}{
+			"id":      e.ID,
+			"type":    e.Type,
+			"user":    e.User,
+			"time":    e.Time,
+			"payload": e.Payload,
+		}
+	}
+	return scope
+}
+
+// mapInput sets the plugin input of the context.
+// The input is the output of the previous step, overridden by the evaluated step input expressions.
+func (service *WorkFlowService) mapInput(name string, step types.WorkFlowStep, plugin types.Plugin, previous interface{}, scope map[string]interface{}) error {
+	input := make(map[string]interface{})
+	if upstream, ok := previous.(map[string]interface{}); ok {
+		for k, v := range upstream {
+			input[k] = v
+		}
+	}
+
+	mapped, err := texpr.Eval(step.Input, scope)
+	if err != nil {
+		return errors.WithMessagef(err, "evaluate input of step %s error", name)
+	}
+
+	for k, v := range mapped.(map[string]interface{}) {
+		input[k] = v
+	}
+
+	service.ctx.Set(types.ScopePlugin, tplugins.PluginNameInChain(plugin.Name), input)
+	return nil
+}
+
+// bindOutput sets the fields of the step output to the context keys of step.Output,
+// the keys are readable by the following steps as {{ctx.key}}
+func (service *WorkFlowService) bindOutput(exec *execution, name string, step types.WorkFlowStep, output interface{}) error {
+	bind := func(key string, value interface{}) {
+		service.ctx.Set(types.ScopeStep, key, value)
+		exec.scope["ctx"].(map[string]interface{})[key] = value
+	}
+
+	for field, key := range step.Output {
+		if field == types.StepOutputAll {
+			bind(key, output)
+			continue
+		}
+
+		fields, ok := output.(map[string]interface{})
+		if !ok {
+			return errors.Errorf("step %s has no output field %s", name, field)
+		}
+
+		value, ok := fields[field]
+		if !ok {
+			return errors.Errorf("step %s has no output field %s", name, field)
+		}
+		bind(key, value)
+	}
+	return nil
+}
+
+// stepName returns the name of the step, the unnamed plugin step is named by its plugin key
+func stepName(step types.WorkFlowStep) string {
+	switch {
+	case step.Name != "":
+		return step.Name
+	case step.Kind == "" || step.Kind == types.StepKindPlugin:
+		return fmt.Sprintf("plugin-%d", step.PluginKey)
+	}
+	return step.Kind
+}
+
+// copyOf returns a copy of the values which is never nil
+func copyOf(values map[string]interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		c[k] = v
+	}
+	return c
 }
@@ -0,0 +1,568 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/andy-zhangtao/Functions/driver"
+	"github.com/andy-zhangtao/Functions/tools/tmockgpt"
+	"github.com/andy-zhangtao/Functions/types"
+)
+
+func seedDiaryWorkflow(store *driver.MemoryStore) {
+	store.SaveWorkFlow(context.Background(), &types.WorkFlow{ID: "1", Name: "diary", Action: types.WorkFlowExecute, Steps: []types.WorkFlowStep{
+		{Name: "gpt", PluginKey: 2, Output: map[string]string{"title": "diary_title"}},
+		{Name: "store", PluginKey: 1, Input: map[string]interface{}{
+			"user":  "{{request.user}}",
+			"title": "{{steps.gpt.output.title | upper}}",
+		}},
+	}})
+	store.SavePlugin(types.Plugin{
+		PluginKey: 2,
+		Name:      "weaviate-function-calling",
+		Module:    "gpt",
+		Input: []types.PluginIO{
+			{Name: "prompt", Value: types.PluginType{Description: "now is %s, the user is %s"}},
+			{Name: "max_tokens", Value: types.PluginType{Description: "1000"}},
+			{Name: "temperature", Value: types.PluginType{Description: "0.7"}},
+			{Name: "model", Value: types.PluginType{Description: "gpt-3.5-turbo-0613"}},
+		},
+		Reference: types.PluginReference{Up: -1, Down: []int{1}},
+	})
+	store.SavePlugin(types.Plugin{
+		PluginKey: 1,
+		Name:      "weaviate",
+		Input: []types.PluginIO{
+			{Name: "action", Value: types.PluginType{Type: "string", Description: "1"}},
+			{Name: "title", Value: types.PluginType{Type: "string", Description: "the title"}},
+			{Name: "body", Value: types.PluginType{Type: "string", Description: "the body"}},
+			{Name: "tags", Value: types.PluginType{Type: "string", Description: "the tags"}},
+			{Name: "user", Value: types.PluginType{Type: "string", Description: "the user"}},
+			{Name: "date", Value: types.PluginType{Type: "string", Description: "YYYY-MM-DD"}},
+		},
+		Reference: types.PluginReference{Up: 2},
+	})
+}
+
+func TestExecuteWorkFlow(t *testing.T) {
+	gpt := tmockgpt.NewServer(tmockgpt.On(tmockgpt.FunctionIs("weaviate"),
+		tmockgpt.FunctionCall("weaviate", `{"action":"1","title":"Father","body":"完成了Father的初步设计","tags":"father,design","user":"someone","date":"2023-08-01"}`)))
+	defer gpt.Close()
+	t.Setenv(types.PluginGPTURL, gpt.URL)
+
+	repos := driver.NewMemoryRepositories()
+	seedDiaryWorkflow(repos.Workflow.(*driver.MemoryStore))
+
+	service := NewWorkFlowService(repos, "test")
+	result, err := service.ExecuteWorkFlow(context.Background(), "1", types.WorkFlowRequest{
+		Action:   types.WorkFlowActionExecute,
+		User:     "tester",
+		Question: "请记录今天的工作内容: 我完成了Father的初步设计",
+	})
+	if err != nil {
+		t.Fatalf("ExecuteWorkFlow error: %v", err)
+	}
+
+	if result.Status != "Completed" || len(result.StepResults) != 2 {
+		t.Errorf("result = %+v, want 2 completed steps", result)
+	}
+
+	if _, ok := result.StepResults["store"].(map[string]interface{})["id"]; !ok {
+		t.Errorf("store step output = %+v, want the diary id", result.StepResults["store"])
+	}
+
+	if title, _ := service.ctx.Get(types.ScopeStep, "diary_title"); title != "Father" {
+		t.Errorf("bound title = %v, want Father", title)
+	}
+
+	res, err := repos.Diary.QueryDiary(context.Background(), types.DirayQueryModel{User: "tester"})
+	if err != nil {
+		t.Fatalf("QueryDiary error: %v", err)
+	}
+
+	if len(res.Results) != 1 {
+		t.Fatalf("diaries = %+v, want 1", res.Results)
+	}
+
+	if reqs := gpt.Requests(); len(reqs) != 1 || reqs[0].LastUserMessage() == "" {
+		t.Errorf("gpt requests = %+v, want 1", reqs)
+	}
+
+	diary := res.Results[0]
+	if diary.Title != "FATHER" || len(diary.Tags) != 2 || diary.Tags[1] != "design" {
+		t.Errorf("diary = %+v", diary)
+	}
+}
+
+func TestExecuteWorkFlowVersion(t *testing.T) {
+	gpt := tmockgpt.NewServer(tmockgpt.On(tmockgpt.FunctionIs("weaviate"),
+		tmockgpt.FunctionCall("weaviate", `{"action":"1","title":"Father","body":"完成了Father的初步设计","tags":"father,design","user":"someone","date":"2023-08-01"}`)))
+	defer gpt.Close()
+	t.Setenv(types.PluginGPTURL, gpt.URL)
+
+	tests := []struct {
+		name    string
+		version int
+		title   string
+	}{
+		// version 2 pins the gpt plugin of version 1
+		{name: "current", title: "father"},
+		{name: "pinned", version: 2, title: "father"},
+		// version 1 runs the latest gpt plugin, which has no implementation
+		{name: "latest plugin", version: 1},
+		{name: "unknown version", version: 9},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repos := driver.NewMemoryRepositories()
+			memory := repos.Workflow.(*driver.MemoryStore)
+			seedDiaryWorkflow(memory)
+
+			// the seeded plugins have no version, the working gpt plugin is saved as version 1
+			gptPlugins, _ := memory.GetPluginByPluginKey(context.Background(), 2)
+			memory.SavePluginVersion(context.Background(), &gptPlugins[0])
+			memory.SavePluginVersion(context.Background(), &types.Plugin{PluginKey: 2, Name: "broken", Module: "gpt", Reference: types.PluginReference{Up: -1, Down: []int{1}}})
+			memory.UpdateWorkFlow(context.Background(), &types.WorkFlow{Name: "diary", Action: types.WorkFlowExecute, Steps: []types.WorkFlowStep{
+				{Name: "gpt", PluginKey: 2, PluginVersion: 1},
+				{Name: "store", PluginKey: 1, Input: map[string]interface{}{"user": "{{request.user}}", "title": "{{steps.gpt.output.title | lower}}"}},
+			}})
+
+			service := NewWorkFlowService(repos, "test")
+			result, err := service.ExecuteWorkFlow(context.Background(), "1", types.WorkFlowRequest{User: "tester", Question: "记录", Version: tt.version})
+			if tt.title == "" {
+				if err == nil {
+					t.Fatalf("execute version %d succeeded", tt.version)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ExecuteWorkFlow error: %v", err)
+			}
+			if result.Version != 2 {
+				t.Errorf("version = %d, want 2", result.Version)
+			}
+
+			res, _ := repos.Diary.QueryDiary(context.Background(), types.DirayQueryModel{User: "tester"})
+			if len(res.Results) != 1 || res.Results[0].Title != tt.title {
+				t.Errorf("diaries = %+v, want title %s", res.Results, tt.title)
+			}
+		})
+	}
+}
+
+func TestExecuteWorkFlowDryRun(t *testing.T) {
+	gpt := tmockgpt.NewServer(tmockgpt.On(tmockgpt.FunctionIs("weaviate"),
+		tmockgpt.FunctionCall("weaviate", `{"action":"1","title":"Father","body":"完成了Father的初步设计","tags":"father,design","user":"someone","date":"2023-08-01"}`)))
+	defer gpt.Close()
+	t.Setenv(types.PluginGPTURL, gpt.URL)
+
+	tests := []struct {
+		name     string
+		workflow string
+		req      types.WorkFlowRequest
+		intents  []string
+		trace    []string
+		diaries  int
+	}{
+		{name: "dry run", workflow: "1", req: types.WorkFlowRequest{DryRun: true}, intents: []string{"gpt:chat", "store:create"}},
+		{name: "step mode", workflow: "1", req: types.WorkFlowRequest{Step: true}, trace: []string{"gpt", "store"}, diaries: 1},
+		{name: "dry run with step mode", workflow: "1", req: types.WorkFlowRequest{DryRun: true, Step: true}, intents: []string{"gpt:chat", "store:create"}, trace: []string{"gpt", "store"}},
+		{name: "dry run approval", workflow: "confirm", req: types.WorkFlowRequest{DryRun: true}, intents: []string{"gpt:chat", "confirm:approval", "store:create"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repos := driver.NewMemoryRepositories()
+			memory := repos.Workflow.(*driver.MemoryStore)
+			seedDiaryWorkflow(memory)
+			memory.SaveWorkFlow(context.Background(), &types.WorkFlow{ID: "confirm", Name: "confirm", Action: types.WorkFlowExecute, Steps: []types.WorkFlowStep{
+				{Name: "gpt", PluginKey: 2},
+				{Name: "confirm", Kind: types.StepKindApproval, Message: "保存 {{steps.gpt.output.title}}?"},
+				{Name: "store", PluginKey: 1, Input: map[string]interface{}{"user": "{{request.user}}"}},
+			}})
+
+			req := tt.req
+			req.User, req.Question = "tester", "记录"
+			result, err := NewWorkFlowService(repos, "test").ExecuteWorkFlow(context.Background(), tt.workflow, req)
+			if err != nil {
+				t.Fatalf("ExecuteWorkFlow error: %v", err)
+			}
+			if result.Status != types.RunStatusCompleted || result.DryRun != req.DryRun {
+				t.Errorf("result = %+v, want completed", result)
+			}
+
+			var intents, trace []string
+			for _, i := range result.Intents {
+				intents = append(intents, i.Step+":"+i.Action)
+			}
+			for _, s := range result.Trace {
+				trace = append(trace, s.Step)
+				if len(s.Context[types.ScopePlugin]) == 0 {
+					t.Errorf("context of step %s is empty", s.Step)
+				}
+			}
+			if fmt.Sprint(intents) != fmt.Sprint(tt.intents) || fmt.Sprint(trace) != fmt.Sprint(tt.trace) {
+				t.Errorf("intents = %v, trace = %v, want %v and %v", intents, trace, tt.intents, tt.trace)
+			}
+
+			res, _ := repos.Diary.QueryDiary(context.Background(), types.DirayQueryModel{User: "tester"})
+			if len(res.Results) != tt.diaries {
+				t.Errorf("diaries = %d, want %d", len(res.Results), tt.diaries)
+			}
+		})
+	}
+}
+
+func TestExecuteWorkFlowNotFound(t *testing.T) {
+	service := NewWorkFlowService(driver.NewMemoryRepositories(), "test")
+	if _, err := service.ExecuteWorkFlow(context.Background(), "404", types.WorkFlowRequest{User: "tester"}); err == nil {
+		t.Error("ExecuteWorkFlow with unknown workflow should fail")
+	}
+}
+
+func TestExecuteWorkFlowApproval(t *testing.T) {
+	gpt := tmockgpt.NewServer(tmockgpt.On(tmockgpt.FunctionIs("weaviate"),
+		tmockgpt.FunctionCall("weaviate", `{"action":"1","title":"Father","body":"完成了Father的初步设计","tags":"father,design","user":"someone","date":"2023-08-01"}`)))
+	defer gpt.Close()
+	t.Setenv(types.PluginGPTURL, gpt.URL)
+
+	repos := driver.NewMemoryRepositories()
+	memory := repos.Workflow.(*driver.MemoryStore)
+	seedDiaryWorkflow(memory)
+	memory.SaveWorkFlow(context.Background(), &types.WorkFlow{ID: "confirm", Name: "confirm", Action: types.WorkFlowExecute, Steps: []types.WorkFlowStep{
+		{Name: "gpt", PluginKey: 2},
+		{Name: "confirm", Kind: types.StepKindApproval, Message: "保存 {{steps.gpt.output.title}}?"},
+		{Name: "store", PluginKey: 1, Input: map[string]interface{}{
+			"user":  "{{request.user}}",
+			"title": "{{steps.gpt.output.title | upper}}",
+		}},
+	}})
+
+	execute := func() *types.Result {
+		result, err := NewWorkFlowService(repos, "test").ExecuteWorkFlow(context.Background(), "confirm", types.WorkFlowRequest{User: "tester", Question: "记录"})
+		if err != nil {
+			t.Fatalf("ExecuteWorkFlow error: %v", err)
+		}
+		if result.Status != types.RunStatusPending || result.Token == "" || result.Message != "保存 Father?" {
+			t.Fatalf("result = %+v, want pending with token", result)
+		}
+		return result
+	}
+
+	diaries := func() int {
+		res, _ := repos.Diary.QueryDiary(context.Background(), types.DirayQueryModel{User: "tester"})
+		return len(res.Results)
+	}
+
+	pending := execute()
+	if diaries() != 0 {
+		t.Fatalf("the diary is stored before approval")
+	}
+
+	// the steps run before the pause are counted after the run is resumed
+	run, err := repos.Run.GetRun(context.Background(), pending.Token)
+	if err != nil {
+		t.Fatalf("GetRun error: %v", err)
+	}
+	var state runState
+	if err := json.Unmarshal([]byte(run.State), &state); err != nil || state.Runs != 1 {
+		t.Errorf("state runs = %d, %v, want 1", state.Runs, err)
+	}
+
+	if _, err := NewWorkFlowService(repos, "test").ResumeWorkFlow(context.Background(), "someone", pending.Token, true); !errors.Is(err, ErrRunNotFound) {
+		t.Errorf("resume by another user error = %v, want ErrRunNotFound", err)
+	}
+
+	// the run is resumed by another request
+	result, err := NewWorkFlowService(repos, "test").ResumeWorkFlow(context.Background(), "tester", pending.Token, true)
+	if err != nil {
+		t.Fatalf("ResumeWorkFlow error: %v", err)
+	}
+	if result.Status != types.RunStatusCompleted || result.StepResults["gpt"] == nil || result.StepResults["store"] == nil {
+		t.Errorf("result = %+v, want completed gpt and store", result)
+	}
+	if diaries() != 1 {
+		t.Errorf("diaries = %d after approval, want 1", diaries())
+	}
+
+	if _, err := NewWorkFlowService(repos, "test").ResumeWorkFlow(context.Background(), "tester", pending.Token, true); !errors.Is(err, ErrRunFinished) {
+		t.Errorf("resume twice error = %v, want ErrRunFinished", err)
+	}
+
+	rejected, err := NewWorkFlowService(repos, "test").ResumeWorkFlow(context.Background(), "tester", execute().Token, false)
+	if err != nil || rejected.Status != types.RunStatusRejected {
+		t.Errorf("reject = %+v, %v, want rejected", rejected, err)
+	}
+	if diaries() != 1 {
+		t.Errorf("diaries = %d after rejection, want 1", diaries())
+	}
+
+	if _, err := NewWorkFlowService(repos, "test").ResumeWorkFlow(context.Background(), "tester", "unknown", true); !errors.Is(err, ErrRunNotFound) {
+		t.Errorf("unknown token error = %v, want ErrRunNotFound", err)
+	}
+}
+
+func TestExecuteWorkFlowApprovalMessageError(t *testing.T) {
+	repos := driver.NewMemoryRepositories()
+	memory := repos.Workflow.(*driver.MemoryStore)
+	seedDiaryWorkflow(memory)
+	memory.SaveWorkFlow(context.Background(), &types.WorkFlow{ID: "confirm", Name: "confirm", Action: types.WorkFlowExecute, Steps: []types.WorkFlowStep{
+		{Name: "confirm", Kind: types.StepKindApproval, Message: "保存 {{steps.gpt.output.title}}?"},
+	}, OnFailure: []types.WorkFlowStep{{Name: "notify", PluginKey: 1, Input: map[string]interface{}{
+		"action": "1", "title": "{{error.step}}", "body": "{{error.message}}", "tags": "failure", "user": "{{request.user}}", "date": "2023-08-01",
+	}}}})
+
+	result, err := NewWorkFlowService(repos, "test").ExecuteWorkFlow(context.Background(), "confirm", types.WorkFlowRequest{User: "tester"})
+	if err == nil || result == nil || result.Status != types.RunStatusFailed || result.Token != "" {
+		t.Fatalf("result = %+v, error = %v, want failed", result, err)
+	}
+
+	res, _ := repos.Diary.QueryDiary(context.Background(), types.DirayQueryModel{User: "tester"})
+	if len(res.Results) != 1 || res.Results[0].Title != "confirm" {
+		t.Errorf("on_failure diaries = %+v, want the approval step", res.Results)
+	}
+}
+
+func TestExecuteWorkFlowCancelled(t *testing.T) {
+	// the gpt server never answers, the request must be cancelled by the workflow
+	gpt := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer gpt.Close()
+	t.Setenv(types.PluginGPTURL, gpt.URL)
+
+	repos := driver.NewMemoryRepositories()
+	seedDiaryWorkflow(repos.Workflow.(*driver.MemoryStore))
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	timeout, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want error
+	}{
+		{name: "cancelled", ctx: cancelled, want: context.Canceled},
+		{name: "timeout", ctx: timeout, want: context.DeadlineExceeded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewWorkFlowService(repos, "test").ExecuteWorkFlow(tt.ctx, "1", types.WorkFlowRequest{User: "tester", Question: "记录"})
+			if !errors.Is(err, tt.want) {
+				t.Errorf("ExecuteWorkFlow error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	if res, _ := repos.Diary.QueryDiary(context.Background(), types.DirayQueryModel{User: "tester"}); len(res.Results) != 0 {
+		t.Errorf("the cancelled workflow stored %d diaries", len(res.Results))
+	}
+}
+
+func TestExecuteWorkFlowBranches(t *testing.T) {
+	gpt := tmockgpt.NewServer(tmockgpt.On(tmockgpt.FunctionIs("weaviate"),
+		tmockgpt.FunctionCall("weaviate", `{"action":"1","title":"Tasks","body":"","tags":"todo","user":"tester","date":"2023-08-01","tasks":["design","review"]}`)))
+	defer gpt.Close()
+	t.Setenv(types.PluginGPTURL, gpt.URL)
+
+	store := func(max int) []types.WorkFlowStep {
+		return []types.WorkFlowStep{
+			{Name: "gpt", PluginKey: 2},
+			{Name: "route", Kind: types.StepKindSwitch, Switch: "{{steps.gpt.output._function}}", Cases: map[string][]types.WorkFlowStep{
+				"weaviate": {{
+					Name: "tasks", Kind: types.StepKindForeach, Foreach: "{{steps.gpt.output.tasks}}", As: "task", MaxIterations: max,
+					Do: []types.WorkFlowStep{{
+						Name: "has_task", Kind: types.StepKindCondition, If: `{{task | ne ""}}`,
+						Then: []types.WorkFlowStep{{Name: "store", PluginKey: 1, Input: map[string]interface{}{
+							"action": "1",
+							"title":  "{{steps.gpt.output.title}} {{index}}",
+							"body":   "{{task}}",
+							"tags":   "{{steps.gpt.output.tags}}",
+							"user":   "{{request.user}}",
+							"date":   "{{steps.gpt.output.date}}",
+						}}},
+					}},
+				}},
+			}},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		max     int
+		diaries int
+		wantErr bool
+	}{
+		{name: "foreach", diaries: 2},
+		{name: "max iterations", max: 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repos := driver.NewMemoryRepositories()
+			memory := repos.Workflow.(*driver.MemoryStore)
+			seedDiaryWorkflow(memory)
+			memory.SaveWorkFlow(context.Background(), &types.WorkFlow{ID: "tasks", Name: "tasks", Action: types.WorkFlowExecute, Steps: store(tt.max)})
+
+			result, err := NewWorkFlowService(repos, "test").ExecuteWorkFlow(context.Background(), "tasks", types.WorkFlowRequest{User: "tester", Question: "今天的任务: design, review"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ExecuteWorkFlow error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if _, ok := result.StepResults["store[1]"]; !ok {
+				t.Errorf("step results = %+v, want store[0] and store[1]", result.StepResults)
+			}
+
+			res, err := repos.Diary.QueryDiary(context.Background(), types.DirayQueryModel{User: "tester"})
+			if err != nil {
+				t.Fatalf("QueryDiary error: %v", err)
+			}
+			if len(res.Results) != tt.diaries {
+				t.Errorf("diaries = %+v, want %d", res.Results, tt.diaries)
+			}
+		})
+	}
+}
+
+func TestExecuteWorkFlowRetry(t *testing.T) {
+	diary := tmockgpt.FunctionCall("weaviate", `{"action":"1","title":"Father","body":"完成了Father的初步设计","tags":"father,design","user":"someone","date":"2023-08-01"}`)
+	notify := []types.WorkFlowStep{{Name: "notify", PluginKey: 1, Input: map[string]interface{}{
+		"action": "1", "title": "{{error.step}}", "body": "{{error.class}}", "tags": "failure", "user": "{{request.user}}", "date": "2023-08-01",
+	}}}
+
+	tests := []struct {
+		name      string
+		responses []tmockgpt.Response
+		step      types.WorkFlowStep
+		status    string
+		attempts  []string
+		diaries   int
+		// failed is the step result key of the error the step continued on
+		failed string
+	}{
+		{
+			name:      "retry rate limit",
+			responses: []tmockgpt.Response{tmockgpt.RateLimit(), tmockgpt.RateLimit(), diary},
+			step:      types.WorkFlowStep{Name: "gpt", PluginKey: 2, Retry: &types.RetryPolicy{Attempts: 3, Backoff: 1, On: []string{types.ErrorClassRateLimit}}},
+			status:    types.RunStatusCompleted,
+			attempts:  []string{types.ErrorClassRateLimit, types.ErrorClassRateLimit, "", ""},
+			diaries:   1,
+		},
+		{
+			name:      "give up and run on_failure",
+			responses: []tmockgpt.Response{tmockgpt.Error(http.StatusBadGateway, "bad gateway")},
+			step:      types.WorkFlowStep{Name: "gpt", PluginKey: 2, Retry: &types.RetryPolicy{Attempts: 2}},
+			status:    types.RunStatusFailed,
+			attempts:  []string{types.ErrorClassServer, types.ErrorClassServer, ""},
+			diaries:   1,
+		},
+		{
+			name:      "no retry of other classes",
+			responses: []tmockgpt.Response{tmockgpt.Error(http.StatusBadRequest, "bad request")},
+			step:      types.WorkFlowStep{Name: "gpt", PluginKey: 2, Retry: &types.RetryPolicy{Attempts: 3, On: []string{types.ErrorClassRateLimit}}},
+			status:    types.RunStatusFailed,
+			attempts:  []string{types.ErrorClassPlugin, ""},
+			diaries:   1,
+		},
+		{
+			name:      "fallback",
+			responses: []tmockgpt.Response{tmockgpt.Error(http.StatusInternalServerError, "down")},
+			step: types.WorkFlowStep{Name: "gpt", PluginKey: 2, Fallback: []types.WorkFlowStep{{Name: "fallback", PluginKey: 1, Input: map[string]interface{}{
+				"action": "1", "title": "fallback", "body": "", "tags": "todo", "user": "{{request.user}}", "date": "2023-08-01",
+			}}}},
+			status:   types.RunStatusCompleted,
+			attempts: []string{types.ErrorClassServer, "", ""},
+			diaries:  2,
+		},
+		{
+			name:      "continue on error",
+			responses: []tmockgpt.Response{tmockgpt.Error(http.StatusInternalServerError, "down")},
+			step:      types.WorkFlowStep{Name: "gpt", PluginKey: 2, ContinueOnError: true},
+			status:    types.RunStatusCompleted,
+			attempts:  []string{types.ErrorClassServer, ""},
+			diaries:   1,
+			failed:    "gpt",
+		},
+		{
+			name:      "continue on error without name",
+			responses: []tmockgpt.Response{tmockgpt.Error(http.StatusInternalServerError, "down")},
+			step:      types.WorkFlowStep{PluginKey: 2, ContinueOnError: true},
+			status:    types.RunStatusCompleted,
+			attempts:  []string{types.ErrorClassServer, ""},
+			diaries:   1,
+			failed:    "weaviate-function-calling",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gpt := tmockgpt.NewServer(tmockgpt.On(tmockgpt.FunctionIs("weaviate"), tt.responses...))
+			defer gpt.Close()
+			t.Setenv(types.PluginGPTURL, gpt.URL)
+
+			repos := driver.NewMemoryRepositories()
+			memory := repos.Workflow.(*driver.MemoryStore)
+			seedDiaryWorkflow(memory)
+			// the step after the one continued on error reads its error class
+			body := ""
+			if tt.failed != "" {
+				body = "{{steps." + tt.failed + ".class}}"
+			}
+			memory.SaveWorkFlow(context.Background(), &types.WorkFlow{ID: "retry", Name: "retry", Action: types.WorkFlowExecute, Steps: []types.WorkFlowStep{
+				tt.step,
+				{Name: "store", PluginKey: 1, Input: map[string]interface{}{
+					"action": "1", "title": "done", "body": body, "tags": "todo", "user": "{{request.user}}", "date": "2023-08-01",
+				}},
+			}, OnFailure: notify})
+
+			result, err := NewWorkFlowService(repos, "test").ExecuteWorkFlow(context.Background(), "retry", types.WorkFlowRequest{User: "tester", Question: "记录"})
+			if (err != nil) != (tt.status == types.RunStatusFailed) {
+				t.Fatalf("ExecuteWorkFlow error = %v, want status %s", err, tt.status)
+			}
+			if result.Status != tt.status {
+				t.Errorf("status = %s, want %s", result.Status, tt.status)
+			}
+
+			var classes []string
+			for _, a := range result.Attempts {
+				classes = append(classes, a.Class)
+			}
+			if fmt.Sprint(classes) != fmt.Sprint(tt.attempts) {
+				t.Errorf("attempts = %+v, want classes %v", result.Attempts, tt.attempts)
+			}
+
+			res, _ := repos.Diary.QueryDiary(context.Background(), types.DirayQueryModel{User: "tester"})
+			if len(res.Results) != tt.diaries {
+				t.Errorf("diaries = %+v, want %d", res.Results, tt.diaries)
+			}
+			if tt.failed != "" {
+				failure, _ := result.StepResults[tt.failed].(map[string]interface{})
+				if failure["class"] != types.ErrorClassServer || len(res.Results) != 1 || res.Results[0].Content != types.ErrorClassServer {
+					t.Errorf("step results = %+v, diaries = %+v, want the error of %s", result.StepResults, res.Results, tt.failed)
+				}
+			}
+			if tt.status == types.RunStatusFailed && (len(res.Results) == 0 || res.Results[0].Title != "gpt") {
+				t.Errorf("on_failure diaries = %+v, want the failed step", res.Results)
+			}
+		})
+	}
+}
@@ -1,37 +1,38 @@
 package workflow
 
 import (
+	"context"
+	"errors"
+	"fmt"
+
 	"github.com/andy-zhangtao/Functions/driver"
 	"github.com/andy-zhangtao/Functions/types"
-	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
-	"go.mongodb.org/mongo-driver/bson"
+)
+
+var (
+	// ErrWorkFlowExists is returned when creating a workflow whose name is used by the user
+	ErrWorkFlowExists = errors.New("workflow already exists")
+	// ErrWorkFlowNotFound is returned when the workflow of the user does not exist
+	ErrWorkFlowNotFound = errors.New("workflow not found")
+	// ErrVersionNotFound is returned when the version of the workflow does not exist
+	ErrVersionNotFound = errors.New("workflow version not found")
+	// ErrWorkFlowConflict is returned when a concurrent update made a later version current
+	ErrWorkFlowConflict = errors.New("workflow was updated concurrently")
 )
 
 type WorkFlow struct {
-	weaviate *driver.WeaviateClient
-	mongoCli *driver.MongoCli
-	traceId  string
+	repo    driver.WorkflowRepository
+	plugins driver.PluginRepository
+	traceId string
 }
 
-func NewWorkFlow(wc driver.WeaviateClientConf, mc driver.MongoCliConf, traceId string) (*WorkFlow, error) {
-	weaviateClient, err := driver.NewWeaviateClient(wc)
-	if err != nil {
-		return nil, errors.WithMessage(err, "new weaviate client error")
-	}
-
-	mongoCli, err := driver.NewMongoCli(mc)
-	if err != nil {
-		return nil, errors.WithMessage(err, "new mongo client error")
-	}
-
-	logrus.SetLevel(logrus.InfoLevel)
-
+func NewWorkFlow(repo driver.WorkflowRepository, plugins driver.PluginRepository, traceId string) *WorkFlow {
 	return &WorkFlow{
-		weaviate: weaviateClient,
-		mongoCli: mongoCli,
-		traceId:  traceId,
-	}, nil
+		repo:    repo,
+		plugins: plugins,
+		traceId: traceId,
+	}
 }
 
 func (wf *WorkFlow) error(format string, args ...interface{}) {
@@ -46,30 +47,240 @@ func (wf *WorkFlow) log(format string, args ...interface{}) {
 	logrus.Infof(format, args...)
 }
 
-func (wf *WorkFlow) NewWorkFlow(flow types.WorkFlowModel) (err error) {
-	// 1. save to mongo
-	return wf.mongoCli.SaveDataToMongo(flow)
+// NewWorkFlow saves the workflow and fills its ID, the workflow is executable if the Action is empty
+func (wf *WorkFlow) NewWorkFlow(ctx context.Context, flow *types.WorkFlow) (err error) {
+	if err := wf.validate(ctx, flow); err != nil {
+		return err
+	}
+
+	exist, err := wf.repo.FindWorkFlow(ctx, flow.Name, flow.User)
+	if err != nil {
+		wf.error("find workflow %s of %s error: %v", flow.Name, flow.User, err)
+		return err
+	}
+	if exist != nil {
+		return ErrWorkFlowExists
+	}
+
+	if flow.Action == "" {
+		flow.Action = types.WorkFlowExecute
+	}
+
+	// 1. save to mongo, a concurrent create of the name is rejected by the store
+	err = wf.repo.SaveWorkFlow(ctx, flow)
+	if errors.Is(err, driver.ErrExists) {
+		return ErrWorkFlowExists
+	}
+	return err
+}
+
+// FindWorkFlow returns ErrWorkFlowNotFound if the workflow does not exist
+func (wf *WorkFlow) FindWorkFlow(ctx context.Context, name, user string) (*types.WorkFlow, error) {
+	flow, err := wf.repo.FindWorkFlow(ctx, name, user)
+	if err != nil {
+		return nil, err
+	}
+	if flow == nil {
+		return nil, ErrWorkFlowNotFound
+	}
+	return flow, nil
+}
+
+// FindWorkFlowVersion returns the version of the workflow, the current version if version is 0
+func (wf *WorkFlow) FindWorkFlowVersion(ctx context.Context, name, user string, version int) (*types.WorkFlow, error) {
+	flow, err := wf.FindWorkFlow(ctx, name, user)
+	if err != nil || version == 0 {
+		return flow, err
+	}
+
+	flow, err = wf.repo.GetWorkFlowVersion(ctx, flow.ID, version)
+	if errors.Is(err, driver.ErrNotFound) {
+		return nil, ErrVersionNotFound
+	}
+	return flow, err
+}
+
+// Versions returns all the versions of the workflow, the latest first
+func (wf *WorkFlow) Versions(ctx context.Context, name, user string) ([]*types.WorkFlow, error) {
+	flow, err := wf.FindWorkFlow(ctx, name, user)
+	if err != nil {
+		return nil, err
+	}
+
+	versions, err := wf.repo.ListWorkFlowVersions(ctx, flow.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	flows := make([]*types.WorkFlow, len(versions))
+	for i := range versions {
+		flows[i] = &versions[i]
+	}
+	return flows, nil
 }
 
-func (wf *WorkFlow) FindWorkFlow(name, user string) (*types.WorkFlowModel, error) {
-	// 1. find from mongo
-	flow := &types.WorkFlowModel{}
-	filter := bson.M{"name": name, "user": user}
-	found, err := wf.mongoCli.FindWorkFlow(filter, flow)
+// Rollback makes the version of the workflow current again, no version is saved.
+// The version is validated first, the plugins it refers to may be deleted.
+func (wf *WorkFlow) Rollback(ctx context.Context, name, user string, version int) (*types.WorkFlow, error) {
+	if version <= 0 {
+		return nil, errors.New("rollback needs a version")
+	}
+
+	flow, err := wf.FindWorkFlowVersion(ctx, name, user, version)
 	if err != nil {
 		return nil, err
 	}
+	if err := wf.validate(ctx, flow); err != nil {
+		return nil, err
+	}
 
-	if found {
-		return flow, nil
+	wf.log("rollback workflow %s of %s to version %d", name, user, version)
+	flow, err = wf.repo.RollbackWorkFlow(ctx, name, user, version)
+	if errors.Is(err, driver.ErrNotFound) {
+		return nil, ErrVersionNotFound
 	}
+	return flow, err
+}
 
-	return nil, nil
+// UpdateWorkFlow saves the workflow with the same name of the user as the next version
+func (wf *WorkFlow) UpdateWorkFlow(ctx context.Context, flow *types.WorkFlow) error {
+	if err := wf.validate(ctx, flow); err != nil {
+		return err
+	}
+
+	if flow.Action == "" {
+		flow.Action = types.WorkFlowExecute
+	}
+
+	err := wf.repo.UpdateWorkFlow(ctx, flow)
+	if errors.Is(err, driver.ErrNotFound) {
+		return ErrWorkFlowNotFound
+	}
+	if errors.Is(err, driver.ErrConflict) {
+		return fmt.Errorf("%w: version %d is saved but not current", ErrWorkFlowConflict, flow.Version)
+	}
+	return err
 }
 
-func (wf *WorkFlow) FindAllWorkFlows(user string) ([]*types.WorkFlowModel, error) {
-	var flows []*types.WorkFlowModel
-	filter := bson.M{"user": user}
-	err := wf.mongoCli.FindAllWorkFlows(filter, flows)
-	return flows, err
+func (wf *WorkFlow) DeleteWorkFlow(ctx context.Context, name, user string) error {
+	err := wf.repo.DeleteWorkFlow(ctx, name, user)
+	if errors.Is(err, driver.ErrNotFound) {
+		return ErrWorkFlowNotFound
+	}
+	return err
+}
+
+// validate checks the required fields and that every step refers to an existing plugin
+func (wf *WorkFlow) validate(ctx context.Context, flow *types.WorkFlow) error {
+	if flow.Name == "" {
+		return errors.New("workflow name is empty")
+	}
+	if flow.User == "" {
+		return errors.New("workflow user is empty")
+	}
+
+	if len(flow.Steps) == 0 {
+		return errors.New("workflow has no step")
+	}
+
+	if flow.Timeout < 0 {
+		return errors.New("workflow has negative timeout")
+	}
+
+	if err := validateSteps(flow.Steps, true); err != nil {
+		return fmt.Errorf("workflow %s: %w", flow.Name, err)
+	}
+
+	if err := validateSteps(flow.OnFailure, false); err != nil {
+		return fmt.Errorf("workflow %s on_failure: %w", flow.Name, err)
+	}
+
+	for _, t := range flow.Triggers {
+		if !knownEvent(t.Event) {
+			return fmt.Errorf("workflow %s has unknown trigger event %s", flow.Name, t.Event)
+		}
+	}
+
+	for _, key := range flow.PluginKeys() {
+		plugins, err := wf.plugins.GetPluginByPluginKey(ctx, key)
+		if err != nil {
+			wf.error("get plugin %d error: %v", key, err)
+			return err
+		}
+		if len(plugins) == 0 {
+			return fmt.Errorf("plugin %d of workflow %s does not exist", key, flow.Name)
+		}
+	}
+
+	for _, s := range flow.PluginSteps() {
+		if s.PluginVersion == 0 {
+			continue
+		}
+		if _, err := wf.plugins.GetPluginVersion(ctx, s.PluginKey, s.PluginVersion); err != nil {
+			return fmt.Errorf("plugin %d version %d of workflow %s: %w", s.PluginKey, s.PluginVersion, flow.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (wf *WorkFlow) FindAllWorkFlows(ctx context.Context, user string) ([]*types.WorkFlow, error) {
+	return wf.repo.FindWorkFlows(ctx, user)
+}
+
+// validateSteps checks the required fields of every kind of step, top is true for the top level steps
+func validateSteps(steps []types.WorkFlowStep, top bool) error {
+	for i, s := range steps {
+		name := s.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i)
+		}
+
+		switch s.Kind {
+		case "", types.StepKindPlugin:
+			if s.PluginKey == 0 {
+				return fmt.Errorf("plugin step %s has no plugin key", name)
+			}
+			if s.PluginVersion < 0 {
+				return fmt.Errorf("plugin step %s has negative plugin version", name)
+			}
+		case types.StepKindCondition:
+			if s.If == "" {
+				return fmt.Errorf("condition step %s has no if", name)
+			}
+		case types.StepKindSwitch:
+			if s.Switch == "" || len(s.Cases) == 0 {
+				return fmt.Errorf("switch step %s needs switch and cases", name)
+			}
+		case types.StepKindForeach:
+			if s.Foreach == "" || len(s.Do) == 0 {
+				return fmt.Errorf("foreach step %s needs foreach and do", name)
+			}
+			if s.MaxIterations < 0 {
+				return fmt.Errorf("foreach step %s has negative max iterations", name)
+			}
+		case types.StepKindApproval:
+			if !top {
+				return fmt.Errorf("approval step %s must be a top level step, the paused run can't resume inside a condition, switch, foreach or fallback step", name)
+			}
+			if s.Expires < 0 {
+				return fmt.Errorf("approval step %s has negative expires", name)
+			}
+		default:
+			return fmt.Errorf("step %s has unknown kind %s", name, s.Kind)
+		}
+
+		if s.Timeout < 0 {
+			return fmt.Errorf("step %s has negative timeout", name)
+		}
+
+		if err := validateRetry(s.Retry); err != nil {
+			return fmt.Errorf("step %s: %w", name, err)
+		}
+
+		if err := validateSteps(s.Children(), false); err != nil {
+			return err
+		}
+	}
+	return nil
 }